@@ -3,59 +3,41 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io"
 	"log"
-	"net"
 	"os"
 	"path"
 	"path/filepath"
 	"strconv"
+	"sync/atomic"
 
 	smpp "github.com/blorticus/smpp-go"
 )
 
-func sendPDU(conn net.Conn, pdu *smpp.PDU, logger *log.Logger) {
-	encoded, err := pdu.Encode()
-
-	if err != nil {
-		logger.Fatalln("Failed to encode PDU: ", err)
-	}
-
-	_, err = conn.Write(encoded)
-
-	if err != nil {
-		logger.Fatalln("Failed to write: ", err)
-	}
+// submitHandler answers each submit_sm with a new message_id
+type submitHandler struct {
+	smpp.BaseSMSCHandler
+	logger *log.Logger
+	msgID  uint32
 }
 
-func recvPDU(conn net.Conn, buf *[]byte, logger *log.Logger) *smpp.PDU {
-	octets, err := conn.Read(*buf)
-
-	if err != nil {
-		if err == io.EOF {
-			return nil
-		}
-
-		logger.Fatalln("Failed on read: ", err)
-	}
-
-	logger.Printf("Read (%d) octets", octets)
+func (handler *submitHandler) OnSubmitSm(session *smpp.SMSCSession, pdu *smpp.PDU) *smpp.Response {
+	msgID := atomic.AddUint32(&handler.msgID, 1)
 
-	pdu, err := smpp.DecodePDU(*buf)
+	handler.logger.Printf("Received submit-sm from (%s) at (%s)", session.Bind.SystemID, session.Bind.RemoteAddr)
 
-	if err != nil {
-		logger.Fatalln("Failed to encode incoming PDU: ", err)
-	}
-
-	return pdu
+	return &smpp.Response{MessageID: fmt.Sprintf("msg-%d", msgID)}
 }
 
 func main() {
 	var bindIP string
 	var bindPort int
+	var logJSON bool
+	var logPDUs bool
 
 	flag.StringVar(&bindIP, "addr", "127.0.0.1", "Listener IP")
 	flag.IntVar(&bindPort, "port", 2775, "Listener TCP port")
+	flag.BoolVar(&logJSON, "json", false, "Log session activity as JSON lines on stdout")
+	flag.BoolVar(&logPDUs, "pdus", false, "Log every PDU sent and received")
 
 	os.Args[0] = path.Base(os.Args[0])
 
@@ -65,73 +47,22 @@ func main() {
 
 	logger := log.New(os.Stderr, filepath.Base(os.Args[0])+": ", 0)
 
-	logger.Println("Starting listener")
-
-	listener, err := net.Listen("tcp", bindAddr)
-
-	if err != nil {
-		logger.Fatalln("Failed to start listener: ", err)
-	}
-
-	defer listener.Close()
-
-	conn, err := listener.Accept()
+	smsc := smpp.NewSMSC("smsc01", nil, &submitHandler{logger: logger})
 
-	if err != nil {
-		logger.Fatalln("Failed to Accept incoming socket: ", err)
+	var eventLogger *smpp.EventLogger
+	if logJSON {
+		eventLogger = smpp.NewEventLogger(smpp.NewJSONLinesLogger(os.Stdout))
+	} else {
+		eventLogger = smpp.NewEventLogger(smpp.NewStandardLogger(logger))
 	}
-
-	defer conn.Close()
-
-	logger.Println("Accepted incoming connection from: ", conn.RemoteAddr().String())
-
-	readbuf := make([]byte, 4096)
-
-	logger.Println("Waiting for bind-transmitter from peer")
-
-	receivedPDU := recvPDU(conn, &readbuf, logger)
-
-	if receivedPDU.CommandID != smpp.CommandBindTransmitter {
-		logger.Fatalf("Expected bind_transmitter from peer, but received (%08x)\n", receivedPDU.CommandID)
+	if logPDUs {
+		eventLogger.SetMinimumLevel(smpp.LevelDebug)
 	}
+	smsc.SetEventLogger(eventLogger)
 
-	logger.Println("bind-transmitter received; sending bind-transmitter-resp")
-
-	seqNum := receivedPDU.SequenceNumber
-
-	responsePDU := smpp.NewPDU(smpp.CommandBindTransmitterResp, 0, seqNum, []*smpp.Parameter{
-		smpp.NewCOctetStringParameter("smsc01"), // system_id
-	}, []*smpp.Parameter{})
-
-	sendPDU(conn, responsePDU, logger)
-
-	logger.Println("Responded with transmitter_resp")
-
-	logger.Println("Entering listen loop")
-
-	msgID := uint32(1)
-	for {
-		pdu := recvPDU(conn, &readbuf, logger)
-
-		if pdu == nil {
-			logger.Println("Peer closed connection")
-			break
-		}
-
-		logger.Printf("Received PDU from peer, type is (%s)", pdu.CommandName())
-
-		switch pdu.CommandID {
-		case smpp.CommandSubmitSm:
-			responsePDU = smpp.NewPDU(smpp.CommandSubmitSmResp, 0, pdu.SequenceNumber, []*smpp.Parameter{
-				smpp.NewCOctetStringParameter(fmt.Sprintf("msg-%d", msgID)),
-			}, []*smpp.Parameter{})
-			msgID++
+	logger.Println("Starting listener")
 
-			sendPDU(conn, responsePDU, logger)
-		default:
-			logger.Println("No response for this message type")
-		}
+	if err := smsc.ListenAndServe(bindAddr); err != nil {
+		logger.Fatalln("Listener failed: ", err)
 	}
-
-	logger.Println("Done")
 }
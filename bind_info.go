@@ -10,7 +10,68 @@ const (
 	TransmitterBind
 )
 
-// BindInfo contains information for a bind
+// BindInfo contains information for a bind.  These correspond to the mandatory
+// parameters of the bind_transmitter, bind_receiver and bind_transceiver PDUs.  If
+// InterfaceVersion is zero, 0x34 (SMPP v3.4) is used
 type BindInfo struct {
-	Type BindType
+	Type             BindType
+	SystemID         string
+	Password         string
+	SystemType       string
+	InterfaceVersion uint8
+	AddrTon          uint8
+	AddrNpi          uint8
+	AddressRange     string
+}
+
+var bindTypeCommandID = map[BindType]CommandIDType{
+	TransceiverBind: CommandBindTransceiver,
+	ReceiverBind:    CommandBindReceiver,
+	TransmitterBind: CommandBindTransmitter,
+}
+
+// bindPDU produces the bind request PDU described by 'bind'
+func (bind *BindInfo) bindPDU(sequenceNumber uint32) *PDU {
+	interfaceVersion := bind.InterfaceVersion
+	if interfaceVersion == 0 {
+		interfaceVersion = 0x34
+	}
+
+	return NewPDU(bindTypeCommandID[bind.Type], 0, sequenceNumber, []*Parameter{
+		NewCOctetStringParameter(bind.SystemID),
+		NewCOctetStringParameter(bind.Password),
+		NewCOctetStringParameter(bind.SystemType),
+		NewFLParameter(interfaceVersion),
+		NewFLParameter(bind.AddrTon),
+		NewFLParameter(bind.AddrNpi),
+		NewCOctetStringParameter(bind.AddressRange),
+	}, []*Parameter{})
+}
+
+var bindTypeForCommandID = map[CommandIDType]BindType{
+	CommandBindTransceiver: TransceiverBind,
+	CommandBindReceiver:    ReceiverBind,
+	CommandBindTransmitter: TransmitterBind,
+}
+
+// bindInfoFromPDU extracts the BindInfo from a bind request PDU.  Parameters that are missing or have
+// the wrong type are left as zero values.
+func bindInfoFromPDU(pdu *PDU) BindInfo {
+	bind := BindInfo{Type: bindTypeForCommandID[pdu.CommandID]}
+
+	strings := []*string{&bind.SystemID, &bind.Password, &bind.SystemType}
+	octets := []*uint8{&bind.InterfaceVersion, &bind.AddrTon, &bind.AddrNpi}
+
+	for i, param := range pdu.MandatoryParameters {
+		switch {
+		case i < 3:
+			*strings[i], _ = param.Value.(string)
+		case i < 6:
+			*octets[i-3], _ = param.Value.(uint8)
+		case i == 6:
+			bind.AddressRange, _ = param.Value.(string)
+		}
+	}
+
+	return bind
 }
@@ -0,0 +1,238 @@
+package smpp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// ErrReceiverBindPool is returned by NewBindPool() when the bind type is ReceiverBind.  A pool spreads
+// requests across its binds, and a receiver cannot send them.
+var ErrReceiverBindPool = errors.New("BindPool binds must be transmitter or transceiver")
+
+// BalancingStrategy determines which bind of a BindPool carries each request
+type BalancingStrategy int
+
+const (
+	// RoundRobin sends each request on the next healthy bind in turn
+	RoundRobin BalancingStrategy = iota
+	// LeastOutstanding sends each request on the healthy bind with the fewest requests waiting for a
+	// response
+	LeastOutstanding
+)
+
+// PoolEndpoint is an SMSC address to which a BindPool keeps 'Binds' bound sessions.  If Dial is set,
+// each transport is connected by calling it (e.g., for TLS or a Unix domain socket), and Addr and Port
+// are not used; otherwise the transport is TCP to Addr and Port.
+type PoolEndpoint struct {
+	Addr  net.IP
+	Port  uint16
+	Dial  DialFunc
+	Binds int
+}
+
+// BindPool keeps a number of bound sessions from an ESME to one or more SMSC addresses, and spreads
+// requests across the healthy ones.  Each session is kept by a ManagedPeer, so a session that fails
+// (including one that the keepalive declares dead) is dropped from the pool until it is reconnected and
+// rebound.  The requests that the SMSCs send on every session (e.g., deliver_sm for delivery receipts
+// and MO messages) are passed to the single handler given to Start().
+type BindPool struct {
+	esme    *ESME
+	members []*ManagedPeer
+
+	mutex          sync.Mutex
+	strategy       BalancingStrategy
+	next           int
+	started        bool
+	stopListenLoop context.CancelFunc
+}
+
+// NewBindPool creates a BindPool that binds to each endpoint in 'endpoints' the number of times that it
+// specifies (at least once), using 'bind'.  Every session is created by 'esme', so its EventLogger,
+// Metrics and session configurator apply to the whole pool.  The bind type must be TransmitterBind or
+// TransceiverBind.  The pool does nothing until Start() is called.
+func NewBindPool(esme *ESME, bind BindInfo, endpoints []PoolEndpoint) (*BindPool, error) {
+	if bind.Type == ReceiverBind {
+		return nil, ErrReceiverBindPool
+	}
+
+	pool := &BindPool{esme: esme, members: make([]*ManagedPeer, 0, len(endpoints))}
+
+	for _, endpoint := range endpoints {
+		for i := 0; i == 0 || i < endpoint.Binds; i++ {
+			if endpoint.Dial != nil {
+				pool.members = append(pool.members, NewManagedPeerWithDialer(pool.esme, endpoint.Dial, bind))
+			} else {
+				pool.members = append(pool.members, NewManagedPeer(pool.esme, endpoint.Addr, endpoint.Port, bind))
+			}
+		}
+	}
+
+	return pool, nil
+}
+
+// ESME returns the ESME that creates the sessions of the pool
+func (pool *BindPool) ESME() *ESME {
+	return pool.esme
+}
+
+// SetBalancingStrategy sets how requests are spread across the binds.  The default is RoundRobin.
+func (pool *BindPool) SetBalancingStrategy(strategy BalancingStrategy) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	pool.strategy = strategy
+}
+
+// SetReconnectPolicy sets the delays between connection attempts for every bind
+func (pool *BindPool) SetReconnectPolicy(policy ReconnectPolicy) {
+	for _, member := range pool.members {
+		member.SetReconnectPolicy(policy)
+	}
+}
+
+// SetSessionConfigurator sets a function that is called with each new session of every bind, after
+// the transport is connected and before the bind is sent
+func (pool *BindPool) SetSessionConfigurator(configure func(peer *Peer)) {
+	for _, member := range pool.members {
+		member.SetSessionConfigurator(configure)
+	}
+}
+
+// AddObserver adds a function that is called for each lifecycle event of every bind
+func (pool *BindPool) AddObserver(observer LifecycleObserver) {
+	for _, member := range pool.members {
+		member.AddObserver(observer)
+	}
+}
+
+// Members returns the ManagedPeer that keeps each bind, in the order of the endpoints
+func (pool *BindPool) Members() []*ManagedPeer {
+	return append([]*ManagedPeer(nil), pool.members...)
+}
+
+// HealthyPeers returns the sessions that are currently bound and able to carry requests
+func (pool *BindPool) HealthyPeers() []*Peer {
+	peers := make([]*Peer, 0, len(pool.members))
+
+	for _, member := range pool.members {
+		if peer := member.Peer(); peer != nil && transmitterStates.contains(peer.State()) {
+			peers = append(peers, peer)
+		}
+	}
+
+	return peers
+}
+
+// Start begins connecting every bind, and passes the requests that arrive on every session to
+// 'handler'.  It runs the listen loop of the pool's ESME until Stop() is called, and returns
+// ErrListenLoopRunning, without starting the binds, if that loop is already running.
+func (pool *BindPool) Start(handler ESMEHandler) error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	if pool.started {
+		return nil
+	}
+
+	stop, err := pool.esme.startListenLoop(handler)
+	if err != nil {
+		return err
+	}
+	pool.started = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pool.stopListenLoop = cancel
+	go pool.esme.runListenLoop(ctx, stop)
+
+	for _, member := range pool.members {
+		member.Start()
+	}
+
+	return nil
+}
+
+// Stop closes every session with Peer.Close(ctx) and stops reconnecting.  It waits until every bind
+// has stopped.
+func (pool *BindPool) Stop(ctx context.Context) {
+	var stopping sync.WaitGroup
+
+	for _, member := range pool.members {
+		stopping.Add(1)
+		go func(member *ManagedPeer) {
+			defer stopping.Done()
+			member.Stop(ctx)
+		}(member)
+	}
+
+	stopping.Wait()
+
+	pool.mutex.Lock()
+	stopListenLoop := pool.stopListenLoop
+	pool.mutex.Unlock()
+
+	if stopListenLoop != nil {
+		stopListenLoop()
+	}
+}
+
+// SendRequest sends a request on a healthy bind chosen by the BalancingStrategy.  If the chosen session
+// fails or begins to close before the request is written, the request is sent on another healthy bind.
+// ErrNotBound is returned if there is no healthy bind.
+func (pool *BindPool) SendRequest(request *PDU) (*ResponseFuture, error) {
+	return pool.SendRequestContext(context.Background(), request)
+}
+
+// SendRequestContext is the same as SendRequest(), except that if 'ctx' is done while it is blocked
+// on a full window or a rate limit, it returns ctx.Err(), and nothing is written
+func (pool *BindPool) SendRequestContext(ctx context.Context, request *PDU) (*ResponseFuture, error) {
+	tried := make(map[*Peer]bool)
+
+	for {
+		peer := pool.choosePeer(tried)
+		if peer == nil {
+			return nil, ErrNotBound
+		}
+
+		future, err := peer.SendRequestContext(ctx, request)
+		if err == nil || ctx.Err() != nil || (peer.State() != StateClosed && err != ErrSessionClosing) {
+			return future, err
+		}
+
+		tried[peer] = true
+	}
+}
+
+// choosePeer returns the healthy session, not in 'excluded', chosen by the BalancingStrategy, or nil if
+// there is none
+func (pool *BindPool) choosePeer(excluded map[*Peer]bool) *Peer {
+	candidates := make([]*Peer, 0, len(pool.members))
+	for _, peer := range pool.HealthyPeers() {
+		if !excluded[peer] {
+			candidates = append(candidates, peer)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+
+	if pool.strategy == LeastOutstanding {
+		chosen := candidates[0]
+		for _, peer := range candidates[1:] {
+			if peer.OutstandingRequests() < chosen.OutstandingRequests() {
+				chosen = peer
+			}
+		}
+		return chosen
+	}
+
+	chosen := candidates[pool.next%len(candidates)]
+	pool.next++
+
+	return chosen
+}
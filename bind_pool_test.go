@@ -0,0 +1,291 @@
+package smpp
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// countingSMSCHandler counts the submit_sm it receives, and holds each one until 'release' is closed,
+// if 'release' is not nil
+type countingSMSCHandler struct {
+	BaseSMSCHandler
+	submitted chan string
+	release   chan struct{}
+}
+
+func (handler *countingSMSCHandler) OnSubmitSm(session *SMSCSession, pdu *PDU) *Response {
+	handler.submitted <- session.Bind.SystemID
+	if handler.release != nil {
+		<-handler.release
+	}
+	return nil
+}
+
+// startPool starts a transceiver BindPool to 'endpoints', and waits until 'healthy' binds are healthy
+func startPool(t *testing.T, endpoints []PoolEndpoint, strategy BalancingStrategy, handler ESMEHandler, healthy int) *BindPool {
+	pool, err := NewBindPool(&ESME{}, BindInfo{Type: TransceiverBind, SystemID: "esme01"}, endpoints)
+	if err != nil {
+		t.Fatalf("Expected no error on NewBindPool(), but got error = (%s)", err)
+	}
+	pool.SetReconnectPolicy(fastReconnectPolicy)
+	pool.SetBalancingStrategy(strategy)
+	if err := pool.Start(handler); err != nil {
+		t.Fatalf("Expected no error on Start(), but got error = (%s)", err)
+	}
+	t.Cleanup(func() { pool.Stop(context.Background()) })
+
+	awaitHealthyPeers(t, pool, healthy)
+
+	return pool
+}
+
+func awaitHealthyPeers(t *testing.T, pool *BindPool, count int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for len(pool.HealthyPeers()) != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected (%d) healthy binds, got (%d)", count, len(pool.HealthyPeers()))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func poolEndpoint(addr *net.TCPAddr, binds int) PoolEndpoint {
+	return PoolEndpoint{Addr: addr.IP, Port: uint16(addr.Port), Binds: binds}
+}
+
+func sendAndWaitOrFail(t *testing.T, pool *BindPool) {
+	future, err := pool.SendRequest(newShortMessagePDU(CommandSubmitSm, 0, "hello"))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	if response, err := future.Wait(); err != nil || response.CommandStatus != EsmeROK {
+		t.Fatalf("Expected submit-sm-resp with ESME_ROK, got (%v), error = (%v)", response, err)
+	}
+}
+
+func TestBindPoolRoundRobinFailoverAndDelivery(t *testing.T) {
+	firstHandler := &countingSMSCHandler{submitted: make(chan string, 10)}
+	secondHandler := &countingSMSCHandler{submitted: make(chan string, 10)}
+	firstSMSC, secondSMSC := NewSMSC("smsc01", nil, firstHandler), NewSMSC("smsc02", nil, secondHandler)
+	defer firstSMSC.Shutdown(context.Background())
+	defer secondSMSC.Shutdown(context.Background())
+	firstAddr, _ := startSMSC(t, firstSMSC)
+	secondAddr, _ := startSMSC(t, secondSMSC)
+
+	esmeHandler := &recordingESMEHandler{received: make(chan *PDU, 10)}
+	pool := startPool(t, []PoolEndpoint{poolEndpoint(firstAddr, 2), poolEndpoint(secondAddr, 1)}, RoundRobin, esmeHandler, 3)
+
+	if len(pool.Members()) != 3 {
+		t.Fatalf("Expected (3) members, got (%d)", len(pool.Members()))
+	}
+
+	for i := 0; i < 6; i++ {
+		sendAndWaitOrFail(t, pool)
+	}
+
+	if len(firstHandler.submitted) != 4 || len(secondHandler.submitted) != 2 {
+		t.Errorf("Expected round robin to send (4) and (2) submit_sm, got (%d) and (%d)", len(firstHandler.submitted), len(secondHandler.submitted))
+	}
+
+	// delivery receipts from every SMSC reach the one handler
+	for _, smsc := range []*SMSC{firstSMSC, secondSMSC} {
+		future, err := smsc.SendToReceiver("esme01", newShortMessagePDU(CommandDeliverSm, 0, "id:01 stat:DELIVRD"))
+		if err != nil {
+			t.Fatalf("Expected no error on SendToReceiver(), but got error = (%s)", err)
+		}
+		if response, err := future.Wait(); err != nil || response.CommandStatus != EsmeROK {
+			t.Fatalf("Expected deliver-sm-resp with ESME_ROK, got (%v), error = (%v)", response, err)
+		}
+		if pdu := <-esmeHandler.received; pdu.CommandID != CommandDeliverSm {
+			t.Errorf("Expected pool handler to receive deliver_sm, got %s", pdu)
+		}
+	}
+
+	// while the second SMSC is down, every request goes to the first
+	secondSMSC.Shutdown(context.Background())
+	awaitHealthyPeers(t, pool, 2)
+
+	for i := 0; i < 3; i++ {
+		sendAndWaitOrFail(t, pool)
+	}
+
+	if len(firstHandler.submitted) != 7 || len(secondHandler.submitted) != 2 {
+		t.Errorf("Expected every submit_sm to go to the first SMSC during failover, got (%d) and (%d)", len(firstHandler.submitted), len(secondHandler.submitted))
+	}
+
+	// when the second SMSC is back, the bind to it is restored
+	listener, err := net.Listen("tcp", secondAddr.String())
+	if err != nil {
+		t.Fatalf("Failed to listen again on (%s): %s", secondAddr, err)
+	}
+	restoredHandler := &countingSMSCHandler{submitted: make(chan string, 10)}
+	restoredSMSC := NewSMSC("smsc02", nil, restoredHandler)
+	defer restoredSMSC.Shutdown(context.Background())
+	go restoredSMSC.Serve(listener)
+
+	awaitHealthyPeers(t, pool, 3)
+	for i := 0; i < 3; i++ {
+		sendAndWaitOrFail(t, pool)
+	}
+
+	if len(restoredHandler.submitted) != 1 {
+		t.Errorf("Expected restored bind to carry (1) submit_sm, got (%d)", len(restoredHandler.submitted))
+	}
+}
+
+func TestBindPoolLeastOutstanding(t *testing.T) {
+	slowHandler := &countingSMSCHandler{submitted: make(chan string, 10), release: make(chan struct{})}
+	fastHandler := &countingSMSCHandler{submitted: make(chan string, 10)}
+	slowSMSC, fastSMSC := NewSMSC("smsc01", nil, slowHandler), NewSMSC("smsc02", nil, fastHandler)
+	defer slowSMSC.Shutdown(context.Background())
+	defer fastSMSC.Shutdown(context.Background())
+	defer close(slowHandler.release)
+	slowAddr, _ := startSMSC(t, slowSMSC)
+	fastAddr, _ := startSMSC(t, fastSMSC)
+
+	pool := startPool(t, []PoolEndpoint{poolEndpoint(slowAddr, 2), poolEndpoint(fastAddr, 1)}, LeastOutstanding, BaseESMEHandler{}, 3)
+
+	for i := 0; i < 2; i++ {
+		if _, err := pool.SendRequest(newShortMessagePDU(CommandSubmitSm, 0, "slow")); err != nil {
+			t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+		}
+		<-slowHandler.submitted
+	}
+
+	for i := 0; i < 3; i++ {
+		sendAndWaitOrFail(t, pool)
+	}
+
+	if len(fastHandler.submitted) != 3 || len(slowHandler.submitted) != 0 {
+		t.Errorf("Expected every submit_sm to go to the bind with no outstanding requests, got (%d) on it and (%d) elsewhere", len(fastHandler.submitted), len(slowHandler.submitted))
+	}
+}
+
+func TestBindPoolWithoutHealthyBinds(t *testing.T) {
+	pool, _ := NewBindPool(&ESME{}, BindInfo{Type: TransceiverBind, SystemID: "esme01"}, []PoolEndpoint{{Addr: net.IPv4(127, 0, 0, 1), Port: 1}})
+
+	if _, err := pool.SendRequest(newShortMessagePDU(CommandSubmitSm, 0, "hello")); err != ErrNotBound {
+		t.Errorf("Expected ErrNotBound from pool with no healthy binds, got (%v)", err)
+	}
+}
+
+func TestBindPoolRejectsReceiverBind(t *testing.T) {
+	pool, err := NewBindPool(&ESME{}, BindInfo{Type: ReceiverBind, SystemID: "esme01"}, []PoolEndpoint{{Addr: net.IPv4(127, 0, 0, 1), Port: 1}})
+
+	if pool != nil || err != ErrReceiverBindPool {
+		t.Errorf("Expected ErrReceiverBindPool from NewBindPool() with a receiver bind, got pool (%v), error = (%v)", pool, err)
+	}
+}
+
+func TestBindPoolSessionsAreCreatedByItsESME(t *testing.T) {
+	smsc := NewSMSC("smsc01", nil, BaseSMSCHandler{})
+	defer smsc.Shutdown(context.Background())
+	addr, _ := startSMSC(t, smsc)
+
+	esme := &ESME{}
+	configured := make(chan *Peer, 10)
+	esme.SetSessionConfigurator(func(peer *Peer) { configured <- peer })
+
+	pool, err := NewBindPool(esme, BindInfo{Type: TransmitterBind, SystemID: "esme01"}, []PoolEndpoint{poolEndpoint(addr, 2)})
+	if err != nil {
+		t.Fatalf("Expected no error on NewBindPool(), but got error = (%s)", err)
+	}
+	if pool.ESME() != esme {
+		t.Errorf("Expected ESME() to return the ESME passed to NewBindPool()")
+	}
+
+	pool.SetReconnectPolicy(fastReconnectPolicy)
+	if err := pool.Start(&recordingESMEHandler{received: make(chan *PDU, 10)}); err != nil {
+		t.Fatalf("Expected no error on Start(), but got error = (%s)", err)
+	}
+	defer pool.Stop(context.Background())
+
+	awaitHealthyPeers(t, pool, 2)
+
+	if len(configured) != 2 {
+		t.Errorf("Expected the ESME's session configurator to be called for each bind, got (%d) calls", len(configured))
+	}
+
+	sendAndWaitOrFail(t, pool)
+}
+
+func TestBindPoolStartFailsWhenESMEListenLoopIsRunning(t *testing.T) {
+	esme := &ESME{}
+	go esme.StartListenLoop(BaseESMEHandler{})
+	defer esme.StopListenLoop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		esme.mutex.Lock()
+		running := esme.stopListening != nil
+		esme.mutex.Unlock()
+
+		if running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the ESME's listen loop to start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	pool, _ := NewBindPool(esme, BindInfo{Type: TransceiverBind, SystemID: "esme01"}, []PoolEndpoint{{Addr: net.IPv4(127, 0, 0, 1), Port: 1}})
+	pool.SetReconnectPolicy(fastReconnectPolicy)
+	events := make(chan *LifecycleEvent, 10)
+	pool.AddObserver(func(event *LifecycleEvent) { events <- event })
+
+	if err := pool.Start(&recordingESMEHandler{received: make(chan *PDU, 10)}); err != ErrListenLoopRunning {
+		t.Fatalf("Expected ErrListenLoopRunning from Start() while the ESME's listen loop is running, got (%v)", err)
+	}
+
+	select {
+	case event := <-events:
+		t.Errorf("Expected no bind to be started, got lifecycle event (%d)", event.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the pool may be started once the ESME's loop has stopped
+	esme.StopListenLoop()
+	if err := pool.Start(&recordingESMEHandler{received: make(chan *PDU, 10)}); err != nil {
+		t.Errorf("Expected no error on Start() after the ESME's listen loop stopped, but got error = (%s)", err)
+	}
+	pool.Stop(context.Background())
+}
+
+func TestBindPoolDialsEndpointOverUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smsc.sock")
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("Unix domain sockets are not available: %s", err)
+	}
+
+	smsc := NewSMSC("smsc01", nil, BaseSMSCHandler{})
+	defer smsc.Shutdown(context.Background())
+	go smsc.Serve(listener)
+
+	dialed := make(chan struct{}, 10)
+	dial := func(ctx context.Context) (net.Conn, error) {
+		dialed <- struct{}{}
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "unix", path)
+	}
+
+	pool := startPool(t, []PoolEndpoint{{Dial: dial, Binds: 2}}, RoundRobin, &recordingESMEHandler{received: make(chan *PDU, 10)}, 2)
+
+	if len(dialed) != 2 {
+		t.Errorf("Expected each bind to be dialed with the endpoint's DialFunc, got (%d) dials", len(dialed))
+	}
+
+	for _, peer := range pool.HealthyPeers() {
+		if err := peer.SetTCPNoDelay(true); err != ErrTransportNotTCP {
+			t.Errorf("Expected the session transport to be the Unix domain socket, got (%v) from SetTCPNoDelay()", err)
+		}
+	}
+
+	sendAndWaitOrFail(t, pool)
+}
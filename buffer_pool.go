@@ -0,0 +1,69 @@
+package smpp
+
+import "sync"
+
+const (
+	smallestBufferPoolClass = 4096
+	largestBufferPoolClass  = 1 << 20
+)
+
+// BufferPool is a set of byte buffer pools, one for each power-of-two size class from 4 KiB
+// to 1 MiB.  It allows many NetworkStreamReaders to share buffers, rather than each one
+// holding a buffer large enough for the largest PDU it has seen.  It is safe for concurrent use.
+type BufferPool struct {
+	classes []*sync.Pool
+}
+
+// NewBufferPool creates an empty BufferPool
+func NewBufferPool() *BufferPool {
+	pool := &BufferPool{}
+
+	for size := smallestBufferPoolClass; size <= largestBufferPoolClass; size *= 2 {
+		classSize := size
+		pool.classes = append(pool.classes, &sync.Pool{
+			New: func() interface{} {
+				buffer := make([]byte, classSize)
+				return &buffer
+			},
+		})
+	}
+
+	return pool
+}
+
+// Get returns a buffer whose length is at least 'minimumSize'.  The length is the smallest
+// size class that fits.  Requests larger than the largest size class are allocated directly
+func (pool *BufferPool) Get(minimumSize int) []byte {
+	class := pool.classFor(minimumSize)
+
+	if class < 0 {
+		return make([]byte, minimumSize)
+	}
+
+	return *(pool.classes[class].Get().(*[]byte))
+}
+
+// Put returns a buffer obtained from Get() to the pool.  Buffers whose length is not a size
+// class are discarded
+func (pool *BufferPool) Put(buffer []byte) {
+	class := pool.classFor(len(buffer))
+
+	if class < 0 || smallestBufferPoolClass<<uint(class) != len(buffer) {
+		return
+	}
+
+	pool.classes[class].Put(&buffer)
+}
+
+func (pool *BufferPool) classFor(size int) int {
+	class := 0
+
+	for classSize := smallestBufferPoolClass; classSize <= largestBufferPoolClass; classSize *= 2 {
+		if size <= classSize {
+			return class
+		}
+		class++
+	}
+
+	return -1
+}
@@ -0,0 +1,371 @@
+package smpp
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var tlvNameByTag map[uint16]string
+
+func init() {
+	tlvNameByTag = make(map[uint16]string)
+
+	for name, definition := range parameterTypeDefinition {
+		if definition.Type == TypeTLV {
+			tlvNameByTag[definition.TagID] = name
+		}
+	}
+}
+
+// TLVName returns the name of the optional parameter with the provided tag, or an empty
+// string if the tag is not defined
+func TLVName(tag uint16) string {
+	return tlvNameByTag[tag]
+}
+
+// These TLVs carry strings or octet sequences.  All other defined TLVs carry integers.
+var tlvIsOctets = map[string]bool{
+	"additional_status_info_text": true,
+	"callback_num":                true,
+	"callback_num_atag":           true,
+	"dest_subaddress":             true,
+	"its_session_info":            true,
+	"message_payload":             true,
+	"network_error_code":          true,
+	"receipted_message_id":        true,
+	"source_subaddress":           true,
+}
+
+var typeOfNumberName = map[uint8]string{
+	0: "Unknown",
+	1: "International",
+	2: "National",
+	3: "Network Specific",
+	4: "Subscriber Number",
+	5: "Alphanumeric",
+	6: "Abbreviated",
+}
+
+var numberingPlanName = map[uint8]string{
+	0:  "Unknown",
+	1:  "ISDN (E163/E164)",
+	3:  "Data (X.121)",
+	4:  "Telex (F.69)",
+	6:  "Land Mobile (E.212)",
+	8:  "National",
+	9:  "Private",
+	10: "ERMES",
+	14: "Internet (IP)",
+	18: "WAP Client Id",
+}
+
+var dataCodingName = map[uint8]string{
+	0:  "SMSC Default Alphabet",
+	1:  "IA5 (CCITT T.50)/ASCII",
+	2:  "Octet unspecified (8-bit binary)",
+	3:  "Latin 1 (ISO-8859-1)",
+	4:  "Octet unspecified (8-bit binary)",
+	5:  "JIS (X 0208-1990)",
+	6:  "Cyrillic (ISO-8859-5)",
+	7:  "Latin/Hebrew (ISO-8859-8)",
+	8:  "UCS2 (ISO/IEC-10646)",
+	9:  "Pictogram Encoding",
+	10: "ISO-2022-JP (Music Codes)",
+	13: "Extended Kanji JIS (X 0212-1990)",
+	14: "KS C 5601",
+}
+
+var messageStateName = map[uint8]string{
+	1: "ENROUTE",
+	2: "DELIVERED",
+	3: "EXPIRED",
+	4: "DELETED",
+	5: "UNDELIVERABLE",
+	6: "ACCEPTED",
+	7: "UNKNOWN",
+	8: "REJECTED",
+}
+
+// String returns a one-line summary of the PDU header
+func (pdu *PDU) String() string {
+	return fmt.Sprintf("%s length=%d status=%s sequence=%d", pdu.commandNameOrID(), pdu.ComputeLength(), CommandStatusName(pdu.CommandStatus), pdu.SequenceNumber)
+}
+
+// Dump returns a multi-line, field-by-field description of the PDU.  Each line starts with
+// the octet offset of the field in the encoded PDU.  Mandatory parameters are named according
+// to the specification for the PDU's command type, and TLVs are named by tag.  Enumerated
+// and bitfield values are interpreted.
+func (pdu *PDU) Dump() string {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "%s\n", pdu.String())
+	fmt.Fprintf(&builder, "  %04x  %-28s %d\n", 0, "command_length", pdu.ComputeLength())
+	fmt.Fprintf(&builder, "  %04x  %-28s 0x%08x (%s)\n", 4, "command_id", uint32(pdu.CommandID), pdu.commandNameOrID())
+	fmt.Fprintf(&builder, "  %04x  %-28s 0x%08x (%s)\n", 8, "command_status", pdu.CommandStatus, CommandStatusName(pdu.CommandStatus))
+	fmt.Fprintf(&builder, "  %04x  %-28s %d (0x%08x)\n", 12, "sequence_number", pdu.SequenceNumber, pdu.SequenceNumber)
+
+	var parameterNames []string
+	if definition, isDefined := pduTypeDefinition[pdu.CommandID]; isDefined {
+		parameterNames = definition.MandatoryParameters
+	}
+
+	offset := uint32(16)
+	for i, param := range pdu.MandatoryParameters {
+		name := fmt.Sprintf("mandatory[%d]", i)
+		if i < len(parameterNames) {
+			name = parameterNames[i]
+		}
+
+		fmt.Fprintf(&builder, "  %04x  %-28s %s\n", offset, name, describeMandatoryParameter(name, param))
+		offset += param.EncodeLength
+	}
+
+	for _, param := range pdu.OptionalParameters {
+		tlv, isTLV := param.Value.(TLV)
+		if !isTLV {
+			fmt.Fprintf(&builder, "  %04x  %-28s %v\n", offset, "(malformed optional)", param.Value)
+			offset += param.EncodeLength
+			continue
+		}
+
+		name := TLVName(tlv.Tag)
+		if name == "" {
+			name = "unknown"
+		}
+
+		label := fmt.Sprintf("%s [0x%04x] len=%d", name, tlv.Tag, tlv.VLength)
+		fmt.Fprintf(&builder, "  %04x  %-28s %s\n", offset, label, describeTLVValue(name, tlv.Value))
+		offset += param.EncodeLength
+	}
+
+	if len(pdu.UnparsedTail) > 0 {
+		fmt.Fprintf(&builder, "  %04x  %-28s 0x%x\n", offset, "(unparsed)", pdu.UnparsedTail)
+	}
+
+	return builder.String()
+}
+
+func (pdu *PDU) commandNameOrID() string {
+	if name := pdu.CommandName(); name != "" {
+		return name
+	}
+
+	return fmt.Sprintf("command-0x%08x", uint32(pdu.CommandID))
+}
+
+func describeMandatoryParameter(name string, param *Parameter) string {
+	switch value := param.Value.(type) {
+	case uint8:
+		if interpretation := interpretUint8Field(name, value); interpretation != "" {
+			return fmt.Sprintf("%d (%s)", value, interpretation)
+		}
+		return fmt.Sprintf("%d (0x%02x)", value, value)
+
+	case uint16:
+		return fmt.Sprintf("%d (0x%04x)", value, value)
+
+	case uint32:
+		return fmt.Sprintf("%d (0x%08x)", value, value)
+
+	case string:
+		return fmt.Sprintf("%q", value)
+
+	case []byte:
+		return describeOctets(value)
+
+	case []DestinationAddress:
+		entries := make([]string, 0, len(value))
+		for _, address := range value {
+			if address.DistributionList != "" {
+				entries = append(entries, fmt.Sprintf("dl=%q", address.DistributionList))
+			} else {
+				entries = append(entries, fmt.Sprintf("%d/%d %q", address.AddrTON, address.AddrNPI, address.Address))
+			}
+		}
+		return "[" + strings.Join(entries, ", ") + "]"
+
+	case []UnsuccessfulSME:
+		entries := make([]string, 0, len(value))
+		for _, sme := range value {
+			entries = append(entries, fmt.Sprintf("%d/%d %q %s", sme.AddrTON, sme.AddrNPI, sme.Address, CommandStatusName(sme.ErrorStatusCode)))
+		}
+		return "[" + strings.Join(entries, ", ") + "]"
+	}
+
+	return fmt.Sprintf("%v", param.Value)
+}
+
+func describeTLVValue(name string, value interface{}) string {
+	if octets, isOctets := value.([]byte); isOctets && !tlvIsOctets[name] && name != "unknown" {
+		switch len(octets) {
+		case 0:
+			return "(no value)"
+		case 1:
+			value = octets[0]
+		case 2:
+			value = uint16(octets[0])<<8 | uint16(octets[1])
+		case 4:
+			value = uint32(octets[0])<<24 | uint32(octets[1])<<16 | uint32(octets[2])<<8 | uint32(octets[3])
+		}
+	}
+
+	switch v := value.(type) {
+	case uint8:
+		if interpretation := interpretUint8Field(name, v); interpretation != "" {
+			return fmt.Sprintf("%d (%s)", v, interpretation)
+		}
+		return fmt.Sprintf("%d", v)
+
+	case uint16:
+		return fmt.Sprintf("%d (0x%04x)", v, v)
+
+	case uint32:
+		return fmt.Sprintf("%d (0x%08x)", v, v)
+
+	case string:
+		return fmt.Sprintf("%q", v)
+
+	case []byte:
+		return describeOctets(v)
+	}
+
+	return fmt.Sprintf("%v", value)
+}
+
+// describeOctets renders an octet string as quoted text if it is printable (ignoring a trailing
+// null terminator); otherwise, as hex
+func describeOctets(octets []byte) string {
+	text := strings.TrimSuffix(string(octets), "\x00")
+
+	printable := true
+	for _, r := range text {
+		if r > unicode.MaxASCII || !unicode.IsPrint(r) {
+			printable = false
+			break
+		}
+	}
+
+	if printable {
+		return fmt.Sprintf("%q", text)
+	}
+
+	return fmt.Sprintf("0x%x", octets)
+}
+
+func interpretUint8Field(name string, value uint8) string {
+	switch name {
+	case "addr_ton", "source_addr_ton", "dest_addr_ton", "destination_addr_ton", "esme_addr_ton":
+		return lookupOrReserved(typeOfNumberName, value)
+
+	case "addr_npi", "source_addr_npi", "dest_addr_npi", "destination_addr_npi", "esme_addr_npi":
+		return lookupOrReserved(numberingPlanName, value)
+
+	case "data_coding":
+		return lookupOrReserved(dataCodingName, value)
+
+	case "message_state":
+		return lookupOrReserved(messageStateName, value)
+
+	case "interface_version", "SC_interface_version":
+		return fmt.Sprintf("v%d.%d", value>>4, value&0x0f)
+
+	case "esm_class":
+		return interpretEsmClass(value)
+
+	case "registered_delivery":
+		return interpretRegisteredDelivery(value)
+
+	case "replace_if_present_flag":
+		if value == 0 {
+			return "don't replace"
+		} else if value == 1 {
+			return "replace"
+		}
+		return "reserved"
+	}
+
+	return ""
+}
+
+func lookupOrReserved(names map[uint8]string, value uint8) string {
+	if name, ok := names[value]; ok {
+		return name
+	}
+
+	return "reserved"
+}
+
+func interpretEsmClass(value uint8) string {
+	fields := make([]string, 0, 3)
+
+	switch value & 0x03 {
+	case 0:
+		fields = append(fields, "mode=default")
+	case 1:
+		fields = append(fields, "mode=datagram")
+	case 2:
+		fields = append(fields, "mode=forward")
+	case 3:
+		fields = append(fields, "mode=store-and-forward")
+	}
+
+	switch value & 0x3c {
+	case 0x00:
+		fields = append(fields, "type=default")
+	case 0x04:
+		fields = append(fields, "type=delivery-receipt")
+	case 0x08:
+		fields = append(fields, "type=delivery-ack")
+	case 0x10:
+		fields = append(fields, "type=user-ack")
+	case 0x18:
+		fields = append(fields, "type=conversation-abort")
+	case 0x20:
+		fields = append(fields, "type=intermediate-delivery-notification")
+	default:
+		fields = append(fields, "type=reserved")
+	}
+
+	if value&0x40 != 0 {
+		fields = append(fields, "udhi")
+	}
+
+	if value&0x80 != 0 {
+		fields = append(fields, "reply-path")
+	}
+
+	return strings.Join(fields, ", ")
+}
+
+func interpretRegisteredDelivery(value uint8) string {
+	fields := make([]string, 0, 3)
+
+	switch value & 0x03 {
+	case 0:
+		fields = append(fields, "receipt=none")
+	case 1:
+		fields = append(fields, "receipt=success-or-failure")
+	case 2:
+		fields = append(fields, "receipt=failure")
+	case 3:
+		fields = append(fields, "receipt=reserved")
+	}
+
+	switch value & 0x0c {
+	case 0x00:
+		fields = append(fields, "sme-ack=none")
+	case 0x04:
+		fields = append(fields, "sme-ack=delivery")
+	case 0x08:
+		fields = append(fields, "sme-ack=manual")
+	case 0x0c:
+		fields = append(fields, "sme-ack=delivery-and-manual")
+	}
+
+	if value&0x10 != 0 {
+		fields = append(fields, "intermediate-notification")
+	}
+
+	return strings.Join(fields, ", ")
+}
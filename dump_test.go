@@ -0,0 +1,153 @@
+package smpp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var dumpTestEnquireLink = []byte{
+	0x00, 0x00, 0x00, 0x10,
+	0x00, 0x00, 0x00, 0x15,
+	0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x02,
+}
+
+func TestPDUDump(t *testing.T) {
+	pdu := NewPDU(CommandSubmitSm, 0, 0x5e, []*Parameter{
+		NewCOctetStringParameter(""),
+		NewFLParameter(uint8(1)),
+		NewFLParameter(uint8(1)),
+		NewCOctetStringParameter("28809090"),
+		NewFLParameter(uint8(1)),
+		NewFLParameter(uint8(1)),
+		NewCOctetStringParameter("13139591463"),
+		NewFLParameter(uint8(0x40)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewCOctetStringParameter(""),
+		NewCOctetStringParameter(""),
+		NewFLParameter(uint8(1)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(8)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(5)),
+		NewOctetStringFromString("hello"),
+	}, []*Parameter{
+		NewTLVParameter(0x020c, uint16(5)),
+		NewTLVParameter(0x1234, []byte{0x01}),
+	})
+
+	if summary := pdu.String(); summary != "submit-sm length=68 status=ESME_ROK sequence=94" {
+		t.Errorf("String() returned (%s)", summary)
+	}
+
+	dump := pdu.Dump()
+
+	for _, expected := range []string{
+		"  0000  command_length               68\n",
+		"  000c  sequence_number              94 (0x0000005e)\n",
+		"  0010  service_type                 \"\"\n",
+		"  0011  source_addr_ton              1 (International)\n",
+		"  0012  source_addr_npi              1 (ISDN (E163/E164))\n",
+		"  0013  source_addr                  \"28809090\"\n",
+		"esm_class                    64 (mode=default, type=default, udhi)\n",
+		"registered_delivery          1 (receipt=success-or-failure, sme-ack=none)\n",
+		"data_coding                  8 (UCS2 (ISO/IEC-10646))\n",
+		"short_message                \"hello\"\n",
+		"  0039  sar_msg_ref_num [0x020c] len=2 5 (0x0005)\n",
+		"  003f  unknown [0x1234] len=1       0x01\n",
+	} {
+		if !strings.Contains(dump, expected) {
+			t.Errorf("Dump() does not contain (%q); dump is:\n%s", expected, dump)
+		}
+	}
+}
+
+func TestPDUDumpOfDecodedTLVs(t *testing.T) {
+	pdu, err := DecodePDU([]byte{
+		0x00, 0x00, 0x00, 0x21,
+		0x80, 0x00, 0x00, 0x09,
+		0x00, 0x00, 0x00, 0x04,
+		0x00, 0x00, 0x00, 0x01,
+		0x73, 0x6d, 0x73, 0x63, 0x00, // system_id = smsc
+		0x02, 0x10, 0x00, 0x01, 0x34, // SC_interface_version
+		0x00, 0x1e, 0x00, 0x03, 0x41, 0x42, 0x00, // receipted_message_id
+	})
+
+	if err != nil {
+		t.Fatalf("Expected no error on DecodePDU(), but got error = (%s)", err)
+	}
+
+	dump := pdu.Dump()
+
+	for _, expected := range []string{
+		"(ESME_RINVBNDSTS)",
+		"system_id                    \"smsc\"\n",
+		"SC_interface_version [0x0210] len=1 52 (v3.4)\n",
+		"receipted_message_id [0x001e] len=3 \"AB\"\n",
+	} {
+		if !strings.Contains(dump, expected) {
+			t.Errorf("Dump() does not contain (%q); dump is:\n%s", expected, dump)
+		}
+	}
+}
+
+func TestParseHexDumpFormats(t *testing.T) {
+	formats := map[string]string{
+		"hex stream":   "0000001000000015000000000000000" + "2",
+		"spaced hex":   "00 00 00 10 00 00 00 15\n00 00 00 00 00 00 00 02\n",
+		"c array":      "static const unsigned char pkt1[16] = {\n0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x15, /* ........ */\n0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02 };\n",
+		"xxd":          "00000000: 0000 0010 0000 0015 0000 0000 0000 0002  ................\n",
+		"wireshark":    "0000   00 00 00 10 00 00 00 15 00 00 00 00 00 00 00 02   ................\n",
+		"two-column":   "0000  00 00 00 10 00 00 00 15  00 00 00 00 00 00 00 02  ........ ........\n",
+		"short ending": "0000   00 00 00 10 00 00 00 15   ........\n0008   00 00 00 00 00 00 00 02   ........\n",
+	}
+
+	for name, dump := range formats {
+		parsed, err := ParseHexDump(dump)
+
+		if err != nil {
+			t.Errorf("Format %s: expected no error, but got error = (%s)", name, err)
+			continue
+		}
+
+		if !bytes.Equal(parsed, dumpTestEnquireLink) {
+			t.Errorf("Format %s: expected (%x), got (%x)", name, dumpTestEnquireLink, parsed)
+		}
+	}
+}
+
+func TestParseHexDumpAsciiColumnThatLooksLikeHex(t *testing.T) {
+	parsed, err := ParseHexDump("00000000: 6162 6364  abcd\n")
+
+	if err != nil {
+		t.Fatalf("Expected no error, but got error = (%s)", err)
+	}
+
+	if !bytes.Equal(parsed, []byte("abcd")) {
+		t.Errorf("Expected (61626364), got (%x)", parsed)
+	}
+}
+
+func TestParseHexDumpErrors(t *testing.T) {
+	if _, err := ParseHexDump("00 01 0"); err == nil {
+		t.Errorf("Expected error for odd number of hex digits, but got none")
+	}
+
+	if _, err := ParseHexDump("00 zz"); err == nil {
+		t.Errorf("Expected error for non-hex value, but got none")
+	}
+}
+
+func TestDecodePDUFromHexDump(t *testing.T) {
+	pdu, err := DecodePDUFromHexDump("00 00 00 10 00 00 00 15 00 00 00 00 00 00 00 02")
+
+	if err != nil {
+		t.Fatalf("Expected no error, but got error = (%s)", err)
+	}
+
+	if pdu.CommandID != CommandEnquireLink || pdu.SequenceNumber != 2 {
+		t.Errorf("Expected enquire-link with sequence 2, got (%s)", pdu)
+	}
+}
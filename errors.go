@@ -0,0 +1,72 @@
+package smpp
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CommandStatusError is returned when a peer answers a request with a response (or a generic_nack)
+// whose command_status is not ESME_ROK
+type CommandStatusError struct {
+	RequestCommandID  CommandIDType
+	ResponseCommandID CommandIDType
+	CommandStatus     uint32
+}
+
+// Error returns a description of the error, including the command_status name
+func (err *CommandStatusError) Error() string {
+	return fmt.Sprintf("peer answered %s with %s status %s", CommandName(err.RequestCommandID), CommandName(err.ResponseCommandID), CommandStatusName(err.CommandStatus))
+}
+
+// SessionStateError is returned when a request is not permitted in the current session state, such
+// as a submit_sm on a receiver bind
+type SessionStateError struct {
+	CommandID CommandIDType
+	State     SessionState
+}
+
+// Error returns a description of the error, including the command and the session state
+func (err *SessionStateError) Error() string {
+	return fmt.Sprintf("%s is not permitted in session state %s", CommandName(err.CommandID), err.State)
+}
+
+// ErrResponseTimeout is the error for a request whose response did not arrive within the response timeout
+var ErrResponseTimeout = errors.New("Timed out waiting for response")
+
+// ErrWindowFull is returned by TrySendRequest() when the maximum number of requests are already waiting
+// for a response
+var ErrWindowFull = errors.New("Window is full")
+
+// ErrRateLimited is returned by TrySendRequest() when the rate limit for the command allows no request now
+var ErrRateLimited = errors.New("Rate limit exceeded")
+
+// ErrPeerDead is the reason that a session closes when the peer does not answer enquire_link
+var ErrPeerDead = errors.New("Peer is not responding")
+
+// ErrSessionClosing is returned for a request sent after the session has begun to shut down
+var ErrSessionClosing = errors.New("Session is closing")
+
+// ErrPeerUnbound is the reason that a session closes when the peer sends unbind
+var ErrPeerUnbound = errors.New("Peer unbound the session")
+
+// ErrTransportNotTCP is returned when a TCP option is set on a session whose transport is not TCP
+var ErrTransportNotTCP = errors.New("Transport is not TCP")
+
+// ErrMessageAlreadyTracked is returned by MessageTracker.Track() for a key under which a message is
+// already tracked
+var ErrMessageAlreadyTracked = errors.New("Message is already tracked")
+
+// DecodeError is returned by NetworkStreamReader.Read() when data read from the transport cannot be
+// decoded as a PDU.  Err is the reason.
+type DecodeError struct {
+	Err error
+}
+
+func (err *DecodeError) Error() string {
+	return err.Err.Error()
+}
+
+// Unwrap returns the reason
+func (err *DecodeError) Unwrap() error {
+	return err.Err
+}
@@ -1,44 +1,498 @@
 package smpp
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
+	"time"
 )
 
-// ESME represents an ESME, which initiates connection to one or more SMSCs
+// ErrListenLoopRunning is returned when the listen loop of an ESME is started while it is already
+// running
+var ErrListenLoopRunning = errors.New("Listen loop is already running")
+
+// ESME represents an ESME, which initiates connection to one or more SMSCs.  The zero value is ready
+// to use.
 type ESME struct {
+	mutex            sync.Mutex
+	boundPeers       map[*Peer]bool
+	handler          ESMEHandler
+	stopListening    chan struct{}
+	eventLogger      *EventLogger
+	metrics          *Metrics
+	configureSession func(peer *Peer)
+}
+
+// SetSessionConfigurator sets a function that is called with each session that the ESME connects or
+// accepts, before it is bound.  It may be used to attach a tap with Peer.SetTap(), or to set the window
+// size, keepalive and timeouts.
+func (esme *ESME) SetSessionConfigurator(configure func(peer *Peer)) {
+	esme.mutex.Lock()
+	defer esme.mutex.Unlock()
+
+	esme.configureSession = configure
+}
+
+// SetEventLogger sets the EventLogger to which the ESME logs connections and binds, and which it gives
+// to each session that it connects or accepts
+func (esme *ESME) SetEventLogger(eventLogger *EventLogger) {
+	esme.mutex.Lock()
+	defer esme.mutex.Unlock()
+
+	esme.eventLogger = eventLogger
+}
+
+// SetMetrics sets the Metrics in which the ESME counts failed connections, and which it gives to each
+// session that it connects or accepts
+func (esme *ESME) SetMetrics(metrics *Metrics) {
+	esme.mutex.Lock()
+	defer esme.mutex.Unlock()
+
+	esme.metrics = metrics
+}
+
+func (esme *ESME) currentMetrics() *Metrics {
+	esme.mutex.Lock()
+	defer esme.mutex.Unlock()
+
+	return esme.metrics
+}
+
+func (esme *ESME) logger() *EventLogger {
+	esme.mutex.Lock()
+	defer esme.mutex.Unlock()
+
+	return esme.eventLogger
+}
+
+// connected creates the session for a connected transport
+func (esme *ESME) connected(conn net.Conn) *Peer {
+	peer := NewPeerWithConnection(conn)
+	peer.SetEventLogger(esme.logger())
+	peer.SetMetrics(esme.currentMetrics())
+	peer.log(LogEventConnect, "Transport connected", LogField{"local_addr", conn.LocalAddr().String()})
+
+	esme.mutex.Lock()
+	configure := esme.configureSession
+	esme.mutex.Unlock()
+
+	if configure != nil {
+		configure(peer)
+	}
+
+	return peer
+}
+
+// connectFailed logs and counts a failure to connect to 'address', which is empty if it is not known
+func (esme *ESME) connectFailed(address string, err error) {
+	esme.currentMetrics().countConnectFailure()
+
+	if address == "" {
+		esme.logger().Log(LogEventError, "Transport connection failed", LogField{"error", err})
+		return
+	}
+
+	esme.logger().Log(LogEventError, "Transport connection failed", LogField{"remote_addr", address}, LogField{"error", err})
 }
 
 // ConnectToPeer connects a transport (TCP) to a remote peer
 func (esme *ESME) ConnectToPeer(remoteAddr net.IP, remotePort uint16) (peer *Peer, err error) {
-	conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: remoteAddr, Port: int(remotePort), Zone: ""})
+	return esme.ConnectToPeerContext(context.Background(), remoteAddr, remotePort)
+}
+
+// ConnectToPeerContext is the same as ConnectToPeer(), except that the dial is aborted if 'ctx' is
+// done before the connection completes
+func (esme *ESME) ConnectToPeerContext(ctx context.Context, remoteAddr net.IP, remotePort uint16) (peer *Peer, err error) {
+	return esme.ConnectToPeerAddressContext(ctx, "tcp", (&net.TCPAddr{IP: remoteAddr, Port: int(remotePort), Zone: ""}).String())
+}
+
+// ConnectToPeerAddress connects a transport to a remote peer using any network that net.Dial()
+// supports, such as "tcp" with a host name, or "unix" with the path of a Unix domain socket
+func (esme *ESME) ConnectToPeerAddress(network string, address string) (peer *Peer, err error) {
+	return esme.ConnectToPeerAddressContext(context.Background(), network, address)
+}
+
+// ConnectToPeerAddressContext is the same as ConnectToPeerAddress(), except that the dial is aborted
+// if 'ctx' is done before the connection completes
+func (esme *ESME) ConnectToPeerAddressContext(ctx context.Context, network string, address string) (peer *Peer, err error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+
+	if err != nil {
+		esme.connectFailed(address, err)
+		return nil, err
+	}
+
+	return esme.connected(conn), nil
+}
+
+// DialFunc connects a transport to an SMSC.  It allows a session to run over any net.Conn, such as TLS,
+// a Unix domain socket, or a connection through a proxy.
+type DialFunc func(ctx context.Context) (net.Conn, error)
+
+// ConnectToPeerWithDialer connects a transport to a remote peer by calling 'dial'
+func (esme *ESME) ConnectToPeerWithDialer(dial DialFunc) (peer *Peer, err error) {
+	return esme.ConnectToPeerWithDialerContext(context.Background(), dial)
+}
+
+// ConnectToPeerWithDialerContext is the same as ConnectToPeerWithDialer(), except that 'ctx' is passed
+// to 'dial'
+func (esme *ESME) ConnectToPeerWithDialerContext(ctx context.Context, dial DialFunc) (peer *Peer, err error) {
+	conn, err := dial(ctx)
 
 	if err != nil {
+		esme.connectFailed("", err)
 		return nil, err
 	}
 
-	return NewPeerWithConnection(conn), nil
+	return esme.connected(conn), nil
+}
+
+// OutbindRequest describes an outbind received by an ESME.  RemoteAddr is the address of the SMSC's end
+// of the transport.
+type OutbindRequest struct {
+	SystemID   string
+	Password   string
+	RemoteAddr net.Addr
+}
+
+// ServeOutbind accepts connections from SMSCs on 'listener'.  On each connection, it waits up to
+// DefaultBindTimeout for an outbind, and passes it to 'accept', which validates the credentials.  If
+// 'accept' returns true, the ESME binds on the same connection with BindToPeer(), using the returned
+// BindInfo, whose Type must be ReceiverBind or TransceiverBind; the bound session is then served by
+// the listen loop.  If 'accept' returns false, or the bind fails, the connection is closed.  ServeOutbind
+// blocks until the listener fails, and closes the listener before returning.
+func (esme *ESME) ServeOutbind(listener net.Listener, accept func(request *OutbindRequest) (bind BindInfo, accepted bool)) error {
+	return esme.ServeOutbindContext(context.Background(), listener, accept)
+}
+
+// ServeOutbindContext is the same as ServeOutbind(), except that when 'ctx' is done, the listener is
+// closed, ctx.Err() is returned, and connections that are still waiting for an outbind or a bind
+// response are closed
+func (esme *ESME) ServeOutbindContext(ctx context.Context, listener net.Listener, accept func(request *OutbindRequest) (bind BindInfo, accepted bool)) error {
+	defer listener.Close()
+
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			listener.Close()
+		case <-stopWatching:
+		}
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		go esme.answerOutbind(ctx, esme.connected(conn), accept)
+	}
+}
+
+// answerOutbind waits for the outbind on a session accepted by ServeOutbind(), and binds if it is
+// accepted
+func (esme *ESME) answerOutbind(ctx context.Context, peer *Peer, accept func(request *OutbindRequest) (bind BindInfo, accepted bool)) {
+	timer := time.NewTimer(DefaultBindTimeout)
+	defer timer.Stop()
+
+	// only outbind is delivered in the OPEN state, because the session answers enquire_link itself, and
+	// rejects anything else from an SMSC
+	var outbind *PDU
+	select {
+	case outbind = <-peer.IncomingPDUs():
+		if outbind == nil {
+			return
+		}
+	case <-timer.C:
+		peer.Disconnect()
+		return
+	case <-ctx.Done():
+		peer.Disconnect()
+		return
+	}
+
+	request := &OutbindRequest{RemoteAddr: peer.connectionToRemotePeer.RemoteAddr()}
+	if len(outbind.MandatoryParameters) == 2 {
+		request.SystemID, _ = outbind.MandatoryParameters[0].Value.(string)
+		request.Password, _ = outbind.MandatoryParameters[1].Value.(string)
+	}
+
+	bind, accepted := accept(request)
+	if !accepted {
+		peer.Disconnect()
+		return
+	}
+
+	if _, _, err := esme.BindToPeerContext(ctx, peer, bind); err != nil {
+		peer.Disconnect()
+	}
+}
+
+// BindToPeer establishes a bind with a remote peer to which a transport connection is already completed.
+// It sends the bind request described by 'bind', then waits for the response with the same sequence number.
+// If the response command_status is ESME_ROK, the peer is bound, and the SMSC's system_id and the value of
+// its SC_interface_version TLV are returned.  If the SMSC does not include SC_interface_version, 0x33 is
+// returned, as the specification requires.  If the command_status is any other value, a *CommandStatusError
+// is returned.  The session state follows the response, so on success it is StateBoundTx, StateBoundRx or
+// StateBoundTrx, according to the bind type.  After an outbind (StateOutbound), the bind type must be
+// ReceiverBind or TransceiverBind.
+func (esme *ESME) BindToPeer(peer *Peer, bind BindInfo) (smscSystemID string, scInterfaceVersion uint8, err error) {
+	return esme.BindToPeerContext(context.Background(), peer, bind)
+}
+
+// BindToPeerContext is the same as BindToPeer(), except that it returns ctx.Err() if 'ctx' is done
+// before the bind response arrives.  If the bind has already been sent, the transport is closed, because
+// the session would otherwise be bound without the ESME knowing it.
+func (esme *ESME) BindToPeerContext(ctx context.Context, peer *Peer, bind BindInfo) (smscSystemID string, scInterfaceVersion uint8, err error) {
+	if state := peer.State(); state == StateClosed {
+		return "", 0, fmt.Errorf("Peer has no connected transport")
+	} else if state.IsBound() {
+		return "", 0, fmt.Errorf("Peer is already bound")
+	}
+
+	bindPDU := bind.bindPDU(0)
+
+	future, err := peer.SendRequestContext(ctx, bindPDU)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	response, err := future.WaitContext(ctx)
+
+	if err != nil {
+		if err == ctx.Err() {
+			peer.Disconnect()
+		}
+		peer.log(LogEventError, "Bind failed", LogField{"system_id", bind.SystemID}, LogField{"error", err})
+		return "", 0, err
+	}
+
+	if response.CommandStatus != EsmeROK {
+		peer.log(LogEventBind, "Bind rejected", LogField{"system_id", bind.SystemID}, LogField{"command", bindPDU.CommandName()}, LogField{"status", CommandStatusName(response.CommandStatus)})
+		return "", 0, &CommandStatusError{RequestCommandID: bindPDU.CommandID, ResponseCommandID: response.CommandID, CommandStatus: response.CommandStatus}
+	}
+
+	if response.CommandID != bindPDU.CommandID|0x80000000 {
+		return "", 0, fmt.Errorf("Expected %s but received %s", CommandName(bindPDU.CommandID|0x80000000), response.CommandName())
+	}
+
+	smscSystemID, scInterfaceVersion = bindResponseInfo(response)
+
+	peer.log(LogEventBind, "Bound", LogField{"system_id", bind.SystemID}, LogField{"command", bindPDU.CommandName()}, LogField{"smsc_system_id", smscSystemID})
+
+	esme.addBoundPeer(peer)
+
+	return smscSystemID, scInterfaceVersion, nil
+}
+
+// StartListenLoop should be run in a goroutine, and listens for incoming messages from peers.  Each
+// session bound with BindToPeer(), whether before or after the loop starts, is served until it closes:
+// deliver_sm, data_sm and alert_notification requests are passed to 'handler', and deliver_sm_resp and
+// data_sm_resp are sent from what it returns.  Any other request is answered with a generic_nack with
+// ESME_RINVCMDID.  StartListenLoop returns when StopListenLoop() is called.  It returns
+// ErrListenLoopRunning if the loop is already running.
+func (esme *ESME) StartListenLoop(handler ESMEHandler) error {
+	return esme.StartListenLoopContext(context.Background(), handler)
+}
+
+// StartListenLoopContext is the same as StartListenLoop(), except that the loop also stops, and
+// ctx.Err() is returned, when 'ctx' is done
+func (esme *ESME) StartListenLoopContext(ctx context.Context, handler ESMEHandler) error {
+	stop, err := esme.startListenLoop(handler)
+	if err != nil {
+		return err
+	}
+
+	return esme.runListenLoop(ctx, stop)
 }
 
-// BindToPeer establishes a bind with a remote peer to which a transport connection is already completed
-func (esme *ESME) BindToPeer(peer *Peer, bind BindInfo) error {
-	if peer.state == peerDisconnected {
-		return fmt.Errorf("Peer has no connected transport")
+// startListenLoop begins serving the bound sessions with 'handler', and returns the stop channel of the
+// loop, or ErrListenLoopRunning
+func (esme *ESME) startListenLoop(handler ESMEHandler) (chan struct{}, error) {
+	esme.mutex.Lock()
+	defer esme.mutex.Unlock()
+
+	if esme.stopListening != nil {
+		return nil, ErrListenLoopRunning
 	}
 
-	if peer.state == peerBound {
-		return fmt.Errorf("Peer is already bound")
+	stop := make(chan struct{})
+	esme.handler = handler
+	esme.stopListening = stop
+
+	for peer := range esme.boundPeers {
+		go esme.serve(peer, handler, stop)
+	}
+
+	return stop, nil
+}
+
+// runListenLoop waits until the loop whose stop channel is 'stop' is stopped, or 'ctx' is done
+func (esme *ESME) runListenLoop(ctx context.Context, stop chan struct{}) error {
+	select {
+	case <-stop:
+		return nil
+	case <-ctx.Done():
+		esme.stopListenLoop(stop)
+		return ctx.Err()
 	}
+}
 
-	return nil
+// StopListenLoop stops the loop started with StartListenLoop().  The sessions remain bound, but their
+// requests are no longer passed to a handler until the loop is started again.  Meanwhile, the requests
+// are queued in Peer.IncomingPDUs(), and once the queue is full they are answered with ESME_RTHROTTLED.
+func (esme *ESME) StopListenLoop() {
+	esme.mutex.Lock()
+	stop := esme.stopListening
+	esme.mutex.Unlock()
+
+	if stop != nil {
+		esme.stopListenLoop(stop)
+	}
 }
 
-// StartListenLoop should be run in a goroutine, and listens for incoming messages from peers
-func (esme *ESME) StartListenLoop() {
+// stopListenLoop stops the listen loop whose stop channel is 'stop', if it is still running
+func (esme *ESME) stopListenLoop(stop chan struct{}) {
+	esme.mutex.Lock()
+	defer esme.mutex.Unlock()
 
+	if esme.stopListening == stop {
+		close(esme.stopListening)
+		esme.stopListening = nil
+		esme.handler = nil
+	}
 }
 
-// SendMessageToPeer sends a message to a bound peer
+// SendMessageToPeer sends a message to a bound peer.  A request is given the next sequence number for
+// the session, and its response is matched but discarded; use Peer.SendRequest() to wait for the
+// response.  A response is sent with the sequence number that it already has.
 func (esme *ESME) SendMessageToPeer(peer *Peer, pdu *PDU) error {
-	return nil
+	return esme.SendMessageToPeerContext(context.Background(), peer, pdu)
+}
+
+// SendMessageToPeerContext is the same as SendMessageToPeer(), except that if 'ctx' is done while a
+// request is blocked on a full window, it returns ctx.Err(), and nothing is written
+func (esme *ESME) SendMessageToPeerContext(ctx context.Context, peer *Peer, pdu *PDU) error {
+	if !pdu.IsRequest() {
+		return peer.SendPDU(pdu)
+	}
+
+	_, err := peer.SendRequestContext(ctx, pdu)
+
+	return err
+}
+
+// addBoundPeer registers a session that BindToPeer() has bound, so that the listen loop serves it
+// until it closes
+func (esme *ESME) addBoundPeer(peer *Peer) {
+	peer.AddObserver(func(event *PeerEvent) {
+		handler := esme.currentHandler()
+		if handler == nil {
+			return
+		}
+
+		switch event.Type {
+		case EventUnbindReceived:
+			handler.OnUnbind(peer, event.PDU)
+		case EventGenericNackReceived:
+			handler.OnGenericNack(peer, event.PDU)
+		}
+	})
+
+	esme.mutex.Lock()
+	if esme.boundPeers == nil {
+		esme.boundPeers = make(map[*Peer]bool)
+	}
+	esme.boundPeers[peer] = true
+	if esme.stopListening != nil {
+		go esme.serve(peer, esme.handler, esme.stopListening)
+	}
+	esme.mutex.Unlock()
+
+	go func() {
+		<-peer.Done()
+
+		esme.mutex.Lock()
+		delete(esme.boundPeers, peer)
+		esme.mutex.Unlock()
+	}()
+}
+
+func (esme *ESME) currentHandler() ESMEHandler {
+	esme.mutex.Lock()
+	defer esme.mutex.Unlock()
+
+	return esme.handler
+}
+
+// serve passes the requests received on a session to 'handler' until the session closes or the listen
+// loop stops
+func (esme *ESME) serve(peer *Peer, handler ESMEHandler, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+
+		case pdu, isOpen := <-peer.IncomingPDUs():
+			if !isOpen {
+				return
+			}
+			esme.dispatch(peer, pdu, handler)
+		}
+	}
+}
+
+// dispatch passes a request to the handler and sends the response, if the request has one
+func (esme *ESME) dispatch(peer *Peer, request *PDU, handler ESMEHandler) {
+	switch request.CommandID {
+	case CommandDeliverSm:
+		response := handler.OnDeliverSm(peer, request)
+		if response != nil {
+			response = &Response{CommandStatus: response.CommandStatus, OptionalParameters: response.OptionalParameters}
+		}
+		peer.SendPDU(responsePDU(request, response))
+
+	case CommandDataSm:
+		peer.SendPDU(responsePDU(request, handler.OnDataSm(peer, request)))
+
+	case CommandAlertNotification:
+		handler.OnAlertNotification(peer, request)
+
+	default:
+		peer.SendPDU(NewPDU(CommandGenericNack, EsmeRInvCmdID, request.SequenceNumber, []*Parameter{}, []*Parameter{}))
+	}
+}
+
+// bindResponseInfo extracts the system_id and SC_interface_version from a bind response
+func bindResponseInfo(response *PDU) (systemID string, scInterfaceVersion uint8) {
+	scInterfaceVersion = 0x33
+
+	if len(response.MandatoryParameters) > 0 {
+		systemID, _ = response.MandatoryParameters[0].Value.(string)
+	}
+
+	for _, param := range response.OptionalParameters {
+		if tlv, isTLV := param.Value.(TLV); isTLV && tlv.Tag == parameterTypeDefinition["SC_interface_version"].TagID {
+			switch value := tlv.Value.(type) {
+			case uint8:
+				scInterfaceVersion = value
+			case []byte:
+				if len(value) == 1 {
+					scInterfaceVersion = value[0]
+				}
+			}
+		}
+	}
+
+	return systemID, scInterfaceVersion
 }
@@ -0,0 +1,75 @@
+package smpp
+
+// Response describes the response that an ESME sends for a request passed to its ESMEHandler.  A nil
+// *Response is a response with command_status ESME_ROK.  MessageID and OptionalParameters are used only
+// when CommandStatus is ESME_ROK, because a response with any other status has no body.
+type Response struct {
+	CommandStatus      uint32
+	MessageID          string
+	OptionalParameters []*Parameter
+}
+
+// ESMEHandler receives the PDUs that SMSCs send to an ESME on its bound sessions, when passed to
+// ESME.StartListenLoop().  The methods are called from the goroutines that serve the sessions, so an
+// implementation that is shared by several sessions must be safe for concurrent use.  OnUnbind and
+// OnGenericNack are called from the session's read loop, and must not block.
+type ESMEHandler interface {
+	// OnDeliverSm is called for each deliver_sm.  The ESME sends a deliver_sm_resp using the returned
+	// Response.  The message_id of a deliver_sm_resp is always empty.
+	OnDeliverSm(peer *Peer, pdu *PDU) *Response
+	// OnDataSm is called for each data_sm.  The ESME sends a data_sm_resp using the returned Response.
+	OnDataSm(peer *Peer, pdu *PDU) *Response
+	// OnAlertNotification is called for each alert_notification, which has no response
+	OnAlertNotification(peer *Peer, pdu *PDU)
+	// OnUnbind is called when the SMSC sends unbind.  The session answers it with unbind_resp, and
+	// closes, after this returns.
+	OnUnbind(peer *Peer, pdu *PDU)
+	// OnGenericNack is called for each generic_nack from the SMSC.  If it answers an outstanding
+	// request, the request's ResponseFuture also completes with it.
+	OnGenericNack(peer *Peer, pdu *PDU)
+}
+
+// BaseESMEHandler implements ESMEHandler by accepting every deliver_sm and data_sm with ESME_ROK and
+// ignoring everything else.  It may be embedded in a struct that overrides only some methods.
+type BaseESMEHandler struct{}
+
+// OnDeliverSm accepts the deliver_sm
+func (BaseESMEHandler) OnDeliverSm(peer *Peer, pdu *PDU) *Response { return nil }
+
+// OnDataSm accepts the data_sm
+func (BaseESMEHandler) OnDataSm(peer *Peer, pdu *PDU) *Response { return nil }
+
+// OnAlertNotification ignores the alert_notification
+func (BaseESMEHandler) OnAlertNotification(peer *Peer, pdu *PDU) {}
+
+// OnUnbind ignores the unbind
+func (BaseESMEHandler) OnUnbind(peer *Peer, pdu *PDU) {}
+
+// OnGenericNack ignores the generic_nack
+func (BaseESMEHandler) OnGenericNack(peer *Peer, pdu *PDU) {}
+
+// responsePDU builds the response to 'request' described by 'response'.  The response has a message_id
+// only if its definition begins with one.
+func responsePDU(request *PDU, response *Response) *PDU {
+	if response == nil {
+		response = &Response{}
+	}
+
+	responseID := request.CommandID | 0x80000000
+
+	if response.CommandStatus != EsmeROK {
+		return NewPDU(responseID, response.CommandStatus, request.SequenceNumber, []*Parameter{}, []*Parameter{})
+	}
+
+	mandatoryParameters := []*Parameter{}
+	if definition := pduTypeDefinition[responseID]; len(definition.MandatoryParameters) > 0 && definition.MandatoryParameters[0] == "message_id" {
+		mandatoryParameters = append(mandatoryParameters, NewCOctetStringParameter(response.MessageID))
+	}
+
+	optionalParameters := response.OptionalParameters
+	if optionalParameters == nil {
+		optionalParameters = []*Parameter{}
+	}
+
+	return NewPDU(responseID, EsmeROK, request.SequenceNumber, mandatoryParameters, optionalParameters)
+}
@@ -0,0 +1,354 @@
+package smpp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// startFakeSmsc listens on a loopback port and, for the first connection, passes each received
+// PDU to 'respond'.  Any PDUs that 'respond' returns are written back on the connection
+func startFakeSmsc(t *testing.T, respond func(pdu *PDU) []*PDU) *net.TCPAddr {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Failed to listen on loopback: %s", err)
+	}
+
+	go func() {
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := NewNetworkStreamReader(conn)
+		writer := NewNetworkStreamWriter(conn)
+
+		for {
+			pdus, err := reader.Read()
+			if err != nil {
+				return
+			}
+
+			for _, pdu := range pdus {
+				for _, response := range respond(pdu) {
+					if writer.Write(response) != nil {
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr)
+}
+
+func TestBindToPeerSucceeds(t *testing.T) {
+	var receivedBind *PDU
+
+	smscAddr := startFakeSmsc(t, func(pdu *PDU) []*PDU {
+		if pdu.CommandID != CommandBindTransceiver {
+			return nil
+		}
+
+		receivedBind = pdu
+		return []*PDU{
+			NewPDU(CommandEnquireLink, 0, 100, []*Parameter{}, []*Parameter{}),
+			NewPDU(CommandBindTransceiverResp, 0, pdu.SequenceNumber, []*Parameter{
+				NewCOctetStringParameter("smsc01"),
+			}, []*Parameter{
+				NewTLVParameter(0x0210, uint8(0x34)),
+			}),
+		}
+	})
+
+	esme := &ESME{}
+	peer, err := esme.ConnectToPeer(smscAddr.IP, uint16(smscAddr.Port))
+
+	if err != nil {
+		t.Fatalf("Expected no error on ConnectToPeer(), but got error = (%s)", err)
+	}
+
+	systemID, version, err := esme.BindToPeer(peer, BindInfo{
+		Type:       TransceiverBind,
+		SystemID:   "esme01",
+		Password:   "secret",
+		SystemType: "generic",
+		AddrTon:    1,
+		AddrNpi:    1,
+	})
+
+	if err != nil {
+		t.Fatalf("Expected no error on BindToPeer(), but got error = (%s)", err)
+	}
+
+	if systemID != "smsc01" || version != 0x34 {
+		t.Errorf("Expected system_id (smsc01) and version (0x34), got (%s) and (0x%02x)", systemID, version)
+	}
+
+	if receivedBind.CommandID != CommandBindTransceiver {
+		t.Fatalf("SMSC expected bind-transceiver, got (%s)", receivedBind.CommandName())
+	}
+
+	expectedValues := []interface{}{"esme01", "secret", "generic", uint8(0x34), uint8(1), uint8(1), ""}
+	for i, expected := range expectedValues {
+		if receivedBind.MandatoryParameters[i].Value != expected {
+			t.Errorf("bind-transceiver parameter (%d) expected (%v), got (%v)", i, expected, receivedBind.MandatoryParameters[i].Value)
+		}
+	}
+
+	if peer.State() != StateBoundTrx {
+		t.Errorf("Expected session state BOUND_TRX after BindToPeer(), got (%s)", peer.State())
+	}
+
+	if _, _, err := esme.BindToPeer(peer, BindInfo{Type: TransceiverBind}); err == nil {
+		t.Errorf("Expected error on second BindToPeer(), but got none")
+	}
+}
+
+func TestBindToPeerRejected(t *testing.T) {
+	smscAddr := startFakeSmsc(t, func(pdu *PDU) []*PDU {
+		return []*PDU{
+			NewPDU(CommandBindTransmitterResp, EsmeRInvPaswd, pdu.SequenceNumber, []*Parameter{}, []*Parameter{}),
+		}
+	})
+
+	esme := &ESME{}
+	peer, err := esme.ConnectToPeer(smscAddr.IP, uint16(smscAddr.Port))
+
+	if err != nil {
+		t.Fatalf("Expected no error on ConnectToPeer(), but got error = (%s)", err)
+	}
+
+	_, _, err = esme.BindToPeer(peer, BindInfo{Type: TransmitterBind, SystemID: "esme01", Password: "wrong"})
+
+	var statusError *CommandStatusError
+	if !errors.As(err, &statusError) {
+		t.Fatalf("Expected *CommandStatusError on BindToPeer(), got (%v)", err)
+	}
+
+	if statusError.CommandStatus != EsmeRInvPaswd {
+		t.Errorf("Expected command_status ESME_RINVPASWD, got (%s)", CommandStatusName(statusError.CommandStatus))
+	}
+
+	if peer.State() != StateOpen {
+		t.Errorf("Expected session state OPEN after rejected BindToPeer(), got (%s)", peer.State())
+	}
+}
+
+// newShortMessagePDU builds a submit_sm or deliver_sm carrying 'text'
+func newShortMessagePDU(commandID CommandIDType, sequenceNumber uint32, text string) *PDU {
+	return NewPDU(commandID, 0, sequenceNumber, []*Parameter{
+		NewCOctetStringParameter(""),
+		NewFLParameter(uint8(1)),
+		NewFLParameter(uint8(1)),
+		NewCOctetStringParameter("15555550100"),
+		NewFLParameter(uint8(1)),
+		NewFLParameter(uint8(1)),
+		NewCOctetStringParameter("15555550199"),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewCOctetStringParameter(""),
+		NewCOctetStringParameter(""),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(len(text))),
+		NewOctetStringFromString(text),
+	}, []*Parameter{})
+}
+
+// recordingESMEHandler passes each PDU it receives to a channel.  It rejects deliver_sm with
+// sequence number 11.
+type recordingESMEHandler struct {
+	BaseESMEHandler
+	received chan *PDU
+}
+
+func (handler *recordingESMEHandler) OnDeliverSm(peer *Peer, pdu *PDU) *Response {
+	handler.received <- pdu
+	if pdu.SequenceNumber == 11 {
+		return &Response{CommandStatus: EsmeRSysErr}
+	}
+	return nil
+}
+
+func (handler *recordingESMEHandler) OnDataSm(peer *Peer, pdu *PDU) *Response {
+	handler.received <- pdu
+	return &Response{MessageID: "data-01"}
+}
+
+func (handler *recordingESMEHandler) OnAlertNotification(peer *Peer, pdu *PDU) {
+	handler.received <- pdu
+}
+
+func (handler *recordingESMEHandler) OnUnbind(peer *Peer, pdu *PDU) {
+	handler.received <- pdu
+}
+
+func (handler *recordingESMEHandler) OnGenericNack(peer *Peer, pdu *PDU) {
+	handler.received <- pdu
+}
+
+func TestListenLoopDispatchesToHandlerAndResponds(t *testing.T) {
+	responses := make(chan *PDU, 10)
+
+	smscAddr := startFakeSmsc(t, func(pdu *PDU) []*PDU {
+		switch pdu.CommandID {
+		case CommandBindTransceiver:
+			return []*PDU{
+				bindResponseFor(pdu, EsmeROK),
+				newShortMessagePDU(CommandDeliverSm, 10, "hello"),
+				newShortMessagePDU(CommandDeliverSm, 11, "rejected"),
+				NewPDU(CommandDataSm, 0, 12, []*Parameter{
+					NewCOctetStringParameter(""),
+					NewFLParameter(uint8(1)),
+					NewFLParameter(uint8(1)),
+					NewCOctetStringParameter("15555550100"),
+					NewFLParameter(uint8(1)),
+					NewFLParameter(uint8(1)),
+					NewCOctetStringParameter("15555550199"),
+					NewFLParameter(uint8(0)),
+					NewFLParameter(uint8(0)),
+					NewFLParameter(uint8(0)),
+				}, []*Parameter{}),
+				NewPDU(CommandAlertNotification, 0, 13, []*Parameter{
+					NewFLParameter(uint8(1)),
+					NewFLParameter(uint8(1)),
+					NewCOctetStringParameter("15555550100"),
+					NewFLParameter(uint8(1)),
+					NewFLParameter(uint8(1)),
+					NewCOctetStringParameter("15555550199"),
+				}, []*Parameter{}),
+			}
+		case CommandSubmitSm:
+			return []*PDU{
+				NewPDU(CommandGenericNack, EsmeRInvCmdLen, pdu.SequenceNumber, []*Parameter{}, []*Parameter{}),
+				NewPDU(CommandUnbind, 0, 14, []*Parameter{}, []*Parameter{}),
+			}
+		default:
+			if !pdu.IsRequest() {
+				responses <- pdu
+			}
+		}
+		return nil
+	})
+
+	esme := &ESME{}
+	handler := &recordingESMEHandler{received: make(chan *PDU, 10)}
+
+	listenLoopStopped := make(chan error, 1)
+	go func() { listenLoopStopped <- esme.StartListenLoop(handler) }()
+	defer func() {
+		esme.StopListenLoop()
+		if err := <-listenLoopStopped; err != nil {
+			t.Errorf("Expected no error from StartListenLoop(), got (%s)", err)
+		}
+	}()
+
+	peer, err := esme.ConnectToPeer(smscAddr.IP, uint16(smscAddr.Port))
+	if err != nil {
+		t.Fatalf("Expected no error on ConnectToPeer(), but got error = (%s)", err)
+	}
+
+	if _, _, err := esme.BindToPeer(peer, BindInfo{Type: TransceiverBind, SystemID: "esme01"}); err != nil {
+		t.Fatalf("Expected no error on BindToPeer(), but got error = (%s)", err)
+	}
+
+	awaitPDU := func(pdus chan *PDU, description string) *PDU {
+		select {
+		case pdu := <-pdus:
+			return pdu
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", description)
+			return nil
+		}
+	}
+
+	for _, expected := range []struct {
+		commandID      CommandIDType
+		sequenceNumber uint32
+	}{
+		{CommandDeliverSm, 10},
+		{CommandDeliverSm, 11},
+		{CommandDataSm, 12},
+		{CommandAlertNotification, 13},
+	} {
+		if pdu := awaitPDU(handler.received, "handler callback"); pdu.CommandID != expected.commandID || pdu.SequenceNumber != expected.sequenceNumber {
+			t.Errorf("Expected handler to receive %s with sequence (%d), got %s", CommandName(expected.commandID), expected.sequenceNumber, pdu)
+		}
+	}
+
+	for _, expected := range []struct {
+		commandID      CommandIDType
+		status         uint32
+		sequenceNumber uint32
+		messageID      string
+	}{
+		{CommandDeliverSmResp, EsmeROK, 10, ""},
+		{CommandDeliverSmResp, EsmeRSysErr, 11, ""},
+		{CommandDataSmResp, EsmeROK, 12, "data-01"},
+	} {
+		response := awaitPDU(responses, CommandName(expected.commandID))
+		if response.CommandID != expected.commandID || response.CommandStatus != expected.status || response.SequenceNumber != expected.sequenceNumber {
+			t.Errorf("Expected %s with status (%s) and sequence (%d), got %s", CommandName(expected.commandID), CommandStatusName(expected.status), expected.sequenceNumber, response)
+			continue
+		}
+		if expected.status == EsmeROK && response.MandatoryParameters[0].Value != expected.messageID {
+			t.Errorf("Expected %s message_id (%s), got (%v)", CommandName(expected.commandID), expected.messageID, response.MandatoryParameters[0].Value)
+		}
+	}
+
+	if err := esme.SendMessageToPeer(peer, newShortMessagePDU(CommandSubmitSm, 0, "outbound")); err != nil {
+		t.Fatalf("Expected no error on SendMessageToPeer(), but got error = (%s)", err)
+	}
+
+	if pdu := awaitPDU(handler.received, "OnGenericNack"); pdu.CommandID != CommandGenericNack {
+		t.Errorf("Expected OnGenericNack after submit_sm, got %s", pdu)
+	}
+
+	if pdu := awaitPDU(handler.received, "OnUnbind"); pdu.CommandID != CommandUnbind {
+		t.Errorf("Expected OnUnbind, got %s", pdu)
+	}
+
+	if unbindResponse := awaitPDU(responses, "unbind-resp"); unbindResponse.CommandID != CommandUnbindResp || unbindResponse.SequenceNumber != 14 {
+		t.Errorf("Expected unbind-resp with sequence 14, got %s", unbindResponse)
+	}
+}
+
+func TestConnectAndBindHonourContext(t *testing.T) {
+	esme := &ESME{}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := esme.ConnectToPeerContext(cancelled, net.IPv4(127, 0, 0, 1), 2775); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from ConnectToPeerContext(), got (%v)", err)
+	}
+
+	// the SMSC never answers the bind
+	smscAddr := startFakeSmsc(t, func(pdu *PDU) []*PDU { return nil })
+
+	peer, err := esme.ConnectToPeerContext(context.Background(), smscAddr.IP, uint16(smscAddr.Port))
+	if err != nil {
+		t.Fatalf("Expected no error on ConnectToPeerContext(), but got error = (%s)", err)
+	}
+
+	ctx, cancelBind := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelBind()
+
+	if _, _, err := esme.BindToPeerContext(ctx, peer, BindInfo{Type: TransceiverBind, SystemID: "esme01"}); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded from BindToPeerContext(), got (%v)", err)
+	}
+
+	if peer.State() != StateClosed {
+		t.Errorf("Expected session to be closed after abandoned bind, got (%s)", peer.State())
+	}
+}
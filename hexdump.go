@@ -0,0 +1,124 @@
+package smpp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	hexDumpCComment      = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	hexDumpXxdOffset     = regexp.MustCompile(`^([0-9a-fA-F]{4,}):\s*`)
+	hexDumpColumnOffset  = regexp.MustCompile(`^([0-9a-fA-F]{4,8})\s{2,}`)
+	hexDumpColumnDivider = regexp.MustCompile(`\s{2,}`)
+	hexDumpSeparators    = strings.NewReplacer(",", " ", "{", " ", "}", " ", ";", " ", ":", " ")
+)
+
+// ParseHexDump converts the text of a hex dump back into bytes, for example so that the result
+// can be passed to DecodePDU.  It accepts:
+//   - plain hex, either continuous or separated by whitespace (e.g., Wireshark "Copy as Hex Stream");
+//   - hex octets with "0x" prefixes separated by commas, optionally inside a C array declaration
+//     (e.g., Wireshark "Copy as C Arrays");
+//   - xxd output, where each line starts with an offset and a colon and ends with an ASCII column;
+//   - Wireshark "Copy as Hex Dump" output, where each line starts with an offset followed by
+//     at least two spaces, and may end with an ASCII column.
+//
+// A leading offset is only recognized on a line if it is followed by a colon, or if its value
+// matches the number of octets parsed so far.
+func ParseHexDump(dump string) ([]byte, error) {
+	dump = hexDumpCComment.ReplaceAllString(dump, " ")
+	if open := strings.Index(dump, "{"); open >= 0 && strings.Contains(dump[:open], "[") {
+		dump = dump[open:]
+	}
+
+	parsed := make([]byte, 0, len(dump)/2)
+
+	for lineNumber, line := range strings.Split(dump, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "//") {
+			continue
+		}
+
+		octets, err := parseHexDumpLine(line, len(parsed))
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber+1, err)
+		}
+
+		parsed = append(parsed, octets...)
+	}
+
+	return parsed, nil
+}
+
+// DecodePDUFromHexDump parses the hex dump using ParseHexDump, then decodes the result using DecodePDU
+func DecodePDUFromHexDump(dump string) (*PDU, error) {
+	stream, err := ParseHexDump(dump)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodePDU(stream)
+}
+
+func parseHexDumpLine(line string, octetsSoFar int) ([]byte, error) {
+	if line == "" {
+		return nil, nil
+	}
+
+	if match := hexDumpXxdOffset.FindStringSubmatch(line); match != nil {
+		return parseHexColumns(line[len(match[0]):])
+	}
+
+	if match := hexDumpColumnOffset.FindStringSubmatch(line); match != nil {
+		if offset, err := strconv.ParseUint(match[1], 16, 32); err == nil && int(offset) == octetsSoFar {
+			return parseHexColumns(line[len(match[0]):])
+		}
+	}
+
+	return parseHexTokens(strings.Fields(hexDumpSeparators.Replace(line)))
+}
+
+// parseHexColumns parses the hex portion of an offset-prefixed line.  The hex column ends at the
+// first run of two or more spaces, except that some formats split sixteen octets into two groups
+// of eight with a double space, in which case the second group is also hex if an ASCII column follows it.
+func parseHexColumns(columns string) ([]byte, error) {
+	groups := hexDumpColumnDivider.Split(strings.TrimSpace(columns), -1)
+
+	octets, err := parseHexTokens(strings.Fields(groups[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(octets) == 8 && len(groups) > 2 {
+		if secondHalf, err := parseHexTokens(strings.Fields(groups[1])); err == nil && len(secondHalf) <= 8 {
+			octets = append(octets, secondHalf...)
+		}
+	}
+
+	return octets, nil
+}
+
+func parseHexTokens(tokens []string) ([]byte, error) {
+	octets := make([]byte, 0, len(tokens))
+
+	for _, token := range tokens {
+		token = strings.TrimPrefix(strings.TrimPrefix(token, "0x"), "0X")
+
+		if len(token)%2 != 0 {
+			return nil, fmt.Errorf("hex value (%s) has an odd number of digits", token)
+		}
+
+		decoded, err := hex.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("value (%s) is not hex", token)
+		}
+
+		octets = append(octets, decoded...)
+	}
+
+	return octets, nil
+}
@@ -0,0 +1,141 @@
+package smpp
+
+// InterceptAction is what an Interceptor decides to do with a PDU
+type InterceptAction int
+
+const (
+	// InterceptPass passes the PDU on to the next interceptor, and then to the session or transport
+	InterceptPass InterceptAction = iota
+	// InterceptDrop discards the PDU.  It is neither written nor dispatched.
+	InterceptDrop
+	// InterceptAnswer discards a request and answers it with the response in the Interception
+	InterceptAnswer
+)
+
+// Interception is the decision of an Interceptor.  For InterceptPass, PDU is the PDU to pass on, which
+// may be the intercepted PDU (modified or not) or a replacement; nil passes the intercepted PDU.  For
+// InterceptAnswer, PDU is the response.
+type Interception struct {
+	Action InterceptAction
+	PDU    *PDU
+}
+
+// PassPDU passes 'pdu' on.  It may be the intercepted PDU or a replacement.
+func PassPDU(pdu *PDU) Interception {
+	return Interception{Action: InterceptPass, PDU: pdu}
+}
+
+// DropPDU discards the intercepted PDU
+func DropPDU() Interception {
+	return Interception{Action: InterceptDrop}
+}
+
+// AnswerPDU discards the intercepted request and answers it with 'response', which should have the
+// request's sequence number.  An inbound request is answered by writing 'response' to the transport,
+// and an outbound request is answered as though 'response' had been read from the transport, so its
+// ResponseFuture completes with it.  Answering a PDU that is not a request is the same as dropping it.
+func AnswerPDU(response *PDU) Interception {
+	return Interception{Action: InterceptAnswer, PDU: response}
+}
+
+// Interceptor inspects each PDU that crosses a session, and decides whether to pass it on, drop it, or
+// answer it locally.  Interceptors are called synchronously from the goroutine that reads or writes the
+// PDU, so they should not block.
+type Interceptor func(peer *Peer, direction PDUDirection, pdu *PDU) Interception
+
+// AddInterceptor adds an interceptor to the session.  The interceptors form a chain in which the first
+// one added is nearest the transport: inbound PDUs pass through the interceptors in the order in which
+// they were added, and outbound PDUs in the reverse order.  A response with which an interceptor answers
+// a request passes through the interceptors between it and the transport (for an inbound request) or
+// between it and the session (for an outbound request).  Session housekeeping (enquire_link and its
+// response, unbind from Close(), and automatic rejections) is intercepted like any other PDU.
+func (peer *Peer) AddInterceptor(interceptor Interceptor) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	peer.interceptors = append(peer.interceptors, interceptor)
+}
+
+func (peer *Peer) interceptorChain() []Interceptor {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	return append([]Interceptor(nil), peer.interceptors...)
+}
+
+// receive passes a PDU read from the transport through the interceptors, then to the session
+func (peer *Peer) receive(pdu *PDU) {
+	peer.observePDU(DirectionInbound, pdu)
+
+	if peer.rejectMalformedRequest(pdu) {
+		return
+	}
+
+	peer.receiveFrom(peer.interceptorChain(), 0, pdu)
+}
+
+// receiveFrom passes an inbound PDU through 'chain', from the interceptor at 'start', then to the session
+func (peer *Peer) receiveFrom(chain []Interceptor, start int, pdu *PDU) {
+	for i := start; i < len(chain); i++ {
+		interception := chain[i](peer, DirectionInbound, pdu)
+
+		switch interception.Action {
+		case InterceptDrop:
+			return
+
+		case InterceptAnswer:
+			if pdu.IsRequest() && interception.PDU != nil {
+				if written, _ := peer.transmitFrom(chain, i-1, interception.PDU); written {
+					peer.applyTransition(interception.PDU)
+				}
+			}
+			return
+
+		default:
+			if interception.PDU != nil {
+				pdu = interception.PDU
+			}
+		}
+	}
+
+	peer.handleInboundPDU(pdu)
+}
+
+// transmit passes a PDU through the interceptors, then writes it to the transport.  It returns true if
+// the PDU was written, and false if it was dropped or answered by an interceptor.
+func (peer *Peer) transmit(pdu *PDU) (bool, error) {
+	chain := peer.interceptorChain()
+	return peer.transmitFrom(chain, len(chain)-1, pdu)
+}
+
+// transmitFrom passes an outbound PDU through 'chain', from the interceptor at 'start' towards the
+// first, then writes it to the transport
+func (peer *Peer) transmitFrom(chain []Interceptor, start int, pdu *PDU) (bool, error) {
+	for i := start; i >= 0; i-- {
+		interception := chain[i](peer, DirectionOutbound, pdu)
+
+		switch interception.Action {
+		case InterceptDrop:
+			return false, nil
+
+		case InterceptAnswer:
+			if pdu.IsRequest() && interception.PDU != nil {
+				peer.receiveFrom(chain, i+1, interception.PDU)
+			}
+			return false, nil
+
+		default:
+			if interception.PDU != nil {
+				pdu = interception.PDU
+			}
+		}
+	}
+
+	if err := peer.writer.Write(pdu); err != nil {
+		return false, err
+	}
+
+	peer.observePDU(DirectionOutbound, pdu)
+
+	return true, nil
+}
@@ -0,0 +1,141 @@
+package smpp
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInterceptorsRunInChainOrderAndModifyPDUs(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+
+	var mutex sync.Mutex
+	seen := make([]string, 0)
+	record := func(name string) Interceptor {
+		return func(peer *Peer, direction PDUDirection, pdu *PDU) Interception {
+			mutex.Lock()
+			defer mutex.Unlock()
+			seen = append(seen, fmt.Sprintf("%s %s %s", name, direction, pdu.CommandName()))
+			return PassPDU(pdu)
+		}
+	}
+
+	peer.AddInterceptor(record("first"))
+	bindLoopbackPeer(t, peer, remoteReader, remoteWriter)
+	peer.AddInterceptor(record("second"))
+
+	// normalize source_addr on every outbound submit_sm
+	peer.AddInterceptor(func(peer *Peer, direction PDUDirection, pdu *PDU) Interception {
+		if direction == DirectionOutbound && pdu.CommandID == CommandSubmitSm {
+			pdu.MandatoryParameters[3] = NewCOctetStringParameter("+" + pdu.MandatoryParameters[3].Value.(string))
+		}
+		return PassPDU(nil)
+	})
+
+	future, err := peer.SendRequest(newShortMessagePDU(CommandSubmitSm, 0, "hello"))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	request := readPDUOrFail(t, remoteReader)
+	if sourceAddr := request.MandatoryParameters[3].Value; sourceAddr != "+15555550100" {
+		t.Errorf("Expected interceptor to rewrite source_addr to (+15555550100), got (%v)", sourceAddr)
+	}
+
+	remoteWriter.Write(NewPDU(CommandSubmitSmResp, EsmeROK, request.SequenceNumber, []*Parameter{NewCOctetStringParameter("msg-01")}, []*Parameter{}))
+	if _, err := future.Wait(); err != nil {
+		t.Fatalf("Expected no error on Wait(), but got error = (%s)", err)
+	}
+
+	expected := []string{
+		"first outbound " + CommandName(CommandBindTransceiver),
+		"first inbound " + CommandName(CommandBindTransceiverResp),
+		"second outbound " + CommandName(CommandSubmitSm),
+		"first outbound " + CommandName(CommandSubmitSm),
+		"first inbound " + CommandName(CommandSubmitSmResp),
+		"second inbound " + CommandName(CommandSubmitSmResp),
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(seen) != len(expected) {
+		t.Fatalf("Expected interceptors to see (%v), got (%v)", expected, seen)
+	}
+	for i := range expected {
+		if seen[i] != expected[i] {
+			t.Errorf("Expected interception (%d) to be (%s), got (%s)", i, expected[i], seen[i])
+		}
+	}
+}
+
+func TestInterceptorsAnswerLocally(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+	bindLoopbackPeer(t, peer, remoteReader, remoteWriter)
+
+	peer.AddInterceptor(func(peer *Peer, direction PDUDirection, pdu *PDU) Interception {
+		switch {
+		case direction == DirectionOutbound && pdu.CommandID == CommandEnquireLink:
+			return AnswerPDU(NewPDU(CommandEnquireLinkResp, EsmeROK, pdu.SequenceNumber, []*Parameter{}, []*Parameter{}))
+		case direction == DirectionInbound && pdu.CommandID == CommandDeliverSm && pdu.SequenceNumber == 1:
+			return AnswerPDU(NewPDU(CommandDeliverSmResp, EsmeRxTAppn, pdu.SequenceNumber, []*Parameter{}, []*Parameter{}))
+		}
+		return PassPDU(pdu)
+	})
+
+	future, err := peer.SendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{}))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	if response, err := future.Wait(); err != nil || response.CommandID != CommandEnquireLinkResp {
+		t.Errorf("Expected enquire_link to be answered locally, got (%v), error = (%v)", response, err)
+	}
+
+	remoteWriter.Write(newShortMessagePDU(CommandDeliverSm, 1, "answered"))
+	remoteWriter.Write(newShortMessagePDU(CommandDeliverSm, 2, "delivered"))
+
+	// the enquire_link was not written, so the first PDU the remote reads is the local answer
+	response := readPDUOrFail(t, remoteReader)
+	if response.CommandID != CommandDeliverSmResp || response.CommandStatus != EsmeRxTAppn || response.SequenceNumber != 1 {
+		t.Errorf("Expected deliver_sm_resp with ESME_RX_T_APPN for sequence (1), got %s", response)
+	}
+
+	if delivered := <-peer.IncomingPDUs(); delivered.SequenceNumber != 2 {
+		t.Errorf("Expected only the deliver_sm that was not answered to be delivered, got sequence (%d)", delivered.SequenceNumber)
+	}
+}
+
+func TestInterceptorDropsPDUs(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+	bindLoopbackPeer(t, peer, remoteReader, remoteWriter)
+
+	peer.SetResponseTimeout(50 * time.Millisecond)
+
+	// lose every submit_sm_resp, to see that the request times out
+	peer.AddInterceptor(func(peer *Peer, direction PDUDirection, pdu *PDU) Interception {
+		if direction == DirectionInbound && pdu.CommandID == CommandSubmitSmResp {
+			return DropPDU()
+		}
+		return PassPDU(pdu)
+	})
+
+	future, err := peer.SendRequest(newShortMessagePDU(CommandSubmitSm, 0, "hello"))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	request := readPDUOrFail(t, remoteReader)
+	remoteWriter.Write(NewPDU(CommandSubmitSmResp, EsmeROK, request.SequenceNumber, []*Parameter{NewCOctetStringParameter("msg-01")}, []*Parameter{}))
+
+	if _, err := future.Wait(); err != ErrResponseTimeout {
+		t.Errorf("Expected ErrResponseTimeout for response dropped by interceptor, got (%v)", err)
+	}
+}
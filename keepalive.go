@@ -0,0 +1,105 @@
+package smpp
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultEnquireLinkMaxMisses is the number of consecutive unanswered enquire_link requests after
+// which a session declares the peer dead, unless it is changed with SetEnquireLinkMaxMisses()
+const DefaultEnquireLinkMaxMisses = 3
+
+// SetEnquireLinkInterval enables the keepalive.  When nothing has been received from the peer for
+// 'interval', the session sends an enquire_link and waits up to 'interval' for the response.  Any
+// PDU from the peer counts as a response.  An interval of zero (the default) disables the keepalive.
+func (peer *Peer) SetEnquireLinkInterval(interval time.Duration) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	peer.enquireLinkInterval = interval
+	close(peer.keepaliveChanged)
+	peer.keepaliveChanged = make(chan struct{})
+}
+
+// SetEnquireLinkMaxMisses sets the number of consecutive enquire_link requests that may go unanswered
+// before the session declares the peer dead.  When that happens, the transport is closed, and the
+// session moves to StateClosed with an ErrPeerDead reason.
+func (peer *Peer) SetEnquireLinkMaxMisses(misses int) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	peer.enquireLinkMaxMisses = misses
+}
+
+func (peer *Peer) keepaliveLoop() {
+	for {
+		peer.mutex.Lock()
+		interval := peer.enquireLinkInterval
+		nextProbeTime := peer.lastReceiveTime.Add(interval)
+		changed := peer.keepaliveChanged
+		peer.mutex.Unlock()
+
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if interval > 0 {
+			timer = time.NewTimer(time.Until(nextProbeTime))
+			expired = timer.C
+		}
+
+		select {
+		case <-peer.closed:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+
+		case <-changed:
+			if timer != nil {
+				timer.Stop()
+			}
+
+		case <-expired:
+			peer.probe(interval)
+		}
+	}
+}
+
+// probe sends an enquire_link if nothing has arrived from the peer for 'interval', and waits for the
+// response.  If the maximum number of consecutive probes go unanswered, the session is closed.
+func (peer *Peer) probe(interval time.Duration) {
+	peer.mutex.Lock()
+	idle := time.Since(peer.lastReceiveTime) >= interval
+	state := peer.state
+	peer.mutex.Unlock()
+
+	if !idle {
+		return
+	}
+
+	if !requestIsPermitted(CommandEnquireLink, peer.localRole, state) {
+		peer.mutex.Lock()
+		peer.lastReceiveTime = time.Now()
+		peer.mutex.Unlock()
+		return
+	}
+
+	future, err := peer.sendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{}), requestOptions{ignoreWindow: true, timeout: interval, policy: &TimeoutPolicy{Action: FailOnTimeout}})
+	if err != nil {
+		return
+	}
+
+	if _, err := future.Wait(); err != ErrResponseTimeout {
+		return
+	}
+
+	peer.mutex.Lock()
+	peer.enquireLinkMisses++
+	misses := peer.enquireLinkMisses
+	maxMisses := peer.enquireLinkMaxMisses
+	peer.mutex.Unlock()
+
+	if misses >= maxMisses {
+		peer.closeSession(fmt.Errorf("%w: (%d) consecutive enquire_link requests were not answered", ErrPeerDead, misses))
+		peer.connectionToRemotePeer.Close()
+	}
+}
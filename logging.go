@@ -0,0 +1,248 @@
+package smpp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// LogLevel is the severity of a log entry
+type LogLevel int
+
+const (
+	// LevelDebug is for detail that is useful when tracing a session, like every PDU
+	LevelDebug LogLevel = iota
+	// LevelInfo is for the normal milestones of a session, like binds and state changes
+	LevelInfo
+	// LevelWarn is for problems from which the session recovers, like response timeouts
+	LevelWarn
+	// LevelError is for failures, like a transport error that closes the session
+	LevelError
+	// LevelOff is above every other level.  An event type with this level is never logged.
+	LevelOff
+)
+
+var logLevelName = map[LogLevel]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+	LevelOff:   "off",
+}
+
+func (level LogLevel) String() string {
+	return logLevelName[level]
+}
+
+// LogEventType identifies the kind of activity that a log entry describes
+type LogEventType int
+
+const (
+	// LogEventConnect is logged when a transport is connected or accepted
+	LogEventConnect LogEventType = iota
+	// LogEventBind is logged when a bind is accepted or rejected
+	LogEventBind
+	// LogEventStateChange is logged when the session state changes
+	LogEventStateChange
+	// LogEventPDUSent is logged for each PDU written to the transport
+	LogEventPDUSent
+	// LogEventPDUReceived is logged for each PDU read from the transport
+	LogEventPDUReceived
+	// LogEventTimeout is logged when a request times out waiting for its response
+	LogEventTimeout
+	// LogEventError is logged when a connection or bind fails, or a session closes because of an error
+	LogEventError
+)
+
+var logEventTypeName = map[LogEventType]string{
+	LogEventConnect:     "connect",
+	LogEventBind:        "bind",
+	LogEventStateChange: "state_change",
+	LogEventPDUSent:     "pdu_sent",
+	LogEventPDUReceived: "pdu_received",
+	LogEventTimeout:     "timeout",
+	LogEventError:       "error",
+}
+
+func (eventType LogEventType) String() string {
+	return logEventTypeName[eventType]
+}
+
+// DefaultLogLevels is the level at which an EventLogger logs each event type, unless it is changed
+// with SetEventLevel()
+var DefaultLogLevels = map[LogEventType]LogLevel{
+	LogEventConnect:     LevelInfo,
+	LogEventBind:        LevelInfo,
+	LogEventStateChange: LevelInfo,
+	LogEventPDUSent:     LevelDebug,
+	LogEventPDUReceived: LevelDebug,
+	LogEventTimeout:     LevelWarn,
+	LogEventError:       LevelError,
+}
+
+// LogField is a named value in a log entry
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// LogEntry is a structured description of session activity
+type LogEntry struct {
+	Time    time.Time
+	Level   LogLevel
+	Event   LogEventType
+	Message string
+	Fields  []LogField
+}
+
+// Logger receives log entries.  An implementation must be safe for concurrent use, because entries
+// are logged from the goroutines of every session.
+type Logger interface {
+	Log(entry *LogEntry)
+}
+
+// LoggerFunc is a function that implements Logger
+type LoggerFunc func(entry *LogEntry)
+
+// Log calls the function
+func (logFunc LoggerFunc) Log(entry *LogEntry) {
+	logFunc(entry)
+}
+
+// NewStandardLogger returns a Logger that writes each entry to 'logger' as one line of the form
+// "info bind: Bound as transceiver system_id=esme01 remote_addr=192.0.2.1:2775"
+func NewStandardLogger(logger *log.Logger) Logger {
+	return LoggerFunc(func(entry *LogEntry) {
+		line := fmt.Sprintf("%s %s: %s", entry.Level, entry.Event, entry.Message)
+		for _, field := range entry.Fields {
+			line += fmt.Sprintf(" %s=%v", field.Key, field.Value)
+		}
+		logger.Print(line)
+	})
+}
+
+// NewJSONLinesLogger returns a Logger that writes each entry to 'writer' as a JSON object on its own
+// line, with the members "time" (RFC 3339 with nanoseconds), "level", "event" and "message", followed
+// by the fields in order.  A field value that is an error is written as its message.
+func NewJSONLinesLogger(writer io.Writer) Logger {
+	var mutex sync.Mutex
+
+	return LoggerFunc(func(entry *LogEntry) {
+		var line bytes.Buffer
+
+		line.WriteString("{")
+		writeJSONMember(&line, "time", entry.Time.Format(time.RFC3339Nano))
+		for _, member := range []LogField{{"level", entry.Level.String()}, {"event", entry.Event.String()}, {"message", entry.Message}} {
+			line.WriteString(",")
+			writeJSONMember(&line, member.Key, member.Value)
+		}
+		for _, field := range entry.Fields {
+			line.WriteString(",")
+			writeJSONMember(&line, field.Key, field.Value)
+		}
+		line.WriteString("}\n")
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		writer.Write(line.Bytes())
+	})
+}
+
+func writeJSONMember(line *bytes.Buffer, key string, value interface{}) {
+	if err, isError := value.(error); isError {
+		value = err.Error()
+	}
+
+	encodedKey, _ := json.Marshal(key)
+	encodedValue, err := json.Marshal(value)
+	if err != nil {
+		encodedValue, _ = json.Marshal(fmt.Sprint(value))
+	}
+
+	line.Write(encodedKey)
+	line.WriteString(":")
+	line.Write(encodedValue)
+}
+
+// EventLogger decides which session activity is logged, and passes it to a Logger.  Each event type
+// is logged at its own level (DefaultLogLevels unless changed with SetEventLevel()), and an entry is
+// passed to the Logger only if its level is at least the minimum level, which is LevelInfo by default.
+// A nil *EventLogger logs nothing.
+type EventLogger struct {
+	logger Logger
+
+	mutex   sync.Mutex
+	minimum LogLevel
+	levels  map[LogEventType]LogLevel
+}
+
+// NewEventLogger creates an EventLogger that passes entries to 'logger'
+func NewEventLogger(logger Logger) *EventLogger {
+	levels := make(map[LogEventType]LogLevel)
+	for eventType, level := range DefaultLogLevels {
+		levels[eventType] = level
+	}
+
+	return &EventLogger{logger: logger, minimum: LevelInfo, levels: levels}
+}
+
+// SetMinimumLevel sets the lowest level that is logged
+func (eventLogger *EventLogger) SetMinimumLevel(level LogLevel) {
+	eventLogger.mutex.Lock()
+	defer eventLogger.mutex.Unlock()
+
+	eventLogger.minimum = level
+}
+
+// SetEventLevel sets the level at which 'eventType' is logged.  For example, raising LogEventPDUSent and
+// LogEventPDUReceived to LevelInfo logs every PDU without the minimum level being lowered, and
+// LevelOff silences an event type.
+func (eventLogger *EventLogger) SetEventLevel(eventType LogEventType, level LogLevel) {
+	eventLogger.mutex.Lock()
+	defer eventLogger.mutex.Unlock()
+
+	eventLogger.levels[eventType] = level
+}
+
+// Enabled returns true if 'eventType' is logged
+func (eventLogger *EventLogger) Enabled(eventType LogEventType) bool {
+	_, enabled := eventLogger.levelFor(eventType)
+	return enabled
+}
+
+func (eventLogger *EventLogger) levelFor(eventType LogEventType) (LogLevel, bool) {
+	if eventLogger == nil {
+		return LevelOff, false
+	}
+
+	eventLogger.mutex.Lock()
+	defer eventLogger.mutex.Unlock()
+
+	level := eventLogger.levels[eventType]
+
+	return level, level != LevelOff && level >= eventLogger.minimum
+}
+
+// Log passes an entry for 'eventType' to the Logger, if the event type is logged
+func (eventLogger *EventLogger) Log(eventType LogEventType, message string, fields ...LogField) {
+	level, enabled := eventLogger.levelFor(eventType)
+	if !enabled {
+		return
+	}
+
+	eventLogger.logger.Log(&LogEntry{Time: time.Now(), Level: level, Event: eventType, Message: message, Fields: fields})
+}
+
+// pduLogFields returns the fields that describe a PDU in a log entry
+func pduLogFields(pdu *PDU) []LogField {
+	return []LogField{
+		{"command", pdu.CommandName()},
+		{"sequence", pdu.SequenceNumber},
+		{"status", CommandStatusName(pdu.CommandStatus)},
+		{"length", pdu.ComputeLength()},
+	}
+}
@@ -0,0 +1,184 @@
+package smpp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// entryRecorder is a Logger that keeps every entry
+type entryRecorder struct {
+	mutex   sync.Mutex
+	entries []*LogEntry
+}
+
+func (recorder *entryRecorder) Log(entry *LogEntry) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	recorder.entries = append(recorder.entries, entry)
+}
+
+// find returns the entries for 'eventType' that have the field 'key' with the value 'value'
+func (recorder *entryRecorder) find(eventType LogEventType, key string, value interface{}) []*LogEntry {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	found := make([]*LogEntry, 0)
+	for _, entry := range recorder.entries {
+		if entry.Event != eventType {
+			continue
+		}
+		for _, field := range entry.Fields {
+			if field.Key == key && field.Value == value {
+				found = append(found, entry)
+				break
+			}
+		}
+	}
+
+	return found
+}
+
+func TestEventLoggerLevels(t *testing.T) {
+	recorder := &entryRecorder{}
+	eventLogger := NewEventLogger(recorder)
+
+	eventLogger.Log(LogEventBind, "Bound")
+	eventLogger.Log(LogEventPDUSent, "Sent PDU")
+	eventLogger.SetEventLevel(LogEventPDUSent, LevelInfo)
+	eventLogger.Log(LogEventPDUSent, "Sent PDU")
+	eventLogger.SetEventLevel(LogEventStateChange, LevelOff)
+	eventLogger.SetMinimumLevel(LevelDebug)
+	eventLogger.Log(LogEventStateChange, "Session state changed")
+	eventLogger.SetMinimumLevel(LevelError)
+	eventLogger.Log(LogEventTimeout, "Request timed out")
+	eventLogger.Log(LogEventError, "Session closed by error")
+
+	expected := []struct {
+		eventType LogEventType
+		level     LogLevel
+	}{
+		{LogEventBind, LevelInfo},
+		{LogEventPDUSent, LevelInfo},
+		{LogEventError, LevelError},
+	}
+
+	if len(recorder.entries) != len(expected) {
+		t.Fatalf("Expected (%d) entries, got (%d)", len(expected), len(recorder.entries))
+	}
+	for i, entry := range recorder.entries {
+		if entry.Event != expected[i].eventType || entry.Level != expected[i].level {
+			t.Errorf("Expected entry (%d) to be (%s) at (%s), got (%s) at (%s)", i, expected[i].eventType, expected[i].level, entry.Event, entry.Level)
+		}
+	}
+
+	var nilLogger *EventLogger
+	nilLogger.Log(LogEventError, "Not logged")
+}
+
+func TestLoggerAdapters(t *testing.T) {
+	entry := &LogEntry{
+		Time:    time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Level:   LevelWarn,
+		Event:   LogEventTimeout,
+		Message: "Request timed out",
+		Fields:  []LogField{{"command", "submit-sm"}, {"sequence", uint32(7)}, {"error", errors.New("Timed out")}},
+	}
+
+	var jsonOutput bytes.Buffer
+	NewJSONLinesLogger(&jsonOutput).Log(entry)
+
+	expectedJSON := `{"time":"2024-05-01T12:00:00Z","level":"warn","event":"timeout","message":"Request timed out","command":"submit-sm","sequence":7,"error":"Timed out"}` + "\n"
+	if jsonOutput.String() != expectedJSON {
+		t.Errorf("Expected JSON line (%s), got (%s)", expectedJSON, jsonOutput.String())
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(jsonOutput.Bytes(), &decoded); err != nil {
+		t.Errorf("Expected JSON line to decode, but got error = (%s)", err)
+	}
+
+	var standardOutput bytes.Buffer
+	NewStandardLogger(log.New(&standardOutput, "", 0)).Log(entry)
+
+	expectedLine := "warn timeout: Request timed out command=submit-sm sequence=7 error=Timed out\n"
+	if standardOutput.String() != expectedLine {
+		t.Errorf("Expected log line (%s), got (%s)", expectedLine, standardOutput.String())
+	}
+}
+
+func TestSessionActivityIsLogged(t *testing.T) {
+	smscRecorder, esmeRecorder := &entryRecorder{}, &entryRecorder{}
+
+	smsc := NewSMSC("smsc01", PasswordAuthenticator{"esme01": "secret"}, BaseSMSCHandler{})
+	smscLogger := NewEventLogger(smscRecorder)
+	smsc.SetEventLogger(smscLogger)
+	defer smsc.Shutdown(context.Background())
+	smscAddr, _ := startSMSC(t, smsc)
+
+	esme := &ESME{}
+	esmeLogger := NewEventLogger(esmeRecorder)
+	esmeLogger.SetMinimumLevel(LevelDebug)
+	esme.SetEventLogger(esmeLogger)
+
+	peer := connectAndBindOrFail(t, esme, smscAddr, BindInfo{Type: TransmitterBind, SystemID: "esme01", Password: "secret"})
+
+	future, err := peer.SendRequest(newShortMessagePDU(CommandSubmitSm, 0, "hello"))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+	if _, err := future.Wait(); err != nil {
+		t.Fatalf("Expected no error on Wait(), but got error = (%s)", err)
+	}
+
+	if _, err := esme.ConnectToPeer(smscAddr.IP, 1); err == nil {
+		t.Fatalf("Expected connection to closed port to fail")
+	}
+
+	for _, expected := range []struct {
+		recorder  *entryRecorder
+		eventType LogEventType
+		key       string
+		value     interface{}
+	}{
+		{esmeRecorder, LogEventConnect, "remote_addr", smscAddr.String()},
+		{esmeRecorder, LogEventBind, "smsc_system_id", "smsc01"},
+		{esmeRecorder, LogEventStateChange, "to", StateBoundTx.String()},
+		{esmeRecorder, LogEventPDUSent, "command", CommandName(CommandSubmitSm)},
+		{esmeRecorder, LogEventPDUReceived, "status", CommandStatusName(EsmeROK)},
+		{esmeRecorder, LogEventError, "remote_addr", strings.Split(smscAddr.String(), ":")[0] + ":1"},
+		{smscRecorder, LogEventConnect, "local_addr", smscAddr.String()},
+		{smscRecorder, LogEventBind, "system_id", "esme01"},
+	} {
+		if len(expected.recorder.find(expected.eventType, expected.key, expected.value)) == 0 {
+			t.Errorf("Expected (%s) entry with (%s=%v)", expected.eventType, expected.key, expected.value)
+		}
+	}
+
+	if entries := smscRecorder.find(LogEventPDUReceived, "command", CommandName(CommandSubmitSm)); len(entries) != 0 {
+		t.Errorf("Expected no PDU entries below the minimum level, got (%d)", len(entries))
+	}
+}
+
+func TestTimeoutIsLogged(t *testing.T) {
+	peer, _ := newLoopbackPeer(t, roleESME)
+	recorder := &entryRecorder{}
+	peer.SetEventLogger(NewEventLogger(recorder))
+
+	future, err := peer.SendRequestWithOptions(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{}), RequestOptions{Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequestWithOptions(), but got error = (%s)", err)
+	}
+	future.Wait()
+
+	if entries := recorder.find(LogEventTimeout, "command", CommandName(CommandEnquireLink)); len(entries) != 1 || entries[0].Level != LevelWarn {
+		t.Errorf("Expected one warn entry for the timed out enquire_link, got (%v)", entries)
+	}
+}
@@ -0,0 +1,456 @@
+package smpp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// ReconnectPolicy controls the delay between connection attempts by a ManagedPeer.  After the n-th
+// consecutive failure, the delay is InitialBackoff * Multiplier^(n-1), limited to MaxBackoff, and then
+// varied randomly by up to +/- Jitter (a fraction of the delay).  AlreadyBoundDelay is used instead
+// when the SMSC answers the bind with ESME_RALYBND, because the SMSC still holds the previous bind and
+// an immediate retry is pointless.
+type ReconnectPolicy struct {
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	Multiplier        float64
+	Jitter            float64
+	AlreadyBoundDelay time.Duration
+}
+
+// DefaultReconnectPolicy is the ReconnectPolicy of a new ManagedPeer
+var DefaultReconnectPolicy = ReconnectPolicy{
+	InitialBackoff:    time.Second,
+	MaxBackoff:        time.Minute,
+	Multiplier:        2,
+	Jitter:            0.2,
+	AlreadyBoundDelay: time.Minute,
+}
+
+// delay returns the delay before the next attempt after 'failures' consecutive failures
+func (policy ReconnectPolicy) delay(failures int) time.Duration {
+	delay := float64(policy.InitialBackoff) * math.Pow(policy.Multiplier, float64(failures-1))
+	if delay > float64(policy.MaxBackoff) {
+		delay = float64(policy.MaxBackoff)
+	}
+
+	delay *= 1 + policy.Jitter*(2*rand.Float64()-1)
+	if delay < 0 {
+		delay = 0
+	}
+
+	return time.Duration(delay)
+}
+
+// InFlightPolicy determines what a ManagedPeer does with a request when there is no bound session,
+// or when the session closes before the response arrives
+type InFlightPolicy int
+
+const (
+	// FailInFlightRequests fails the request
+	FailInFlightRequests InFlightPolicy = iota
+	// RetryInFlightRequests holds the request until a session is bound, then sends it (again)
+	RetryInFlightRequests
+)
+
+// LifecycleEventType identifies a ManagedPeer lifecycle event
+type LifecycleEventType int
+
+const (
+	// LifecycleConnecting is reported before each connection attempt
+	LifecycleConnecting LifecycleEventType = iota
+	// LifecycleConnectFailed is reported when the transport cannot be connected, or fails during the bind
+	LifecycleConnectFailed
+	// LifecycleBindRejected is reported when the SMSC answers the bind with an error status
+	LifecycleBindRejected
+	// LifecycleBound is reported when a session is bound
+	LifecycleBound
+	// LifecycleDisconnected is reported when a bound session closes
+	LifecycleDisconnected
+	// LifecycleBackoff is reported before waiting to reconnect.  Delay is the wait.
+	LifecycleBackoff
+	// LifecycleStopped is reported when the ManagedPeer stops, either because Stop() was called or
+	// because the bind was rejected with a status that retrying cannot fix
+	LifecycleStopped
+)
+
+// LifecycleEvent is passed to a LifecycleObserver.  Peer is the session to which the event applies,
+// if there is one.  Attempt is the number of consecutive failed attempts.
+type LifecycleEvent struct {
+	Type    LifecycleEventType
+	Peer    *Peer
+	Attempt int
+	Delay   time.Duration
+	Err     error
+}
+
+// LifecycleObserver is a function that receives events from a ManagedPeer
+type LifecycleObserver func(event *LifecycleEvent)
+
+// ErrNotBound is returned by ManagedPeer.SendRequest() under FailInFlightRequests when there is no
+// bound session
+var ErrNotBound = errors.New("No bound session")
+
+// ManagedPeer maintains a bound session from an ESME to an SMSC.  When the transport fails, or the
+// SMSC unbinds, it reconnects and rebinds according to its ReconnectPolicy.  A bind rejected with
+// ESME_RBINDFAIL is retried with backoff, and one rejected with ESME_RALYBND is retried after the
+// AlreadyBoundDelay.  A bind rejected with any other status (e.g., ESME_RINVPASWD) stops the ManagedPeer.
+// Each session is bound with ESME.BindToPeer(), so the requests that the SMSC sends are passed to the
+// handler given to the ESME's StartListenLoop().
+type ManagedPeer struct {
+	esme       *ESME
+	remoteAddr net.IP
+	remotePort uint16
+	dial       DialFunc
+	bind       BindInfo
+
+	mutex              sync.Mutex
+	policy             ReconnectPolicy
+	inFlightPolicy     InFlightPolicy
+	configureSession   func(peer *Peer)
+	observers          []LifecycleObserver
+	currentPeer        *Peer
+	currentPeerChanged chan struct{}
+	started            bool
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewManagedPeer creates a ManagedPeer that connects over TCP to the SMSC at 'remoteAddr' and
+// 'remotePort' and binds using 'bind'.  It does nothing until Start() is called.
+func NewManagedPeer(esme *ESME, remoteAddr net.IP, remotePort uint16, bind BindInfo) *ManagedPeer {
+	managed := NewManagedPeerWithDialer(esme, nil, bind)
+	managed.remoteAddr = remoteAddr
+	managed.remotePort = remotePort
+
+	return managed
+}
+
+// NewManagedPeerWithDialer creates a ManagedPeer that connects each transport by calling 'dial', and
+// binds using 'bind'.  It allows the session to run over any net.Conn, such as TLS or a Unix domain
+// socket.  It does nothing until Start() is called.
+func NewManagedPeerWithDialer(esme *ESME, dial DialFunc, bind BindInfo) *ManagedPeer {
+	return &ManagedPeer{
+		esme:               esme,
+		dial:               dial,
+		bind:               bind,
+		policy:             DefaultReconnectPolicy,
+		observers:          make([]LifecycleObserver, 0),
+		currentPeerChanged: make(chan struct{}),
+		stop:               make(chan struct{}),
+		stopped:            make(chan struct{}),
+	}
+}
+
+// SetReconnectPolicy sets the delays between connection attempts
+func (managed *ManagedPeer) SetReconnectPolicy(policy ReconnectPolicy) {
+	managed.mutex.Lock()
+	defer managed.mutex.Unlock()
+
+	managed.policy = policy
+}
+
+// SetInFlightPolicy sets the handling of requests when there is no bound session.  The default is
+// FailInFlightRequests.
+func (managed *ManagedPeer) SetInFlightPolicy(policy InFlightPolicy) {
+	managed.mutex.Lock()
+	defer managed.mutex.Unlock()
+
+	managed.inFlightPolicy = policy
+}
+
+// SetSessionConfigurator sets a function that is called with each new session after the transport is
+// connected and before the bind is sent.  It may be used to set the window size, keepalive and timeouts.
+func (managed *ManagedPeer) SetSessionConfigurator(configure func(peer *Peer)) {
+	managed.mutex.Lock()
+	defer managed.mutex.Unlock()
+
+	managed.configureSession = configure
+}
+
+// AddObserver adds a function that is called for each lifecycle event
+func (managed *ManagedPeer) AddObserver(observer LifecycleObserver) {
+	managed.mutex.Lock()
+	defer managed.mutex.Unlock()
+
+	managed.observers = append(managed.observers, observer)
+}
+
+// Peer returns the current bound session, or nil if there is none
+func (managed *ManagedPeer) Peer() *Peer {
+	managed.mutex.Lock()
+	defer managed.mutex.Unlock()
+
+	return managed.currentPeer
+}
+
+// Start begins connecting in a goroutine
+func (managed *ManagedPeer) Start() {
+	managed.mutex.Lock()
+	defer managed.mutex.Unlock()
+
+	if !managed.started {
+		managed.started = true
+		go managed.run()
+	}
+}
+
+// Stop closes the current session, if any, with Peer.Close(ctx), and stops reconnecting.  It waits
+// until the ManagedPeer has stopped.
+func (managed *ManagedPeer) Stop(ctx context.Context) {
+	managed.mutex.Lock()
+	select {
+	case <-managed.stop:
+	default:
+		close(managed.stop)
+	}
+	started := managed.started
+	peer := managed.currentPeer
+	managed.mutex.Unlock()
+
+	if peer != nil {
+		peer.Close(ctx)
+	}
+
+	if started {
+		<-managed.stopped
+	}
+}
+
+// SendRequest sends a request on the current session.  Under FailInFlightRequests, ErrNotBound is
+// returned if there is no bound session, and the future fails if the session closes before the response
+// arrives.  Under RetryInFlightRequests, the request is held until a session is bound, and is resent
+// on the next session if the session closes before the response arrives.
+func (managed *ManagedPeer) SendRequest(request *PDU) (*ResponseFuture, error) {
+	managed.mutex.Lock()
+	policy := managed.inFlightPolicy
+	peer := managed.currentPeer
+	managed.mutex.Unlock()
+
+	if policy == FailInFlightRequests {
+		if peer == nil {
+			return nil, ErrNotBound
+		}
+		return peer.SendRequest(request)
+	}
+
+	future := newResponseFuture(request)
+	go managed.sendUntilAnswered(future)
+
+	return future, nil
+}
+
+// sendUntilAnswered sends the future's request on each bound session in turn until a session
+// completes it with a response or a timeout
+func (managed *ManagedPeer) sendUntilAnswered(future *ResponseFuture) {
+	for {
+		peer := managed.awaitBoundPeer()
+		if peer == nil {
+			future.complete(nil, fmt.Errorf("ManagedPeer stopped"))
+			return
+		}
+
+		sessionFuture, err := peer.SendRequest(future.request)
+		if err == nil {
+			var response *PDU
+			response, err = sessionFuture.Wait()
+			if err == nil || err == ErrResponseTimeout {
+				future.complete(response, err)
+				return
+			}
+		}
+
+		if peer.State() != StateClosed {
+			future.complete(nil, err)
+			return
+		}
+	}
+}
+
+// awaitBoundPeer returns the current bound session, waiting for one if necessary.  It returns nil if
+// the ManagedPeer stops first.
+func (managed *ManagedPeer) awaitBoundPeer() *Peer {
+	for {
+		managed.mutex.Lock()
+		peer := managed.currentPeer
+		changed := managed.currentPeerChanged
+		managed.mutex.Unlock()
+
+		if peer != nil && peer.State() != StateClosed {
+			return peer
+		}
+
+		select {
+		case <-changed:
+		case <-managed.stop:
+			return nil
+		}
+	}
+}
+
+func (managed *ManagedPeer) run() {
+	defer close(managed.stopped)
+
+	// Stop() aborts a connection attempt or a bind that is in progress
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-managed.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	failures := 0
+
+	for {
+		if managed.isStopping() {
+			managed.notify(&LifecycleEvent{Type: LifecycleStopped})
+			return
+		}
+
+		managed.notify(&LifecycleEvent{Type: LifecycleConnecting, Attempt: failures})
+
+		peer, delay, err := managed.connectAndBind(ctx, failures+1)
+		if err != nil {
+			if delay < 0 {
+				managed.notify(&LifecycleEvent{Type: LifecycleStopped, Err: err})
+				return
+			}
+
+			failures++
+			if !managed.backOff(delay, failures) {
+				managed.notify(&LifecycleEvent{Type: LifecycleStopped})
+				return
+			}
+			continue
+		}
+
+		failures = 0
+		managed.setCurrentPeer(peer)
+		managed.notify(&LifecycleEvent{Type: LifecycleBound, Peer: peer})
+
+		if managed.isStopping() {
+			peer.Disconnect()
+		}
+
+		<-peer.Done()
+
+		managed.setCurrentPeer(nil)
+		managed.notify(&LifecycleEvent{Type: LifecycleDisconnected, Peer: peer, Err: peer.Err()})
+
+		if !managed.backOff(managed.reconnectPolicy().delay(1), 1) {
+			managed.notify(&LifecycleEvent{Type: LifecycleStopped})
+			return
+		}
+	}
+}
+
+// connectAndBind makes one attempt to connect and bind.  On failure, it returns the delay before the
+// next attempt, or a negative delay if there should be no further attempts.
+func (managed *ManagedPeer) connectAndBind(ctx context.Context, attempt int) (*Peer, time.Duration, error) {
+	policy := managed.reconnectPolicy()
+
+	var peer *Peer
+	var err error
+	if managed.dial != nil {
+		peer, err = managed.esme.ConnectToPeerWithDialerContext(ctx, managed.dial)
+	} else {
+		peer, err = managed.esme.ConnectToPeerContext(ctx, managed.remoteAddr, managed.remotePort)
+	}
+	if err != nil {
+		managed.notify(&LifecycleEvent{Type: LifecycleConnectFailed, Attempt: attempt, Err: err})
+		return nil, policy.delay(attempt), err
+	}
+
+	managed.mutex.Lock()
+	configure := managed.configureSession
+	managed.mutex.Unlock()
+
+	if configure != nil {
+		configure(peer)
+	}
+
+	_, _, err = managed.esme.BindToPeerContext(ctx, peer, managed.bind)
+	if err == nil {
+		return peer, 0, nil
+	}
+
+	peer.Disconnect()
+
+	var statusError *CommandStatusError
+	if !errors.As(err, &statusError) {
+		managed.notify(&LifecycleEvent{Type: LifecycleConnectFailed, Peer: peer, Attempt: attempt, Err: err})
+		return nil, policy.delay(attempt), err
+	}
+
+	managed.notify(&LifecycleEvent{Type: LifecycleBindRejected, Peer: peer, Attempt: attempt, Err: err})
+
+	switch statusError.CommandStatus {
+	case EsmeRBindFail:
+		return nil, policy.delay(attempt), err
+	case EsmeRAlyBnd:
+		return nil, policy.AlreadyBoundDelay, err
+	default:
+		return nil, -1, err
+	}
+}
+
+// backOff waits for 'delay', reporting LifecycleBackoff.  It returns false if the ManagedPeer is
+// stopped while waiting.
+func (managed *ManagedPeer) backOff(delay time.Duration, failures int) bool {
+	managed.notify(&LifecycleEvent{Type: LifecycleBackoff, Attempt: failures, Delay: delay})
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-managed.stop:
+		return false
+	}
+}
+
+func (managed *ManagedPeer) isStopping() bool {
+	select {
+	case <-managed.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (managed *ManagedPeer) reconnectPolicy() ReconnectPolicy {
+	managed.mutex.Lock()
+	defer managed.mutex.Unlock()
+
+	return managed.policy
+}
+
+func (managed *ManagedPeer) setCurrentPeer(peer *Peer) {
+	managed.mutex.Lock()
+	defer managed.mutex.Unlock()
+
+	managed.currentPeer = peer
+	close(managed.currentPeerChanged)
+	managed.currentPeerChanged = make(chan struct{})
+}
+
+func (managed *ManagedPeer) notify(event *LifecycleEvent) {
+	managed.mutex.Lock()
+	observers := append([]LifecycleObserver(nil), managed.observers...)
+	managed.mutex.Unlock()
+
+	for _, observer := range observers {
+		observer(event)
+	}
+}
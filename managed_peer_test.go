@@ -0,0 +1,284 @@
+package smpp
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startReconnectingSmsc accepts any number of connections on a loopback port.  For each PDU received
+// on the n-th connection (counting from zero), 'respond' returns the PDUs to write back, and whether
+// to close the connection afterwards.  An unbind is answered without calling 'respond'.
+func startReconnectingSmsc(t *testing.T, respond func(connection int, pdu *PDU) ([]*PDU, bool)) *net.TCPAddr {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on loopback: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for connection := 0; ; connection++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(connection int, conn net.Conn) {
+				defer conn.Close()
+
+				reader := NewNetworkStreamReader(conn)
+				writer := NewNetworkStreamWriter(conn)
+
+				for {
+					pdus, err := reader.Read()
+					if err != nil {
+						return
+					}
+
+					for _, pdu := range pdus {
+						if pdu.CommandID == CommandUnbind {
+							writer.Write(NewPDU(CommandUnbindResp, 0, pdu.SequenceNumber, []*Parameter{}, []*Parameter{}))
+							continue
+						}
+
+						responses, closeConnection := respond(connection, pdu)
+						for _, response := range responses {
+							writer.Write(response)
+						}
+						if closeConnection {
+							return
+						}
+					}
+				}
+			}(connection, conn)
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr)
+}
+
+func bindResponseFor(pdu *PDU, status uint32) *PDU {
+	return NewPDU(pdu.CommandID|0x80000000, status, pdu.SequenceNumber, []*Parameter{NewCOctetStringParameter("smsc")}, []*Parameter{})
+}
+
+// lifecycleRecorder passes each lifecycle event to a channel
+type lifecycleRecorder struct {
+	events chan *LifecycleEvent
+}
+
+func newLifecycleRecorder(managed *ManagedPeer) *lifecycleRecorder {
+	recorder := &lifecycleRecorder{events: make(chan *LifecycleEvent, 100)}
+
+	managed.AddObserver(func(event *LifecycleEvent) {
+		recorder.events <- event
+	})
+
+	return recorder
+}
+
+func (recorder *lifecycleRecorder) awaitEvent(t *testing.T, eventType LifecycleEventType) *LifecycleEvent {
+	timeout := time.After(5 * time.Second)
+
+	for {
+		select {
+		case event := <-recorder.events:
+			if event.Type == eventType {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for lifecycle event (%d)", eventType)
+		}
+	}
+}
+
+var fastReconnectPolicy = ReconnectPolicy{
+	InitialBackoff:    5 * time.Millisecond,
+	MaxBackoff:        20 * time.Millisecond,
+	Multiplier:        2,
+	AlreadyBoundDelay: 150 * time.Millisecond,
+}
+
+func TestManagedPeerReconnectsAfterTransportFailure(t *testing.T) {
+	smscAddr := startReconnectingSmsc(t, func(connection int, pdu *PDU) ([]*PDU, bool) {
+		if pdu.CommandID != CommandBindTransceiver {
+			return nil, false
+		}
+		// the first session is dropped immediately after it is bound
+		return []*PDU{bindResponseFor(pdu, EsmeROK)}, connection == 0
+	})
+
+	managed := NewManagedPeer(&ESME{}, smscAddr.IP, uint16(smscAddr.Port), BindInfo{Type: TransceiverBind, SystemID: "esme01"})
+	managed.SetReconnectPolicy(fastReconnectPolicy)
+	recorder := newLifecycleRecorder(managed)
+
+	managed.Start()
+	defer managed.Stop(context.Background())
+
+	first := recorder.awaitEvent(t, LifecycleBound).Peer
+	recorder.awaitEvent(t, LifecycleDisconnected)
+	second := recorder.awaitEvent(t, LifecycleBound).Peer
+
+	if first == second {
+		t.Errorf("Expected a new session after reconnect")
+	}
+
+	if managed.Peer() != second || second.State() != StateBoundTrx {
+		t.Errorf("Expected the current session to be the second session, bound as transceiver")
+	}
+}
+
+func TestManagedPeerHandlesBindRejections(t *testing.T) {
+	statuses := []uint32{EsmeRBindFail, EsmeRAlyBnd, EsmeRInvPaswd}
+
+	smscAddr := startReconnectingSmsc(t, func(connection int, pdu *PDU) ([]*PDU, bool) {
+		return []*PDU{bindResponseFor(pdu, statuses[connection])}, false
+	})
+
+	managed := NewManagedPeer(&ESME{}, smscAddr.IP, uint16(smscAddr.Port), BindInfo{Type: TransmitterBind, SystemID: "esme01"})
+	managed.SetReconnectPolicy(fastReconnectPolicy)
+	recorder := newLifecycleRecorder(managed)
+
+	managed.Start()
+	defer managed.Stop(context.Background())
+
+	recorder.awaitEvent(t, LifecycleBindRejected)
+	if backoff := recorder.awaitEvent(t, LifecycleBackoff); backoff.Delay > fastReconnectPolicy.MaxBackoff {
+		t.Errorf("Expected ESME_RBINDFAIL backoff of at most (%s), got (%s)", fastReconnectPolicy.MaxBackoff, backoff.Delay)
+	}
+
+	recorder.awaitEvent(t, LifecycleBindRejected)
+	if backoff := recorder.awaitEvent(t, LifecycleBackoff); backoff.Delay != fastReconnectPolicy.AlreadyBoundDelay {
+		t.Errorf("Expected ESME_RALYBND delay of (%s), got (%s)", fastReconnectPolicy.AlreadyBoundDelay, backoff.Delay)
+	}
+
+	recorder.awaitEvent(t, LifecycleBindRejected)
+	stopped := recorder.awaitEvent(t, LifecycleStopped)
+	if stopped.Err == nil {
+		t.Errorf("Expected ESME_RINVPASWD to stop the ManagedPeer with an error")
+	}
+}
+
+func TestManagedPeerRetriesInFlightRequests(t *testing.T) {
+	var mutex sync.Mutex
+	enquireLinksPerConnection := make(map[int]int)
+
+	smscAddr := startReconnectingSmsc(t, func(connection int, pdu *PDU) ([]*PDU, bool) {
+		switch pdu.CommandID {
+		case CommandBindTransceiver:
+			return []*PDU{bindResponseFor(pdu, EsmeROK)}, false
+		case CommandEnquireLink:
+			mutex.Lock()
+			enquireLinksPerConnection[connection]++
+			mutex.Unlock()
+			if connection == 0 {
+				// drop the first session without answering
+				return nil, true
+			}
+			return []*PDU{NewPDU(CommandEnquireLinkResp, 0, pdu.SequenceNumber, []*Parameter{}, []*Parameter{})}, false
+		}
+		return nil, false
+	})
+
+	managed := NewManagedPeer(&ESME{}, smscAddr.IP, uint16(smscAddr.Port), BindInfo{Type: TransceiverBind, SystemID: "esme01"})
+	managed.SetReconnectPolicy(fastReconnectPolicy)
+	managed.SetInFlightPolicy(RetryInFlightRequests)
+
+	// the request is held until the first session is bound
+	future, err := managed.SendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{}))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	managed.Start()
+	defer managed.Stop(context.Background())
+
+	select {
+	case <-future.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for in-flight request to complete")
+	}
+
+	response, err := future.Wait()
+	if err != nil || response.CommandID != CommandEnquireLinkResp {
+		t.Fatalf("Expected enquire-link-resp, got (%v), error = (%v)", response, err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if enquireLinksPerConnection[0] != 1 || enquireLinksPerConnection[1] != 1 {
+		t.Errorf("Expected the request on both sessions, got (%v)", enquireLinksPerConnection)
+	}
+}
+
+func TestManagedPeerFailsRequestsWhenNotBound(t *testing.T) {
+	managed := NewManagedPeer(&ESME{}, net.IPv4(127, 0, 0, 1), 1, BindInfo{})
+
+	if _, err := managed.SendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{})); err != ErrNotBound {
+		t.Errorf("Expected ErrNotBound, got (%v)", err)
+	}
+}
+
+func TestReconnectPolicyDelay(t *testing.T) {
+	policy := ReconnectPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2, Jitter: 0.1}
+
+	for _, testCase := range []struct {
+		failures int
+		nominal  time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{10, time.Second},
+	} {
+		for i := 0; i < 20; i++ {
+			delay := policy.delay(testCase.failures)
+			if delay < testCase.nominal*9/10 || delay > testCase.nominal*11/10 {
+				t.Errorf("delay(%d): expected (%s) +/- 10%%, got (%s)", testCase.failures, testCase.nominal, delay)
+				break
+			}
+		}
+	}
+}
+
+func TestManagedPeerRebindsAfterSmscUnbind(t *testing.T) {
+	unbindResponses := make(chan *PDU, 1)
+
+	smscAddr := startReconnectingSmsc(t, func(connection int, pdu *PDU) ([]*PDU, bool) {
+		switch pdu.CommandID {
+		case CommandBindReceiver:
+			responses := []*PDU{bindResponseFor(pdu, EsmeROK)}
+			if connection == 0 {
+				responses = append(responses, NewPDU(CommandUnbind, 0, 1, []*Parameter{}, []*Parameter{}))
+			}
+			return responses, false
+		case CommandUnbindResp:
+			unbindResponses <- pdu
+			return nil, true
+		}
+		return nil, false
+	})
+
+	managed := NewManagedPeer(&ESME{}, smscAddr.IP, uint16(smscAddr.Port), BindInfo{Type: ReceiverBind, SystemID: "esme01"})
+	managed.SetReconnectPolicy(fastReconnectPolicy)
+	recorder := newLifecycleRecorder(managed)
+
+	managed.Start()
+	defer managed.Stop(context.Background())
+
+	recorder.awaitEvent(t, LifecycleBound)
+
+	select {
+	case response := <-unbindResponses:
+		if response.SequenceNumber != 1 || response.CommandStatus != EsmeROK {
+			t.Errorf("Expected unbind-resp with ESME_ROK and sequence 1, got %s", response)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for unbind-resp")
+	}
+
+	recorder.awaitEvent(t, LifecycleDisconnected)
+	recorder.awaitEvent(t, LifecycleBound)
+}
@@ -0,0 +1,812 @@
+package smpp
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultReceiptTimeout is how long a MessageTracker waits for the final delivery receipt of a message,
+// unless it is changed with SetReceiptTimeout()
+const DefaultReceiptTimeout = 72 * time.Hour
+
+// unmatchedReceiptRetention is the longest that a receipt for an unknown message_id is kept, in case it
+// arrived before the submit_sm_resp that carries the message_id was handled
+const unmatchedReceiptRetention = time.Minute
+
+const (
+	receiptedMessageIDTag = 0x001E
+	messageStateTag       = 0x0427
+	messagePayloadTag     = 0x0424
+)
+
+// MessageState is the state of a message tracked by a MessageTracker
+type MessageState int
+
+const (
+	// MessageSubmitted is the state of a message whose submit_sm_resp has not arrived
+	MessageSubmitted MessageState = iota
+	// MessageEnroute is the state of a message accepted by the SMSC, for which no final delivery receipt
+	// has arrived
+	MessageEnroute
+	// MessageDelivered is the final state of a message whose receipt reports DELIVRD
+	MessageDelivered
+	// MessageExpired is the final state of a message whose receipt reports EXPIRED
+	MessageExpired
+	// MessageDeleted is the final state of a message whose receipt reports DELETED
+	MessageDeleted
+	// MessageUndeliverable is the final state of a message whose receipt reports UNDELIV
+	MessageUndeliverable
+	// MessageAccepted is the final state of a message whose receipt reports ACCEPTD
+	MessageAccepted
+	// MessageUnknown is the final state of a message whose receipt reports UNKNOWN, or a state that is
+	// not recognized
+	MessageUnknown
+	// MessageRejected is the final state of a message whose receipt reports REJECTD
+	MessageRejected
+	// MessageSubmitFailed is the final state of a message whose submit_sm was answered with an error
+	// status, or got no response
+	MessageSubmitFailed
+	// MessageReceiptTimedOut is the final state of a message for which no final receipt arrived within
+	// the receipt timeout
+	MessageReceiptTimedOut
+)
+
+var messageStateString = map[MessageState]string{
+	MessageSubmitted:       "SUBMITTED",
+	MessageEnroute:         "ENROUTE",
+	MessageDelivered:       "DELIVERED",
+	MessageExpired:         "EXPIRED",
+	MessageDeleted:         "DELETED",
+	MessageUndeliverable:   "UNDELIVERABLE",
+	MessageAccepted:        "ACCEPTED",
+	MessageUnknown:         "UNKNOWN",
+	MessageRejected:        "REJECTED",
+	MessageSubmitFailed:    "SUBMIT_FAILED",
+	MessageReceiptTimedOut: "RECEIPT_TIMED_OUT",
+}
+
+func (state MessageState) String() string {
+	return messageStateString[state]
+}
+
+// IsFinal returns true if the message can no longer change state
+func (state MessageState) IsFinal() bool {
+	return state != MessageSubmitted && state != MessageEnroute
+}
+
+// receiptStates maps the message_state TLV values to MessageStates
+var receiptStates = map[uint8]MessageState{
+	1: MessageEnroute,
+	2: MessageDelivered,
+	3: MessageExpired,
+	4: MessageDeleted,
+	5: MessageUndeliverable,
+	6: MessageAccepted,
+	7: MessageUnknown,
+	8: MessageRejected,
+}
+
+// receiptStatStates maps the "stat:" values of a receipt body to MessageStates
+var receiptStatStates = map[string]MessageState{
+	"ENROUTE":       MessageEnroute,
+	"DELIVRD":       MessageDelivered,
+	"DELIVERED":     MessageDelivered,
+	"EXPIRED":       MessageExpired,
+	"DELETED":       MessageDeleted,
+	"UNDELIV":       MessageUndeliverable,
+	"UNDELIVERABLE": MessageUndeliverable,
+	"ACCEPTD":       MessageAccepted,
+	"ACCEPTED":      MessageAccepted,
+	"UNKNOWN":       MessageUnknown,
+	"REJECTD":       MessageRejected,
+	"REJECTED":      MessageRejected,
+}
+
+// TrackedMessage is the record of a message kept by a MessageTracker
+type TrackedMessage struct {
+	// Key is the key given to Track()
+	Key string
+	// MessageID is the message_id from the submit_sm_resp, as the SMSC sent it
+	MessageID string
+	State     MessageState
+	Submitted time.Time
+	// Updated is when the state last changed, or an intermediate receipt arrived
+	Updated time.Time
+	// CommandStatus is the command_status of the submit_sm_resp
+	CommandStatus uint32
+	// Err is why the submit failed, for MessageSubmitFailed
+	Err error
+	// ErrorCode is the "err:" value from the body of the final receipt, if there is one
+	ErrorCode string
+	// Receipt is the deliver_sm or data_sm that carried the final receipt
+	Receipt *PDU
+}
+
+// MessageObserver is called when a tracked message reaches a final state
+type MessageObserver func(message *TrackedMessage)
+
+// MessageStore keeps the records of a MessageTracker.  A message must be found by LoadByMessageID() with
+// the form that NormalizeMessageID() returns for its MessageID.  The methods are called with the tracker's
+// lock held, so they are not called concurrently by one tracker.
+type MessageStore interface {
+	// Save inserts the message, or replaces the message with the same Key
+	Save(message *TrackedMessage) error
+	// Load returns the message with 'key', or nil if there is none
+	Load(key string) (*TrackedMessage, error)
+	// LoadByMessageID returns the message with the normalized message_id 'normalizedID', or nil if there
+	// is none
+	LoadByMessageID(normalizedID string) (*TrackedMessage, error)
+	// Delete removes the message with 'key', if there is one
+	Delete(key string) error
+	// Pending returns every message whose state is not final
+	Pending() ([]*TrackedMessage, error)
+}
+
+// MessageIDFormat says how the message_id of a receipt relates to the message_id of the submit_sm_resp.
+// SMSCs often send the message_id in hex in the submit_sm_resp and in decimal in the receipt (or the
+// reverse).  The literal message_id is always tried first, and the other base only after it.
+type MessageIDFormat int
+
+const (
+	// MessageIDAutomatic matches a receipt message_id in the other base only when that is unambiguous: an
+	// ID with the hex digits a-f is matched by its decimal value, and an ID of decimal digits is matched by
+	// its hex value only when that has one of the digits a-f.  An ID such as "22" is not matched to a
+	// message "16" (0x16), because "16" may just as well be decimal.
+	MessageIDAutomatic MessageIDFormat = iota
+	// MessageIDLiteral matches only the literal message_id
+	MessageIDLiteral
+	// MessageIDHexResponseDecimalReceipt is for an SMSC that sends the message_id in hex in the
+	// submit_sm_resp and in decimal in receipts
+	MessageIDHexResponseDecimalReceipt
+	// MessageIDDecimalResponseHexReceipt is for an SMSC that sends the message_id in decimal in the
+	// submit_sm_resp and in hex in receipts
+	MessageIDDecimalResponseHexReceipt
+)
+
+// NormalizeMessageID returns the form under which a message_id is matched: the ID lowercased, without
+// surrounding spaces or leading zeros
+func NormalizeMessageID(messageID string) string {
+	trimmed := strings.TrimSpace(messageID)
+
+	literal := strings.TrimLeft(strings.ToLower(trimmed), "0")
+	if literal == "" && trimmed != "" {
+		return "0"
+	}
+
+	return literal
+}
+
+// receiptMessageIDs returns the normalized message_ids of tracked messages that a receipt with
+// 'messageID' may report on, in the order in which they are tried
+func receiptMessageIDs(messageID string, format MessageIDFormat) []string {
+	literal := NormalizeMessageID(messageID)
+	if literal == "" {
+		return []string{}
+	}
+
+	converted := ""
+	switch format {
+	case MessageIDAutomatic:
+		if strings.ContainsAny(literal, "abcdef") {
+			if value, err := strconv.ParseUint(literal, 16, 64); err == nil {
+				converted = strconv.FormatUint(value, 10)
+			}
+		} else if value, err := strconv.ParseUint(literal, 10, 64); err == nil {
+			if hex := strconv.FormatUint(value, 16); strings.ContainsAny(hex, "abcdef") {
+				converted = hex
+			}
+		}
+	case MessageIDHexResponseDecimalReceipt:
+		if value, err := strconv.ParseUint(literal, 10, 64); err == nil {
+			converted = strconv.FormatUint(value, 16)
+		}
+	case MessageIDDecimalResponseHexReceipt:
+		if value, err := strconv.ParseUint(literal, 16, 64); err == nil {
+			converted = strconv.FormatUint(value, 10)
+		}
+	}
+
+	if converted == "" || converted == literal {
+		return []string{literal}
+	}
+
+	return []string{literal, converted}
+}
+
+// MessageTracker follows each submitted message to its final state.  A message is tracked from its
+// ResponseFuture, which supplies the message_id, and is matched to the delivery receipts (deliver_sm or
+// data_sm with an esm_class of delivery receipt or intermediate notification) passed to HandleReceipt(),
+// usually by adding Interceptor() to the sessions.  The message_id of a receipt is taken from the
+// receipted_message_id TLV, or else from the "id:" of the receipt body, and its state from the
+// message_state TLV, or else from "stat:".  When a message reaches a final state, the observers are
+// called and the message is removed from the store.  Messages that get no final receipt within the
+// receipt timeout are moved to MessageReceiptTimedOut by RunContext().
+type MessageTracker struct {
+	store MessageStore
+
+	mutex           sync.Mutex
+	receiptTimeout  time.Duration
+	messageIDFormat MessageIDFormat
+	observers       []MessageObserver
+	eventLogger     *EventLogger
+	unmatched       map[string]*unmatchedReceipt
+}
+
+// deliveryReceipt is what a receipt says about a message
+type deliveryReceipt struct {
+	messageID string
+	state     MessageState
+	errorCode string
+	pdu       *PDU
+}
+
+// unmatchedReceipt is a receipt that matched no message, kept under each of 'messageIDs'
+type unmatchedReceipt struct {
+	receipt    *deliveryReceipt
+	messageIDs []string
+	arrived    time.Time
+}
+
+// NewMessageTracker creates a MessageTracker that keeps its records in 'store'.  If 'store' is nil, a
+// MemoryMessageStore is used.
+func NewMessageTracker(store MessageStore) *MessageTracker {
+	if store == nil {
+		store = NewMemoryMessageStore()
+	}
+
+	return &MessageTracker{
+		store:          store,
+		receiptTimeout: DefaultReceiptTimeout,
+		unmatched:      make(map[string]*unmatchedReceipt),
+	}
+}
+
+// SetReceiptTimeout sets how long, after the submit_sm_resp or the last intermediate receipt, a message
+// waits for its final receipt
+func (tracker *MessageTracker) SetReceiptTimeout(timeout time.Duration) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.receiptTimeout = timeout
+}
+
+// SetMessageIDFormat sets how the message_id of a receipt is matched to the message_id of a
+// submit_sm_resp, for receipts passed to HandleReceipt() or Interceptor().  The default is
+// MessageIDAutomatic.
+func (tracker *MessageTracker) SetMessageIDFormat(format MessageIDFormat) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.messageIDFormat = format
+}
+
+// AddObserver adds a function that is called, synchronously, each time a message reaches a final state
+func (tracker *MessageTracker) AddObserver(observer MessageObserver) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.observers = append(tracker.observers, observer)
+}
+
+// SetEventLogger sets the EventLogger to which errors from the store are logged, when they cannot be
+// returned to a caller
+func (tracker *MessageTracker) SetEventLogger(eventLogger *EventLogger) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	tracker.eventLogger = eventLogger
+}
+
+// Track begins tracking the message whose submit_sm (or data_sm) is the request of 'future', under
+// 'key'.  ErrMessageAlreadyTracked is returned if a message is already tracked under 'key'.
+func (tracker *MessageTracker) Track(key string, future *ResponseFuture) error {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	existing, err := tracker.store.Load(key)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrMessageAlreadyTracked
+	}
+
+	now := time.Now()
+	if err := tracker.store.Save(&TrackedMessage{Key: key, State: MessageSubmitted, Submitted: now, Updated: now}); err != nil {
+		return err
+	}
+
+	go func() {
+		response, err := future.Wait()
+		tracker.submitted(key, future.Request(), response, err)
+	}()
+
+	return nil
+}
+
+// Message returns the message tracked under 'key', or nil if there is none.  A message is no longer
+// tracked once it has reached a final state.
+func (tracker *MessageTracker) Message(key string) (*TrackedMessage, error) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	return tracker.store.Load(key)
+}
+
+// Forget stops tracking the message under 'key', without notifying the observers
+func (tracker *MessageTracker) Forget(key string) error {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+
+	return tracker.store.Delete(key)
+}
+
+// Interceptor returns an Interceptor that passes every inbound request to HandleReceipt().  The PDUs
+// are not changed, so receipts still reach the session's handler.
+func (tracker *MessageTracker) Interceptor() Interceptor {
+	return func(peer *Peer, direction PDUDirection, pdu *PDU) Interception {
+		if direction == DirectionInbound && pdu.IsRequest() {
+			tracker.HandleReceipt(pdu)
+		}
+		return PassPDU(pdu)
+	}
+}
+
+// InterceptorWithMessageIDFormat is the same as Interceptor(), except that the receipts are matched
+// using 'format' rather than the tracker's MessageIDFormat.  It is added to the sessions with an SMSC
+// whose message_id format is known.
+func (tracker *MessageTracker) InterceptorWithMessageIDFormat(format MessageIDFormat) Interceptor {
+	return func(peer *Peer, direction PDUDirection, pdu *PDU) Interception {
+		if direction == DirectionInbound && pdu.IsRequest() {
+			tracker.HandleReceiptWithMessageIDFormat(pdu, format)
+		}
+		return PassPDU(pdu)
+	}
+}
+
+// HandleReceipt applies 'pdu' to the tracked message that it reports on, if it is a delivery receipt.
+// It returns true if 'pdu' is a delivery receipt.  A receipt whose message_id is not (yet) known is kept
+// for a short while, because it may arrive before the submit_sm_resp that carries the message_id has
+// been handled.
+func (tracker *MessageTracker) HandleReceipt(pdu *PDU) bool {
+	tracker.mutex.Lock()
+	format := tracker.messageIDFormat
+	tracker.mutex.Unlock()
+
+	return tracker.HandleReceiptWithMessageIDFormat(pdu, format)
+}
+
+// HandleReceiptWithMessageIDFormat is the same as HandleReceipt(), except that the receipt is matched
+// using 'format' rather than the tracker's MessageIDFormat
+func (tracker *MessageTracker) HandleReceiptWithMessageIDFormat(pdu *PDU, format MessageIDFormat) bool {
+	receipt, isReceipt := parseDeliveryReceipt(pdu)
+	if !isReceipt {
+		return false
+	}
+
+	messageIDs := receiptMessageIDs(receipt.messageID, format)
+
+	tracker.mutex.Lock()
+	message, err := tracker.loadByMessageID(messageIDs)
+	if err == nil && message == nil {
+		tracker.keepUnmatched(&unmatchedReceipt{receipt: receipt, messageIDs: messageIDs, arrived: time.Now()})
+	}
+	var final *TrackedMessage
+	if err == nil && message != nil {
+		final, err = tracker.applyReceipt(message, receipt)
+	}
+	tracker.mutex.Unlock()
+
+	tracker.finish(final, err)
+
+	return true
+}
+
+// RunContext moves each message that has waited longer than the receipt timeout for its final receipt
+// to MessageReceiptTimedOut, until 'ctx' is done.  It returns ctx.Err().
+func (tracker *MessageTracker) RunContext(ctx context.Context) error {
+	for {
+		tracker.mutex.Lock()
+		interval := tracker.receiptTimeout / 4
+		tracker.mutex.Unlock()
+
+		if interval > time.Minute {
+			interval = time.Minute
+		} else if interval < time.Millisecond {
+			interval = time.Millisecond
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+			tracker.expireOverdue(time.Now())
+		}
+	}
+}
+
+// submitted records the result of the submit_sm of the message under 'key'
+func (tracker *MessageTracker) submitted(key string, request *PDU, response *PDU, err error) {
+	tracker.mutex.Lock()
+
+	message, loadErr := tracker.store.Load(key)
+	if loadErr != nil || message == nil || message.State != MessageSubmitted {
+		tracker.mutex.Unlock()
+		tracker.finish(nil, loadErr)
+		return
+	}
+
+	message.Updated = time.Now()
+
+	switch {
+	case err != nil:
+		message.State = MessageSubmitFailed
+		message.Err = err
+	case response.CommandStatus != EsmeROK || response.CommandID == CommandGenericNack:
+		message.State = MessageSubmitFailed
+		message.CommandStatus = response.CommandStatus
+		message.Err = &CommandStatusError{RequestCommandID: request.CommandID, ResponseCommandID: response.CommandID, CommandStatus: response.CommandStatus}
+	default:
+		message.State = MessageEnroute
+		if len(response.MandatoryParameters) > 0 {
+			message.MessageID, _ = response.MandatoryParameters[0].Value.(string)
+		}
+	}
+
+	var final *TrackedMessage
+	if message.State.IsFinal() {
+		final, err = message, tracker.store.Delete(key)
+	} else if err = tracker.store.Save(message); err == nil {
+		if early := tracker.takeUnmatched(message.MessageID); early != nil {
+			final, err = tracker.applyReceipt(message, early)
+		}
+	}
+
+	tracker.mutex.Unlock()
+
+	tracker.finish(final, err)
+}
+
+// applyReceipt updates 'message' from 'receipt', and returns the message if it is now final.  The
+// caller holds the lock.
+func (tracker *MessageTracker) applyReceipt(message *TrackedMessage, receipt *deliveryReceipt) (*TrackedMessage, error) {
+	message.Updated = time.Now()
+
+	if !receipt.state.IsFinal() {
+		return nil, tracker.store.Save(message)
+	}
+
+	message.State = receipt.state
+	message.ErrorCode = receipt.errorCode
+	message.Receipt = receipt.pdu
+
+	return message, tracker.store.Delete(message.Key)
+}
+
+// expireOverdue moves every message whose receipt timeout has passed at 'now' to MessageReceiptTimedOut,
+// and discards old unmatched receipts
+func (tracker *MessageTracker) expireOverdue(now time.Time) {
+	tracker.mutex.Lock()
+
+	retention := unmatchedReceiptRetention
+	if tracker.receiptTimeout < retention {
+		retention = tracker.receiptTimeout
+	}
+	for messageID, unmatched := range tracker.unmatched {
+		if now.Sub(unmatched.arrived) >= retention {
+			delete(tracker.unmatched, messageID)
+		}
+	}
+
+	pending, err := tracker.store.Pending()
+	if err != nil {
+		tracker.mutex.Unlock()
+		tracker.finish(nil, err)
+		return
+	}
+
+	expired := make([]*TrackedMessage, 0)
+	deleteErrors := make([]error, 0)
+	for _, message := range pending {
+		if now.Sub(message.Updated) < tracker.receiptTimeout {
+			continue
+		}
+
+		message.State = MessageReceiptTimedOut
+		message.Updated = now
+		if deleteErr := tracker.store.Delete(message.Key); deleteErr != nil {
+			deleteErrors = append(deleteErrors, deleteErr)
+		} else {
+			expired = append(expired, message)
+		}
+	}
+
+	tracker.mutex.Unlock()
+
+	sort.Slice(expired, func(i, j int) bool { return expired[i].Submitted.Before(expired[j].Submitted) })
+	for _, message := range expired {
+		tracker.finish(message, nil)
+	}
+	for _, deleteErr := range deleteErrors {
+		tracker.finish(nil, deleteErr)
+	}
+}
+
+// loadByMessageID returns the message with the first of 'messageIDs' that is tracked.  The caller holds
+// the lock.
+func (tracker *MessageTracker) loadByMessageID(messageIDs []string) (*TrackedMessage, error) {
+	for _, messageID := range messageIDs {
+		message, err := tracker.store.LoadByMessageID(messageID)
+		if err != nil || message != nil {
+			return message, err
+		}
+	}
+
+	return nil, nil
+}
+
+// keepUnmatched keeps a receipt that matched no message under each of its message_ids.  A receipt does
+// not displace one kept under its literal message_id.  The caller holds the lock.
+func (tracker *MessageTracker) keepUnmatched(unmatched *unmatchedReceipt) {
+	for i, messageID := range unmatched.messageIDs {
+		if existing, isKnown := tracker.unmatched[messageID]; isKnown && i > 0 && existing.messageIDs[0] == messageID {
+			continue
+		}
+		tracker.unmatched[messageID] = unmatched
+	}
+}
+
+// takeUnmatched removes and returns a receipt that arrived for 'messageID' before it was known.  The
+// caller holds the lock.
+func (tracker *MessageTracker) takeUnmatched(messageID string) *deliveryReceipt {
+	unmatched, isKnown := tracker.unmatched[NormalizeMessageID(messageID)]
+	if !isKnown {
+		return nil
+	}
+
+	for _, receiptMessageID := range unmatched.messageIDs {
+		if tracker.unmatched[receiptMessageID] == unmatched {
+			delete(tracker.unmatched, receiptMessageID)
+		}
+	}
+
+	return unmatched.receipt
+}
+
+// finish notifies the observers of a message that has reached a final state, and logs a store error.
+// The caller does not hold the lock.
+func (tracker *MessageTracker) finish(final *TrackedMessage, err error) {
+	tracker.mutex.Lock()
+	observers := tracker.observers
+	eventLogger := tracker.eventLogger
+	tracker.mutex.Unlock()
+
+	if err != nil {
+		eventLogger.Log(LogEventError, "Message store failed", LogField{"error", err})
+	}
+
+	if final != nil {
+		for _, observer := range observers {
+			observer(final)
+		}
+	}
+}
+
+// parseDeliveryReceipt returns what 'pdu' reports, if it is a delivery receipt with a message_id
+func parseDeliveryReceipt(pdu *PDU) (*deliveryReceipt, bool) {
+	if pdu.CommandID != CommandDeliverSm && pdu.CommandID != CommandDataSm {
+		return nil, false
+	}
+
+	// esm_class is the eighth mandatory parameter of both deliver_sm and data_sm
+	if len(pdu.MandatoryParameters) < 8 {
+		return nil, false
+	}
+	if esmClass, isUint8 := pdu.MandatoryParameters[7].Value.(uint8); !isUint8 || (esmClass&0x3c != 0x04 && esmClass&0x3c != 0x20) {
+		return nil, false
+	}
+
+	body := receiptBody(pdu)
+	receipt := &deliveryReceipt{state: MessageUnknown, errorCode: receiptBodyField(body, "err"), pdu: pdu}
+
+	if messageID, isPresent := optionalString(pdu, receiptedMessageIDTag); isPresent {
+		receipt.messageID = messageID
+	} else {
+		receipt.messageID = receiptBodyField(body, "id")
+	}
+
+	if state, isPresent := optionalUint8(pdu, messageStateTag); isPresent {
+		if mapped, isKnown := receiptStates[state]; isKnown {
+			receipt.state = mapped
+		}
+	} else if mapped, isKnown := receiptStatStates[strings.ToUpper(receiptBodyField(body, "stat"))]; isKnown {
+		receipt.state = mapped
+	}
+
+	return receipt, receipt.messageID != ""
+}
+
+// receiptBody returns the short_message of a deliver_sm, or else its message_payload TLV
+func receiptBody(pdu *PDU) string {
+	if pdu.CommandID == CommandDeliverSm && len(pdu.MandatoryParameters) > 17 {
+		if shortMessage, isBytes := pdu.MandatoryParameters[17].Value.([]byte); isBytes && len(shortMessage) > 0 {
+			return string(shortMessage)
+		}
+	}
+
+	payload, _ := optionalString(pdu, messagePayloadTag)
+
+	return payload
+}
+
+// receiptBodyField returns the value of 'name' in a receipt body of the form "id:IIII sub:SSS dlvrd:DDD
+// submit date:YYMMDDhhmm done date:YYMMDDhhmm stat:DDDDDDD err:E text:...", or "" if it is absent
+func receiptBodyField(body string, name string) string {
+	lowered := strings.ToLower(body)
+	prefix := name + ":"
+
+	for start := 0; start < len(lowered); {
+		offset := strings.Index(lowered[start:], prefix)
+		if offset < 0 {
+			return ""
+		}
+		offset += start
+
+		if offset == 0 || lowered[offset-1] == ' ' {
+			value := body[offset+len(prefix):]
+			if end := strings.IndexByte(value, ' '); end >= 0 {
+				value = value[:end]
+			}
+			return value
+		}
+
+		start = offset + len(prefix)
+	}
+
+	return ""
+}
+
+// optionalString returns the value of the TLV with 'tag' as a string, without a null terminator
+func optionalString(pdu *PDU, tag uint16) (string, bool) {
+	for _, param := range pdu.OptionalParameters {
+		if tlv, isTLV := param.Value.(TLV); isTLV && tlv.Tag == tag {
+			switch value := tlv.Value.(type) {
+			case string:
+				return strings.TrimRight(value, "\x00"), true
+			case []byte:
+				return string(bytes.TrimRight(value, "\x00")), true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// optionalUint8 returns the value of the TLV with 'tag', if it is one octet
+func optionalUint8(pdu *PDU, tag uint16) (uint8, bool) {
+	for _, param := range pdu.OptionalParameters {
+		if tlv, isTLV := param.Value.(TLV); isTLV && tlv.Tag == tag {
+			switch value := tlv.Value.(type) {
+			case uint8:
+				return value, true
+			case []byte:
+				if len(value) == 1 {
+					return value[0], true
+				}
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// MemoryMessageStore is a MessageStore that keeps the messages in memory
+type MemoryMessageStore struct {
+	mutex       sync.Mutex
+	messages    map[string]*TrackedMessage
+	byMessageID map[string]string
+}
+
+// NewMemoryMessageStore creates an empty MemoryMessageStore
+func NewMemoryMessageStore() *MemoryMessageStore {
+	return &MemoryMessageStore{messages: make(map[string]*TrackedMessage), byMessageID: make(map[string]string)}
+}
+
+// Save inserts a copy of the message, or replaces the message with the same Key
+func (store *MemoryMessageStore) Save(message *TrackedMessage) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.unindex(message.Key)
+
+	saved := *message
+	store.messages[message.Key] = &saved
+	if messageID := NormalizeMessageID(message.MessageID); messageID != "" {
+		store.byMessageID[messageID] = message.Key
+	}
+
+	return nil
+}
+
+// Load returns a copy of the message with 'key', or nil if there is none
+func (store *MemoryMessageStore) Load(key string) (*TrackedMessage, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.copyOf(key), nil
+}
+
+// LoadByMessageID returns a copy of the message with the normalized message_id 'normalizedID', or nil if
+// there is none
+func (store *MemoryMessageStore) LoadByMessageID(normalizedID string) (*TrackedMessage, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key, isKnown := store.byMessageID[normalizedID]
+	if !isKnown {
+		return nil, nil
+	}
+
+	return store.copyOf(key), nil
+}
+
+// Delete removes the message with 'key', if there is one
+func (store *MemoryMessageStore) Delete(key string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.unindex(key)
+	delete(store.messages, key)
+
+	return nil
+}
+
+// Pending returns copies of every message whose state is not final
+func (store *MemoryMessageStore) Pending() ([]*TrackedMessage, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	pending := make([]*TrackedMessage, 0, len(store.messages))
+	for key, message := range store.messages {
+		if !message.State.IsFinal() {
+			pending = append(pending, store.copyOf(key))
+		}
+	}
+
+	return pending, nil
+}
+
+// Len returns the number of messages in the store
+func (store *MemoryMessageStore) Len() int {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return len(store.messages)
+}
+
+func (store *MemoryMessageStore) copyOf(key string) *TrackedMessage {
+	message, isKnown := store.messages[key]
+	if !isKnown {
+		return nil
+	}
+
+	copied := *message
+	return &copied
+}
+
+// unindex removes the message_id that refers to the message with 'key'
+func (store *MemoryMessageStore) unindex(key string) {
+	if message, isKnown := store.messages[key]; isKnown {
+		messageID := NormalizeMessageID(message.MessageID)
+		if store.byMessageID[messageID] == key {
+			delete(store.byMessageID, messageID)
+		}
+	}
+}
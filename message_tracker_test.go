@@ -0,0 +1,317 @@
+package smpp
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newReceiptPDU returns a deliver_sm delivery receipt with the body 'text'
+func newReceiptPDU(sequenceNumber uint32, text string, optionalParameters ...*Parameter) *PDU {
+	pdu := newShortMessagePDU(CommandDeliverSm, sequenceNumber, text)
+	pdu.MandatoryParameters[7] = NewFLParameter(uint8(0x04))
+	pdu.OptionalParameters = append(pdu.OptionalParameters, optionalParameters...)
+
+	return pdu
+}
+
+// trackedSession is a bound loopback session whose inbound requests pass through a MessageTracker
+type trackedSession struct {
+	peer         *Peer
+	remoteReader *NetworkStreamReader
+	remoteWriter *NetworkStreamWriter
+	tracker      *MessageTracker
+	finals       chan *TrackedMessage
+}
+
+func newTrackedSession(t *testing.T) *trackedSession {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	session := &trackedSession{
+		peer:         peer,
+		remoteReader: NewNetworkStreamReader(remote),
+		remoteWriter: NewNetworkStreamWriter(remote),
+		tracker:      NewMessageTracker(nil),
+		finals:       make(chan *TrackedMessage, 10),
+	}
+
+	bindLoopbackPeer(t, peer, session.remoteReader, session.remoteWriter)
+	peer.AddInterceptor(session.tracker.Interceptor())
+	session.tracker.AddObserver(func(message *TrackedMessage) { session.finals <- message })
+
+	return session
+}
+
+// submit sends a submit_sm tracked under 'key', and returns the request as the remote read it
+func (session *trackedSession) submit(t *testing.T, key string) *PDU {
+	future, err := session.peer.SendRequest(newShortMessagePDU(CommandSubmitSm, 0, "hello"))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+	if err := session.tracker.Track(key, future); err != nil {
+		t.Fatalf("Expected no error on Track(), but got error = (%s)", err)
+	}
+
+	return readPDUOrFail(t, session.remoteReader)
+}
+
+func (session *trackedSession) awaitFinal(t *testing.T) *TrackedMessage {
+	select {
+	case message := <-session.finals:
+		return message
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for a message to reach a final state")
+		return nil
+	}
+}
+
+func TestNormalizeMessageID(t *testing.T) {
+	for messageID, expected := range map[string]string{"": "", " 00A1b2": "a1b2", "000": "0", "msg-01": "msg-01"} {
+		if normalized := NormalizeMessageID(messageID); normalized != expected {
+			t.Errorf("Expected (%s) for message_id (%s), got (%s)", expected, messageID, normalized)
+		}
+	}
+}
+
+func TestReceiptMessageIDs(t *testing.T) {
+	for _, testCase := range []struct {
+		messageID string
+		format    MessageIDFormat
+		expected  []string
+	}{
+		{"", MessageIDAutomatic, []string{}},
+		{"00A1b2", MessageIDAutomatic, []string{"a1b2", "41394"}},
+		{"1715004", MessageIDAutomatic, []string{"1715004", "1a2b3c"}},
+		{"22", MessageIDAutomatic, []string{"22"}},
+		{"1715004", MessageIDLiteral, []string{"1715004"}},
+		{"22", MessageIDHexResponseDecimalReceipt, []string{"22", "16"}},
+		{"16", MessageIDDecimalResponseHexReceipt, []string{"16", "22"}},
+		{"000", MessageIDHexResponseDecimalReceipt, []string{"0"}},
+		{"msg-01", MessageIDDecimalResponseHexReceipt, []string{"msg-01"}},
+	} {
+		if messageIDs := receiptMessageIDs(testCase.messageID, testCase.format); !reflect.DeepEqual(messageIDs, testCase.expected) {
+			t.Errorf("Expected (%v) for message_id (%s) with format (%d), got (%v)", testCase.expected, testCase.messageID, testCase.format, messageIDs)
+		}
+	}
+}
+
+func TestMessageTrackerMatchesReceiptBodyWithDecimalID(t *testing.T) {
+	session := newTrackedSession(t)
+
+	request := session.submit(t, "order-1")
+	if message, _ := session.tracker.Message("order-1"); message == nil || message.State != MessageSubmitted {
+		t.Errorf("Expected message to be SUBMITTED before the response, got (%v)", message)
+	}
+
+	// the SMSC answers with a hex message_id, and reports it in decimal in the receipt
+	session.remoteWriter.Write(NewPDU(CommandSubmitSmResp, EsmeROK, request.SequenceNumber, []*Parameter{NewCOctetStringParameter("1A2B3C")}, []*Parameter{}))
+	session.remoteWriter.Write(newReceiptPDU(1, "id:1715004 sub:001 dlvrd:000 submit date:2405011200 done date:2405011201 stat:ENROUTE err:000 text:hello"))
+	session.remoteWriter.Write(newReceiptPDU(2, "id:1715004 sub:001 dlvrd:001 submit date:2405011200 done date:2405011202 stat:DELIVRD err:000 text:hello"))
+
+	message := session.awaitFinal(t)
+	if message.Key != "order-1" || message.MessageID != "1A2B3C" || message.State != MessageDelivered || message.ErrorCode != "000" {
+		t.Errorf("Expected order-1 (1A2B3C) DELIVERED with err 000, got (%s) (%s) (%s) with err (%s)", message.Key, message.MessageID, message.State, message.ErrorCode)
+	}
+	if message.Receipt == nil || message.Receipt.SequenceNumber != 2 {
+		t.Errorf("Expected the final receipt to be kept with the message")
+	}
+
+	// receipts still reach the session
+	for i := 0; i < 2; i++ {
+		if delivered := <-session.peer.IncomingPDUs(); delivered.CommandID != CommandDeliverSm {
+			t.Errorf("Expected receipt to be delivered, got %s", delivered)
+		}
+	}
+
+	if message, _ := session.tracker.Message("order-1"); message != nil {
+		t.Errorf("Expected final message to be removed from the store, got (%v)", message)
+	}
+}
+
+// respond answers the submit_sm 'request' with 'messageID', and waits until the tracker has handled the
+// submit_sm_resp
+func (session *trackedSession) respond(t *testing.T, key string, request *PDU, messageID string) {
+	session.remoteWriter.Write(NewPDU(CommandSubmitSmResp, EsmeROK, request.SequenceNumber, []*Parameter{NewCOctetStringParameter(messageID)}, []*Parameter{}))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if message, _ := session.tracker.Message(key); message == nil || message.State != MessageSubmitted {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the submit_sm_resp of message (%s) to be handled", key)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMessageTrackerDoesNotCrossMatchSequentialDecimalIDs(t *testing.T) {
+	session := newTrackedSession(t)
+
+	session.respond(t, "order-16", session.submit(t, "order-16"), "16")
+
+	// 0x16 is 22, but "16" may be decimal, so a receipt for 22 must not match it
+	session.tracker.HandleReceipt(newReceiptPDU(1, "id:22 stat:DELIVRD"))
+	select {
+	case message := <-session.finals:
+		t.Fatalf("Expected the receipt for 22 not to match message 16, got (%s) (%s)", message.Key, message.State)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the receipt was kept, and matches message 22 once its submit_sm_resp is handled
+	session.respond(t, "order-22", session.submit(t, "order-22"), "22")
+	if message := session.awaitFinal(t); message.Key != "order-22" || message.State != MessageDelivered {
+		t.Errorf("Expected order-22 DELIVERED, got (%s) (%s)", message.Key, message.State)
+	}
+
+	session.tracker.HandleReceipt(newReceiptPDU(2, "id:16 stat:UNDELIV"))
+	if message := session.awaitFinal(t); message.Key != "order-16" || message.State != MessageUndeliverable {
+		t.Errorf("Expected order-16 UNDELIVERABLE, got (%s) (%s)", message.Key, message.State)
+	}
+}
+
+func TestMessageTrackerMatchesConfiguredMessageIDFormat(t *testing.T) {
+	session := newTrackedSession(t)
+	session.tracker.SetMessageIDFormat(MessageIDHexResponseDecimalReceipt)
+
+	session.respond(t, "order-16", session.submit(t, "order-16"), "16")
+	session.respond(t, "order-22", session.submit(t, "order-22"), "22")
+
+	// the literal message_id is matched first
+	session.tracker.HandleReceipt(newReceiptPDU(1, "id:22 stat:DELIVRD"))
+	if message := session.awaitFinal(t); message.Key != "order-22" || message.State != MessageDelivered {
+		t.Errorf("Expected order-22 DELIVERED, got (%s) (%s)", message.Key, message.State)
+	}
+
+	// with no literal match, the decimal receipt matches the hex message_id 0x16
+	session.tracker.HandleReceiptWithMessageIDFormat(newReceiptPDU(2, "id:22 stat:EXPIRED"), MessageIDHexResponseDecimalReceipt)
+	if message := session.awaitFinal(t); message.Key != "order-16" || message.State != MessageExpired {
+		t.Errorf("Expected order-16 EXPIRED, got (%s) (%s)", message.Key, message.State)
+	}
+}
+
+func TestMessageTrackerMatchesReceiptThatArrivesBeforeResponseIsHandled(t *testing.T) {
+	session := newTrackedSession(t)
+
+	request := session.submit(t, "order-2")
+
+	// the receipt is handled before the submit_sm_resp, and reports the state in TLVs
+	session.tracker.HandleReceipt(newReceiptPDU(1, "", NewTLVParameter(receiptedMessageIDTag, "0042\x00"), NewTLVParameter(messageStateTag, uint8(5))))
+	session.remoteWriter.Write(NewPDU(CommandSubmitSmResp, EsmeROK, request.SequenceNumber, []*Parameter{NewCOctetStringParameter("42")}, []*Parameter{}))
+
+	if message := session.awaitFinal(t); message.Key != "order-2" || message.State != MessageUndeliverable {
+		t.Errorf("Expected order-2 UNDELIVERABLE, got (%s) (%s)", message.Key, message.State)
+	}
+}
+
+func TestMessageTrackerFailsRejectedSubmit(t *testing.T) {
+	session := newTrackedSession(t)
+
+	request := session.submit(t, "order-3")
+	if err := session.tracker.Track("order-3", nil); err != ErrMessageAlreadyTracked {
+		t.Errorf("Expected ErrMessageAlreadyTracked for a duplicate key, got (%v)", err)
+	}
+
+	session.remoteWriter.Write(NewPDU(CommandSubmitSmResp, EsmeRThrottled, request.SequenceNumber, []*Parameter{}, []*Parameter{}))
+
+	message := session.awaitFinal(t)
+	if message.State != MessageSubmitFailed || message.CommandStatus != EsmeRThrottled {
+		t.Errorf("Expected SUBMIT_FAILED with ESME_RTHROTTLED, got (%s) with (%s)", message.State, CommandStatusName(message.CommandStatus))
+	}
+	if _, isStatusError := message.Err.(*CommandStatusError); !isStatusError {
+		t.Errorf("Expected CommandStatusError, got (%v)", message.Err)
+	}
+}
+
+func TestMessageTrackerTimesOutMissingReceipts(t *testing.T) {
+	session := newTrackedSession(t)
+	session.tracker.SetReceiptTimeout(50 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go session.tracker.RunContext(ctx)
+
+	request := session.submit(t, "order-4")
+	session.remoteWriter.Write(NewPDU(CommandSubmitSmResp, EsmeROK, request.SequenceNumber, []*Parameter{NewCOctetStringParameter("msg-04")}, []*Parameter{}))
+
+	if message := session.awaitFinal(t); message.Key != "order-4" || message.State != MessageReceiptTimedOut {
+		t.Errorf("Expected order-4 RECEIPT_TIMED_OUT, got (%s) (%s)", message.Key, message.State)
+	}
+
+	// a late receipt is recognized but matches nothing
+	if !session.tracker.HandleReceipt(newReceiptPDU(1, "id:msg-04 stat:DELIVRD")) {
+		t.Errorf("Expected late receipt to be recognized as a receipt")
+	}
+	if session.tracker.HandleReceipt(newShortMessagePDU(CommandDeliverSm, 2, "id:msg-04 stat:DELIVRD")) {
+		t.Errorf("Expected deliver_sm without the receipt esm_class not to be a receipt")
+	}
+
+	select {
+	case message := <-session.finals:
+		t.Errorf("Expected no further final state, got (%s) (%s)", message.Key, message.State)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// failingDeleteStore is a MemoryMessageStore whose Delete() fails for 'failKey'
+type failingDeleteStore struct {
+	*MemoryMessageStore
+	failKey string
+	err     error
+}
+
+func (store *failingDeleteStore) Delete(key string) error {
+	if key == store.failKey {
+		return store.err
+	}
+	return store.MemoryMessageStore.Delete(key)
+}
+
+func TestMessageTrackerExpiresOverdueMessagesAfterDeleteFails(t *testing.T) {
+	store := &failingDeleteStore{MemoryMessageStore: NewMemoryMessageStore(), failKey: "b", err: errors.New("Store unavailable")}
+	tracker := NewMessageTracker(store)
+	tracker.SetReceiptTimeout(time.Minute)
+
+	recorder := &entryRecorder{}
+	tracker.SetEventLogger(NewEventLogger(recorder))
+
+	finals := make([]string, 0)
+	tracker.AddObserver(func(message *TrackedMessage) { finals = append(finals, message.Key) })
+
+	submitted := time.Now().Add(-time.Hour)
+	for i, key := range []string{"a", "b", "c"} {
+		at := submitted.Add(time.Duration(i) * time.Second)
+		store.Save(&TrackedMessage{Key: key, MessageID: key, State: MessageEnroute, Submitted: at, Updated: at})
+	}
+
+	tracker.expireOverdue(time.Now())
+
+	if !reflect.DeepEqual(finals, []string{"a", "c"}) {
+		t.Errorf("Expected messages (a) and (c) to time out despite the failed delete of (b), got (%v)", finals)
+	}
+	if found := recorder.find(LogEventError, "error", store.err); len(found) != 1 {
+		t.Errorf("Expected the failed delete to be logged once, got (%d) entries", len(found))
+	}
+}
+
+func TestMemoryMessageStoreIndexesNormalizedMessageIDs(t *testing.T) {
+	store := NewMemoryMessageStore()
+
+	store.Save(&TrackedMessage{Key: "a", State: MessageSubmitted})
+	store.Save(&TrackedMessage{Key: "a", MessageID: "FF", State: MessageEnroute})
+	store.Save(&TrackedMessage{Key: "b", MessageID: "x-1", State: MessageDelivered})
+
+	if message, _ := store.LoadByMessageID("ff"); message == nil || message.Key != "a" {
+		t.Errorf("Expected message (a) for ff, got (%v)", message)
+	}
+	if pending, _ := store.Pending(); len(pending) != 1 || pending[0].Key != "a" {
+		t.Errorf("Expected only message (a) to be pending, got (%v)", pending)
+	}
+
+	store.Delete("a")
+
+	if message, _ := store.LoadByMessageID("ff"); message != nil || store.Len() != 1 {
+		t.Errorf("Expected deleted message to be unindexed, got (%v) and (%d) messages", message, store.Len())
+	}
+}
@@ -0,0 +1,361 @@
+package smpp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the buckets of the request latency
+// histogram
+var DefaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// pduMetricKey identifies a PDU counter
+type pduMetricKey struct {
+	command string
+	status  string
+}
+
+// histogram counts observations in cumulative buckets
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Metrics collects counters, gauges and histograms for the sessions to which it is given (with
+// SetMetrics() on a Peer, an ESME or an SMSC), and serves them in the Prometheus text exposition format.
+// Metrics implements http.Handler, so it can be registered on any ServeMux (e.g., at "/metrics").
+// The reconnects of ManagedPeers are counted if LifecycleObserver() is added to them.  A nil *Metrics
+// collects nothing.
+type Metrics struct {
+	mutex            sync.Mutex
+	latencyBuckets   []float64
+	pdusSent         map[pduMetricKey]uint64
+	pdusReceived     map[pduMetricKey]uint64
+	latencies        map[string]*histogram
+	timeouts         map[string]uint64
+	throttlingEvents map[string]uint64
+	decodeErrors     uint64
+	connectFailures  uint64
+	reconnects       uint64
+	sessions         map[*Peer]bool
+}
+
+// NewMetrics creates a Metrics whose request latency histogram uses DefaultLatencyBuckets
+func NewMetrics() *Metrics {
+	return NewMetricsWithLatencyBuckets(DefaultLatencyBuckets)
+}
+
+// NewMetricsWithLatencyBuckets creates a Metrics whose request latency histogram has buckets with the
+// upper bounds 'buckets', in seconds, in increasing order
+func NewMetricsWithLatencyBuckets(buckets []float64) *Metrics {
+	return &Metrics{
+		latencyBuckets:   append([]float64(nil), buckets...),
+		pdusSent:         make(map[pduMetricKey]uint64),
+		pdusReceived:     make(map[pduMetricKey]uint64),
+		latencies:        make(map[string]*histogram),
+		timeouts:         make(map[string]uint64),
+		throttlingEvents: make(map[string]uint64),
+		sessions:         make(map[*Peer]bool),
+	}
+}
+
+// LifecycleObserver returns a LifecycleObserver that counts each reconnect: each time a bound session
+// of the ManagedPeer (or BindPool) to which it is added is lost
+func (metrics *Metrics) LifecycleObserver() LifecycleObserver {
+	return func(event *LifecycleEvent) {
+		if metrics != nil && event.Type == LifecycleDisconnected {
+			metrics.mutex.Lock()
+			defer metrics.mutex.Unlock()
+
+			metrics.reconnects++
+		}
+	}
+}
+
+func (metrics *Metrics) addSession(peer *Peer) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.sessions[peer] = true
+}
+
+func (metrics *Metrics) removeSession(peer *Peer) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	delete(metrics.sessions, peer)
+}
+
+// observePDU counts a PDU, and for an inbound PDU, the throttling signals that it carries
+func (metrics *Metrics) observePDU(direction PDUDirection, pdu *PDU, congestionThreshold uint8) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	key := pduMetricKey{command: pdu.CommandName(), status: CommandStatusName(pdu.CommandStatus)}
+	if direction == DirectionOutbound {
+		metrics.pdusSent[key]++
+		return
+	}
+
+	metrics.pdusReceived[key]++
+
+	if !pdu.IsRequest() && pdu.CommandStatus == EsmeRThrottled {
+		metrics.throttlingEvents["throttled"]++
+	}
+	if congestion, isPresent := congestionState(pdu); isPresent && congestion >= congestionThreshold {
+		metrics.throttlingEvents["congestion"]++
+	}
+}
+
+func (metrics *Metrics) observeLatency(commandID CommandIDType, latency time.Duration) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	command := CommandName(commandID)
+	latencies, isKnown := metrics.latencies[command]
+	if !isKnown {
+		latencies = &histogram{counts: make([]uint64, len(metrics.latencyBuckets))}
+		metrics.latencies[command] = latencies
+	}
+
+	seconds := latency.Seconds()
+	for i, bound := range metrics.latencyBuckets {
+		if seconds <= bound {
+			latencies.counts[i]++
+		}
+	}
+	latencies.count++
+	latencies.sum += seconds
+}
+
+func (metrics *Metrics) countTimeout(commandID CommandIDType) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.timeouts[CommandName(commandID)]++
+}
+
+func (metrics *Metrics) countDecodeError() {
+	if metrics == nil {
+		return
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.decodeErrors++
+}
+
+func (metrics *Metrics) countConnectFailure() {
+	if metrics == nil {
+		return
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.connectFailures++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (metrics *Metrics) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.WriteTo(writer)
+}
+
+// WriteTo writes the metrics to 'writer' in the Prometheus text exposition format.  A nil *Metrics
+// writes nothing, which is an empty exposition.
+func (metrics *Metrics) WriteTo(writer io.Writer) (int64, error) {
+	if metrics == nil {
+		return 0, nil
+	}
+
+	sessionStates, outstanding := metrics.sessionGauges()
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	output := &metricsWriter{writer: bufio.NewWriter(writer)}
+
+	output.header("smpp_pdus_sent_total", "counter", "PDUs written to the transport, by command and command_status")
+	for _, key := range sortedPDUKeys(metrics.pdusSent) {
+		output.sample("smpp_pdus_sent_total", labels("command", key.command, "status", key.status), float64(metrics.pdusSent[key]))
+	}
+
+	output.header("smpp_pdus_received_total", "counter", "PDUs read from the transport, by command and command_status")
+	for _, key := range sortedPDUKeys(metrics.pdusReceived) {
+		output.sample("smpp_pdus_received_total", labels("command", key.command, "status", key.status), float64(metrics.pdusReceived[key]))
+	}
+
+	output.header("smpp_request_latency_seconds", "histogram", "Time from sending a request to receiving its response, by command")
+	for _, command := range sortedKeys(metrics.latencies) {
+		latencies := metrics.latencies[command]
+		for i, bound := range metrics.latencyBuckets {
+			output.sample("smpp_request_latency_seconds_bucket", labels("command", command, "le", formatFloat(bound)), float64(latencies.counts[i]))
+		}
+		output.sample("smpp_request_latency_seconds_bucket", labels("command", command, "le", "+Inf"), float64(latencies.count))
+		output.sample("smpp_request_latency_seconds_sum", labels("command", command), latencies.sum)
+		output.sample("smpp_request_latency_seconds_count", labels("command", command), float64(latencies.count))
+	}
+
+	output.header("smpp_request_timeouts_total", "counter", "Requests whose response did not arrive within the response timeout, by command")
+	for _, command := range sortedKeys(metrics.timeouts) {
+		output.sample("smpp_request_timeouts_total", labels("command", command), float64(metrics.timeouts[command]))
+	}
+
+	output.header("smpp_outstanding_requests", "gauge", "Requests waiting for a response, which is the occupancy of the windows of every session")
+	output.sample("smpp_outstanding_requests", "", float64(outstanding))
+
+	output.header("smpp_sessions", "gauge", "Sessions, by session state")
+	for state := StateOpen; state < StateClosed; state++ {
+		output.sample("smpp_sessions", labels("state", state.String()), float64(sessionStates[state]))
+	}
+
+	output.header("smpp_throttling_events_total", "counter", "Responses with ESME_RTHROTTLED, and PDUs with a congestion_state at or above the threshold")
+	for _, reason := range []string{"throttled", "congestion"} {
+		output.sample("smpp_throttling_events_total", labels("reason", reason), float64(metrics.throttlingEvents[reason]))
+	}
+
+	output.header("smpp_decode_errors_total", "counter", "Data read from a transport that could not be decoded as a PDU")
+	output.sample("smpp_decode_errors_total", "", float64(metrics.decodeErrors))
+
+	output.header("smpp_connect_failures_total", "counter", "Attempts to connect a transport that failed")
+	output.sample("smpp_connect_failures_total", "", float64(metrics.connectFailures))
+
+	output.header("smpp_reconnects_total", "counter", "Bound sessions of a ManagedPeer that were lost, after which it reconnects")
+	output.sample("smpp_reconnects_total", "", float64(metrics.reconnects))
+
+	if output.err == nil {
+		output.err = output.writer.Flush()
+	}
+
+	return output.written, output.err
+}
+
+// sessionGauges returns the number of sessions in each state, and the number of requests outstanding
+// on all of them
+func (metrics *Metrics) sessionGauges() (map[SessionState]int, int) {
+	if metrics == nil {
+		return map[SessionState]int{}, 0
+	}
+
+	metrics.mutex.Lock()
+	sessions := make([]*Peer, 0, len(metrics.sessions))
+	for peer := range metrics.sessions {
+		sessions = append(sessions, peer)
+	}
+	metrics.mutex.Unlock()
+
+	states := make(map[SessionState]int)
+	outstanding := 0
+	for _, peer := range sessions {
+		states[peer.State()]++
+		outstanding += peer.OutstandingRequests()
+	}
+
+	return states, outstanding
+}
+
+// metricsWriter writes samples in the text exposition format, remembering the first error
+type metricsWriter struct {
+	writer  *bufio.Writer
+	written int64
+	err     error
+}
+
+func (output *metricsWriter) printf(format string, a ...interface{}) {
+	if output.err != nil {
+		return
+	}
+
+	n, err := fmt.Fprintf(output.writer, format, a...)
+	output.written += int64(n)
+	output.err = err
+}
+
+func (output *metricsWriter) header(name string, metricType string, help string) {
+	output.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func (output *metricsWriter) sample(name string, labels string, value float64) {
+	output.printf("%s%s %s\n", name, labels, formatFloat(value))
+}
+
+// labels formats pairs of label names and values as a label set (e.g., `{command="submit-sm"}`)
+func labels(namesAndValues ...string) string {
+	pairs := make([]string, 0, len(namesAndValues)/2)
+	for i := 0; i+1 < len(namesAndValues); i += 2 {
+		pairs = append(pairs, namesAndValues[i]+`="`+labelValueEscaper.Replace(namesAndValues[i+1])+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedPDUKeys(counters map[pduMetricKey]uint64) []pduMetricKey {
+	keys := make([]pduMetricKey, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].command != keys[j].command {
+			return keys[i].command < keys[j].command
+		}
+		return keys[i].status < keys[j].status
+	})
+
+	return keys
+}
+
+func sortedKeys(values interface{}) []string {
+	keys := make([]string, 0)
+
+	switch typed := values.(type) {
+	case map[string]uint64:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	case map[string]*histogram:
+		for key := range typed {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
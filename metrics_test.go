@@ -0,0 +1,182 @@
+package smpp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrapeOrFail serves 'metrics' to a recorded request, and returns the lines of the body
+func scrapeOrFail(t *testing.T, metrics *Metrics) []string {
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("Expected text exposition content type, got (%s)", contentType)
+	}
+
+	return strings.Split(recorder.Body.String(), "\n")
+}
+
+func expectSamples(t *testing.T, lines []string, samples ...string) {
+	for _, sample := range samples {
+		found := false
+		for _, line := range lines {
+			if line == sample {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("Expected sample (%s) in exposition:\n%s", sample, strings.Join(lines, "\n"))
+		}
+	}
+}
+
+// throttlingSMSCHandler accepts the first submit_sm and throttles the rest
+type throttlingSMSCHandler struct {
+	BaseSMSCHandler
+	submitted chan struct{}
+}
+
+func (handler *throttlingSMSCHandler) OnSubmitSm(session *SMSCSession, pdu *PDU) *Response {
+	select {
+	case handler.submitted <- struct{}{}:
+		return nil
+	default:
+		return &Response{CommandStatus: EsmeRThrottled}
+	}
+}
+
+func TestMetricsCountSessionActivity(t *testing.T) {
+	smsc := NewSMSC("smsc01", nil, &throttlingSMSCHandler{submitted: make(chan struct{}, 1)})
+	smscMetrics := NewMetrics()
+	smsc.SetMetrics(smscMetrics)
+	defer smsc.Shutdown(context.Background())
+	smscAddr, _ := startSMSC(t, smsc)
+
+	esme := &ESME{}
+	metrics := NewMetrics()
+	esme.SetMetrics(metrics)
+
+	peer := connectAndBindOrFail(t, esme, smscAddr, BindInfo{Type: TransmitterBind, SystemID: "esme01"})
+
+	for i := 0; i < 2; i++ {
+		future, err := peer.SendRequest(newShortMessagePDU(CommandSubmitSm, 0, "hello"))
+		if err != nil {
+			t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+		}
+		if _, err := future.Wait(); err != nil {
+			t.Fatalf("Expected no error on Wait(), but got error = (%s)", err)
+		}
+	}
+
+	if _, err := esme.ConnectToPeer(smscAddr.IP, 1); err == nil {
+		t.Fatalf("Expected connection to closed port to fail")
+	}
+
+	submitSm, submitSmResp := CommandName(CommandSubmitSm), CommandName(CommandSubmitSmResp)
+
+	expectSamples(t, scrapeOrFail(t, metrics),
+		fmt.Sprintf(`smpp_pdus_sent_total{command="%s",status="ESME_ROK"} 2`, submitSm),
+		fmt.Sprintf(`smpp_pdus_received_total{command="%s",status="ESME_ROK"} 1`, submitSmResp),
+		fmt.Sprintf(`smpp_pdus_received_total{command="%s",status="ESME_RTHROTTLED"} 1`, submitSmResp),
+		fmt.Sprintf(`smpp_request_latency_seconds_count{command="%s"} 2`, submitSm),
+		fmt.Sprintf(`smpp_request_latency_seconds_bucket{command="%s",le="+Inf"} 2`, submitSm),
+		`smpp_sessions{state="BOUND_TX"} 1`,
+		`smpp_sessions{state="OPEN"} 0`,
+		`smpp_outstanding_requests 0`,
+		`smpp_throttling_events_total{reason="throttled"} 1`,
+		`smpp_connect_failures_total 1`,
+		`# TYPE smpp_request_latency_seconds histogram`,
+	)
+
+	expectSamples(t, scrapeOrFail(t, smscMetrics),
+		fmt.Sprintf(`smpp_pdus_received_total{command="%s",status="ESME_ROK"} 2`, submitSm),
+		fmt.Sprintf(`smpp_pdus_sent_total{command="%s",status="ESME_RTHROTTLED"} 1`, submitSmResp),
+		`smpp_sessions{state="BOUND_TX"} 1`,
+	)
+
+	peer.Close(context.Background())
+
+	expectSamples(t, scrapeOrFail(t, metrics), `smpp_sessions{state="BOUND_TX"} 0`)
+}
+
+func TestMetricsCountDecodeErrorsTimeoutsAndReconnects(t *testing.T) {
+	metrics := NewMetrics()
+
+	peer, remote := newLoopbackPeer(t, roleESME)
+	peer.SetMetrics(metrics)
+
+	future, err := peer.SendRequestWithOptions(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{}), RequestOptions{Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequestWithOptions(), but got error = (%s)", err)
+	}
+	future.Wait()
+
+	// a header whose command_length is shorter than a header cannot be decoded
+	malformed := make([]byte, 16)
+	binary.BigEndian.PutUint32(malformed[0:4], 8)
+	remote.Write(malformed)
+	<-peer.Done()
+
+	observer := metrics.LifecycleObserver()
+	observer(&LifecycleEvent{Type: LifecycleBound})
+	observer(&LifecycleEvent{Type: LifecycleDisconnected})
+
+	expectSamples(t, scrapeOrFail(t, metrics),
+		fmt.Sprintf(`smpp_request_timeouts_total{command="%s"} 1`, CommandName(CommandEnquireLink)),
+		`smpp_decode_errors_total 1`,
+		`smpp_reconnects_total 1`,
+		`smpp_sessions{state="OPEN"} 0`,
+	)
+}
+
+func TestMetricsExpositionFormat(t *testing.T) {
+	metrics := NewMetricsWithLatencyBuckets([]float64{0.1, 1})
+
+	for _, latency := range []time.Duration{50 * time.Millisecond, 500 * time.Millisecond, 2 * time.Second} {
+		metrics.observeLatency(CommandSubmitSm, latency)
+	}
+
+	submitSm := CommandName(CommandSubmitSm)
+	expectSamples(t, scrapeOrFail(t, metrics),
+		`# HELP smpp_request_latency_seconds Time from sending a request to receiving its response, by command`,
+		fmt.Sprintf(`smpp_request_latency_seconds_bucket{command="%s",le="0.1"} 1`, submitSm),
+		fmt.Sprintf(`smpp_request_latency_seconds_bucket{command="%s",le="1"} 2`, submitSm),
+		fmt.Sprintf(`smpp_request_latency_seconds_bucket{command="%s",le="+Inf"} 3`, submitSm),
+		fmt.Sprintf(`smpp_request_latency_seconds_sum{command="%s"} 2.55`, submitSm),
+		fmt.Sprintf(`smpp_request_latency_seconds_count{command="%s"} 3`, submitSm),
+	)
+
+	if escaped := labels("name", "a\"b\\c\nd"); escaped != `{name="a\"b\\c\nd"}` {
+		t.Errorf("Expected label value to be escaped, got (%s)", escaped)
+	}
+
+	var nilMetrics *Metrics
+	nilMetrics.observeLatency(CommandSubmitSm, time.Second)
+}
+
+func TestNilMetricsServesEmptyExposition(t *testing.T) {
+	var nilMetrics *Metrics
+
+	if written, err := nilMetrics.WriteTo(&bytes.Buffer{}); written != 0 || err != nil {
+		t.Errorf("Expected nil Metrics to write nothing, got (%d) octets, error = (%v)", written, err)
+	}
+
+	recorder := httptest.NewRecorder()
+	nilMetrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != 200 || recorder.Body.Len() != 0 {
+		t.Errorf("Expected nil Metrics to serve an empty exposition, got status (%d) and (%d) octets", recorder.Code, recorder.Body.Len())
+	}
+
+	states, outstanding := nilMetrics.sessionGauges()
+	if len(states) != 0 || outstanding != 0 {
+		t.Errorf("Expected no sessions from nil Metrics, got (%v) and (%d)", states, outstanding)
+	}
+}
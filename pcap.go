@@ -0,0 +1,275 @@
+package smpp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	pcapMagicNumber       = 0xa1b2c3d4
+	pcapVersionMajor      = 2
+	pcapVersionMinor      = 4
+	pcapSnapLength        = 262144
+	pcapLinkTypeEthernet  = 1
+	ethernetHeaderLength  = 14
+	ipv4HeaderLength      = 20
+	ipv6HeaderLength      = 40
+	tcpHeaderLength       = 20
+	maxPcapSegmentPayload = 65535 - ipv6HeaderLength - tcpHeaderLength
+	tcpFlagFIN            = 0x01
+	tcpFlagSYN            = 0x02
+	tcpFlagPSH            = 0x08
+	tcpFlagACK            = 0x10
+)
+
+// PcapWriter writes PDUs to a stream in libpcap format.  Each PDU is wrapped in synthesized
+// Ethernet, IP and TCP headers, so that the capture can be opened with tools like Wireshark.
+// The TCP sequence and acknowledgement numbers track the bytes sent in each direction,
+// and the first PDU is preceded by a synthesized three-way handshake.  A PcapWriter
+// is a PDUTap, so it may be attached to a NetworkStreamReader and a NetworkStreamWriter
+// at the same time.
+type PcapWriter struct {
+	outputStream     io.Writer
+	localAddr        *net.TCPAddr
+	remoteAddr       *net.TCPAddr
+	localSequence    uint32
+	remoteSequence   uint32
+	handshakeWritten bool
+	firstError       error
+	mutex            sync.Mutex
+}
+
+// NewPcapWriter creates a PcapWriter that writes to the provided stream, and immediately writes
+// the pcap file header.  'localAddr' is the address of the entity sending the outbound PDUs, and
+// 'remoteAddr' is the address of its peer.  If either is nil, a loopback address is substituted.
+// The two addresses must be of the same IP family.
+func NewPcapWriter(outputStream io.Writer, localAddr *net.TCPAddr, remoteAddr *net.TCPAddr) (*PcapWriter, error) {
+	if localAddr == nil {
+		localAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 49152}
+	}
+
+	if remoteAddr == nil {
+		remoteAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 2775}
+	}
+
+	if (localAddr.IP.To4() == nil) != (remoteAddr.IP.To4() == nil) {
+		return nil, fmt.Errorf("local address (%s) and remote address (%s) are not the same IP family", localAddr, remoteAddr)
+	}
+
+	writer := &PcapWriter{
+		outputStream:   outputStream,
+		localAddr:      localAddr,
+		remoteAddr:     remoteAddr,
+		localSequence:  0x1000,
+		remoteSequence: 0x2000,
+	}
+
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:4], pcapMagicNumber)
+	binary.LittleEndian.PutUint16(header[4:6], pcapVersionMajor)
+	binary.LittleEndian.PutUint16(header[6:8], pcapVersionMinor)
+	binary.LittleEndian.PutUint32(header[16:20], pcapSnapLength)
+	binary.LittleEndian.PutUint32(header[20:24], pcapLinkTypeEthernet)
+
+	if _, err := outputStream.Write(header); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+// NewPcapWriterForConnection is the same as NewPcapWriter, but uses the local and remote
+// addresses of 'conn' if it is a TCP connection
+func NewPcapWriterForConnection(outputStream io.Writer, conn net.Conn) (*PcapWriter, error) {
+	localAddr, _ := conn.LocalAddr().(*net.TCPAddr)
+	remoteAddr, _ := conn.RemoteAddr().(*net.TCPAddr)
+
+	return NewPcapWriter(outputStream, localAddr, remoteAddr)
+}
+
+// TapPDU writes the encoded PDU as one or more TCP segments.  Write errors are retained
+// and may be retrieved with Err(); once an error occurs, nothing further is written.
+func (writer *PcapWriter) TapPDU(direction PDUDirection, encoded []byte, timestamp time.Time) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.firstError != nil {
+		return
+	}
+
+	if !writer.handshakeWritten {
+		writer.handshakeWritten = true
+		writer.writeHandshake(timestamp)
+	}
+
+	for len(encoded) > 0 && writer.firstError == nil {
+		segmentLength := len(encoded)
+		if segmentLength > maxPcapSegmentPayload {
+			segmentLength = maxPcapSegmentPayload
+		}
+
+		writer.writeSegment(direction, tcpFlagPSH|tcpFlagACK, encoded[:segmentLength], timestamp)
+		encoded = encoded[segmentLength:]
+	}
+}
+
+// WritePDU encodes the PDU and writes it as if it had been seen on the wire in the
+// indicated direction at the provided time
+func (writer *PcapWriter) WritePDU(direction PDUDirection, pdu *PDU, timestamp time.Time) error {
+	encoded, err := pdu.Encode()
+
+	if err != nil {
+		return err
+	}
+
+	writer.TapPDU(direction, encoded, timestamp)
+
+	return writer.Err()
+}
+
+// WriteClose writes a synthesized FIN exchange, indicating that the local side closed
+// the connection at the provided time
+func (writer *PcapWriter) WriteClose(timestamp time.Time) error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	if writer.firstError == nil && writer.handshakeWritten {
+		writer.writeSegment(DirectionOutbound, tcpFlagFIN|tcpFlagACK, nil, timestamp)
+		writer.writeSegment(DirectionInbound, tcpFlagFIN|tcpFlagACK, nil, timestamp)
+		writer.writeSegment(DirectionOutbound, tcpFlagACK, nil, timestamp)
+	}
+
+	return writer.firstError
+}
+
+// Err returns the first error encountered while writing to the output stream, or nil
+func (writer *PcapWriter) Err() error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	return writer.firstError
+}
+
+func (writer *PcapWriter) writeHandshake(timestamp time.Time) {
+	writer.localSequence--
+	writer.remoteSequence--
+
+	writer.writeSegment(DirectionOutbound, tcpFlagSYN, nil, timestamp)
+	writer.writeSegment(DirectionInbound, tcpFlagSYN|tcpFlagACK, nil, timestamp)
+	writer.writeSegment(DirectionOutbound, tcpFlagACK, nil, timestamp)
+}
+
+// writeSegment writes one packet record and advances the sequence number for the sending side.
+// SYN and FIN each consume one sequence number.
+func (writer *PcapWriter) writeSegment(direction PDUDirection, flags uint8, payload []byte, timestamp time.Time) {
+	srcAddr, dstAddr := writer.localAddr, writer.remoteAddr
+	sequence, acknowledgement := &writer.localSequence, &writer.remoteSequence
+
+	if direction == DirectionInbound {
+		srcAddr, dstAddr = dstAddr, srcAddr
+		sequence, acknowledgement = acknowledgement, sequence
+	}
+
+	tcpSegment := make([]byte, tcpHeaderLength+len(payload))
+	binary.BigEndian.PutUint16(tcpSegment[0:2], uint16(srcAddr.Port))
+	binary.BigEndian.PutUint16(tcpSegment[2:4], uint16(dstAddr.Port))
+	binary.BigEndian.PutUint32(tcpSegment[4:8], *sequence)
+	if flags&tcpFlagACK != 0 {
+		binary.BigEndian.PutUint32(tcpSegment[8:12], *acknowledgement)
+	}
+	tcpSegment[12] = (tcpHeaderLength / 4) << 4
+	tcpSegment[13] = flags
+	binary.BigEndian.PutUint16(tcpSegment[14:16], 65535)
+	copy(tcpSegment[tcpHeaderLength:], payload)
+
+	*sequence += uint32(len(payload))
+	if flags&(tcpFlagSYN|tcpFlagFIN) != 0 {
+		*sequence++
+	}
+
+	var ethernetType uint16
+	var ipPacket []byte
+
+	if src4, dst4 := srcAddr.IP.To4(), dstAddr.IP.To4(); src4 != nil && dst4 != nil {
+		ethernetType = 0x0800
+		ipPacket = make([]byte, ipv4HeaderLength+len(tcpSegment))
+		ipPacket[0] = 0x45
+		binary.BigEndian.PutUint16(ipPacket[2:4], uint16(len(ipPacket)))
+		ipPacket[6] = 0x40 // don't fragment
+		ipPacket[8] = 64
+		ipPacket[9] = 6 // TCP
+		copy(ipPacket[12:16], src4)
+		copy(ipPacket[16:20], dst4)
+		binary.BigEndian.PutUint16(ipPacket[10:12], internetChecksum(0, ipPacket[:ipv4HeaderLength]))
+
+		pseudoHeader := make([]byte, 12)
+		copy(pseudoHeader[0:8], ipPacket[12:20])
+		pseudoHeader[9] = 6
+		binary.BigEndian.PutUint16(pseudoHeader[10:12], uint16(len(tcpSegment)))
+		binary.BigEndian.PutUint16(tcpSegment[16:18], internetChecksum(checksumSum(0, pseudoHeader), tcpSegment))
+	} else {
+		ethernetType = 0x86dd
+		ipPacket = make([]byte, ipv6HeaderLength+len(tcpSegment))
+		ipPacket[0] = 0x60
+		binary.BigEndian.PutUint16(ipPacket[4:6], uint16(len(tcpSegment)))
+		ipPacket[6] = 6 // TCP
+		ipPacket[7] = 64
+		copy(ipPacket[8:24], srcAddr.IP.To16())
+		copy(ipPacket[24:40], dstAddr.IP.To16())
+
+		pseudoHeader := make([]byte, 40)
+		copy(pseudoHeader[0:32], ipPacket[8:40])
+		binary.BigEndian.PutUint32(pseudoHeader[32:36], uint32(len(tcpSegment)))
+		pseudoHeader[39] = 6
+		binary.BigEndian.PutUint16(tcpSegment[16:18], internetChecksum(checksumSum(0, pseudoHeader), tcpSegment))
+	}
+
+	copy(ipPacket[len(ipPacket)-len(tcpSegment):], tcpSegment)
+
+	frame := make([]byte, 16+ethernetHeaderLength+len(ipPacket))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(timestamp.Unix()))
+	binary.LittleEndian.PutUint32(frame[4:8], uint32(timestamp.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(frame[8:12], uint32(ethernetHeaderLength+len(ipPacket)))
+	binary.LittleEndian.PutUint32(frame[12:16], uint32(ethernetHeaderLength+len(ipPacket)))
+
+	// locally administered MAC addresses, with the last octet distinguishing the sides
+	ethernetHeader := frame[16 : 16+ethernetHeaderLength]
+	copy(ethernetHeader[0:6], []byte{0x02, 0, 0, 0, 0, 0x02})
+	copy(ethernetHeader[6:12], []byte{0x02, 0, 0, 0, 0, 0x01})
+	if direction == DirectionInbound {
+		ethernetHeader[5], ethernetHeader[11] = 0x01, 0x02
+	}
+	binary.BigEndian.PutUint16(ethernetHeader[12:14], ethernetType)
+
+	copy(frame[16+ethernetHeaderLength:], ipPacket)
+
+	if _, err := writer.outputStream.Write(frame); err != nil {
+		writer.firstError = err
+	}
+}
+
+func checksumSum(sum uint32, data []byte) uint32 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+	}
+
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+
+	return sum
+}
+
+func internetChecksum(initialSum uint32, data []byte) uint16 {
+	sum := checksumSum(initialSum, data)
+
+	for sum > 0xffff {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+
+	return ^uint16(sum)
+}
@@ -0,0 +1,172 @@
+package smpp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+type pcapTestRecord struct {
+	timestamp      time.Time
+	srcPort        uint16
+	dstPort        uint16
+	sequence       uint32
+	acknowledgment uint32
+	flags          uint8
+	payload        []byte
+}
+
+func parsePcapIPv4Records(t *testing.T, capture []byte) []pcapTestRecord {
+	if len(capture) < 24 {
+		t.Fatalf("pcap stream is (%d) octets, shorter than the file header", len(capture))
+	}
+
+	if binary.LittleEndian.Uint32(capture[0:4]) != 0xa1b2c3d4 {
+		t.Fatalf("pcap magic number incorrect, got (%08x)", binary.LittleEndian.Uint32(capture[0:4]))
+	}
+
+	if binary.LittleEndian.Uint32(capture[20:24]) != 1 {
+		t.Fatalf("pcap link type should be (1), is (%d)", binary.LittleEndian.Uint32(capture[20:24]))
+	}
+
+	records := make([]pcapTestRecord, 0)
+
+	for s := 24; s < len(capture); {
+		seconds := binary.LittleEndian.Uint32(capture[s : s+4])
+		microseconds := binary.LittleEndian.Uint32(capture[s+4 : s+8])
+		includedLength := int(binary.LittleEndian.Uint32(capture[s+8 : s+12]))
+		frame := capture[s+16 : s+16+includedLength]
+
+		if binary.BigEndian.Uint16(frame[12:14]) != 0x0800 {
+			t.Fatalf("record at offset (%d) is not IPv4", s)
+		}
+
+		ip := frame[14:]
+		if internetChecksum(0, ip[:20]) != 0 {
+			t.Errorf("record at offset (%d) has incorrect IPv4 header checksum", s)
+		}
+
+		tcp := ip[20:binary.BigEndian.Uint16(ip[2:4])]
+		pseudoHeader := make([]byte, 12)
+		copy(pseudoHeader[0:8], ip[12:20])
+		pseudoHeader[9] = 6
+		binary.BigEndian.PutUint16(pseudoHeader[10:12], uint16(len(tcp)))
+		if internetChecksum(checksumSum(0, pseudoHeader), tcp) != 0 {
+			t.Errorf("record at offset (%d) has incorrect TCP checksum", s)
+		}
+
+		records = append(records, pcapTestRecord{
+			timestamp:      time.Unix(int64(seconds), int64(microseconds)*1000),
+			srcPort:        binary.BigEndian.Uint16(tcp[0:2]),
+			dstPort:        binary.BigEndian.Uint16(tcp[2:4]),
+			sequence:       binary.BigEndian.Uint32(tcp[4:8]),
+			acknowledgment: binary.BigEndian.Uint32(tcp[8:12]),
+			flags:          tcp[13],
+			payload:        tcp[20:],
+		})
+
+		s += 16 + includedLength
+	}
+
+	return records
+}
+
+func TestPcapWriterSequencing(t *testing.T) {
+	conn := newFakeNetConn()
+	capture := new(bytes.Buffer)
+
+	pcap, err := NewPcapWriter(capture, &net.TCPAddr{IP: net.IPv4(10, 1, 1, 1), Port: 40000}, &net.TCPAddr{IP: net.IPv4(10, 1, 1, 2), Port: 2775})
+
+	if err != nil {
+		t.Fatalf("Expected no error on NewPcapWriter(), but got error = (%s)", err)
+	}
+
+	timestamp := time.Unix(1600000000, 250000000)
+	pcap.TapPDU(DirectionOutbound, conn.bindTrasceiver01Msg, timestamp)
+	pcap.TapPDU(DirectionInbound, conn.enquireLink01Msg, timestamp.Add(time.Second))
+	pcap.TapPDU(DirectionOutbound, conn.enquireLink01Msg, timestamp.Add(2*time.Second))
+
+	if err := pcap.Err(); err != nil {
+		t.Fatalf("Expected no error from Err(), but got error = (%s)", err)
+	}
+
+	records := parsePcapIPv4Records(t, capture.Bytes())
+
+	if len(records) != 6 {
+		t.Fatalf("Expected 6 records (3 handshake, 3 PDUs), got (%d)", len(records))
+	}
+
+	expectedFlags := []uint8{tcpFlagSYN, tcpFlagSYN | tcpFlagACK, tcpFlagACK}
+	for i, flags := range expectedFlags {
+		if records[i].flags != flags {
+			t.Errorf("Handshake record (%d) expected flags (%02x), got (%02x)", i, flags, records[i].flags)
+		}
+	}
+
+	if records[3].srcPort != 40000 || records[3].dstPort != 2775 {
+		t.Errorf("First PDU should be from port 40000 to 2775, is from (%d) to (%d)", records[3].srcPort, records[3].dstPort)
+	}
+
+	if !bytes.Equal(records[3].payload, conn.bindTrasceiver01Msg) {
+		t.Errorf("First PDU payload does not match bind_transceiver encoding")
+	}
+
+	if records[4].srcPort != 2775 {
+		t.Errorf("Second PDU should be from port 2775, is from (%d)", records[4].srcPort)
+	}
+
+	if records[4].acknowledgment != records[3].sequence+uint32(len(conn.bindTrasceiver01Msg)) {
+		t.Errorf("Second PDU should acknowledge (%d), but acknowledges (%d)", records[3].sequence+uint32(len(conn.bindTrasceiver01Msg)), records[4].acknowledgment)
+	}
+
+	if records[5].sequence != records[3].sequence+uint32(len(conn.bindTrasceiver01Msg)) {
+		t.Errorf("Third PDU should have sequence (%d), but has (%d)", records[3].sequence+uint32(len(conn.bindTrasceiver01Msg)), records[5].sequence)
+	}
+
+	if records[5].acknowledgment != records[4].sequence+uint32(len(conn.enquireLink01Msg)) {
+		t.Errorf("Third PDU should acknowledge (%d), but acknowledges (%d)", records[4].sequence+uint32(len(conn.enquireLink01Msg)), records[5].acknowledgment)
+	}
+
+	if !records[4].timestamp.Equal(timestamp.Add(time.Second)) {
+		t.Errorf("Second PDU timestamp should be (%s), is (%s)", timestamp.Add(time.Second), records[4].timestamp)
+	}
+}
+
+func TestPcapWriterAsStreamTap(t *testing.T) {
+	conn := newFakeNetConn()
+	conn.nextReadValue = append(conn.bindTrasceiver01Msg, conn.enquireLink01Msg...)
+	capture := new(bytes.Buffer)
+
+	pcap, err := NewPcapWriterForConnection(capture, conn)
+
+	if err != nil {
+		t.Fatalf("Expected no error on NewPcapWriterForConnection(), but got error = (%s)", err)
+	}
+
+	reader := NewNetworkStreamReader(conn)
+	reader.SetTap(pcap)
+
+	if _, err := reader.Read(); err != nil {
+		t.Fatalf("Expected no error on Read(), but got error = (%s)", err)
+	}
+
+	records := parsePcapIPv4Records(t, capture.Bytes())
+
+	if len(records) != 5 {
+		t.Fatalf("Expected 5 records (3 handshake, 2 PDUs), got (%d)", len(records))
+	}
+
+	if !bytes.Equal(records[3].payload, conn.bindTrasceiver01Msg) || !bytes.Equal(records[4].payload, conn.enquireLink01Msg) {
+		t.Errorf("Captured PDU payloads do not match the PDUs read from the stream")
+	}
+}
+
+func TestPcapWriterRejectsMixedFamilies(t *testing.T) {
+	_, err := NewPcapWriter(new(bytes.Buffer), &net.TCPAddr{IP: net.IPv4(10, 1, 1, 1), Port: 1}, &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 2})
+
+	if err == nil {
+		t.Errorf("Expected error on NewPcapWriter() with IPv4 and IPv6 addresses, but got none")
+	}
+}
@@ -23,6 +23,12 @@ const (
 	TypeOctetString
 	// TypeTLV encodes as octet string
 	TypeTLV
+	// TypeDestinationAddressList is the dest_address list of a submit_multi.  The Value is a
+	// []DestinationAddress
+	TypeDestinationAddressList
+	// TypeUnsuccessfulSMEList is the unsuccess_sme list of a submit_multi_resp.  The Value is a
+	// []UnsuccessfulSME
+	TypeUnsuccessfulSMEList
 )
 
 // TLV represents the value in a Parameter struct for TLV type Parameters
@@ -58,12 +64,22 @@ var parameterTypeDefinition = map[string]ParameterDefinition{
 	"address_range":           {"address_range", TypeCOctetString, 41, 0},
 	"data_coding":             {"data_coding", TypeUint8, 1, 0},
 	"destination_addr":        {"destination_addr", TypeCOctetString, 21, 0},
-	"destination_addr_npi":    {"source_addr_npi", TypeUint8, 1, 0},
-	"destination_addr_ton":    {"source_addr_ton", TypeUint8, 1, 0},
+	"dest_address":            {"dest_address", TypeDestinationAddressList, 0, 0},
+	"destination_addr_npi":    {"destination_addr_npi", TypeUint8, 1, 0},
+	"destination_addr_ton":    {"destination_addr_ton", TypeUint8, 1, 0},
+	"dest_addr_npi":           {"dest_addr_npi", TypeUint8, 1, 0},
+	"dest_addr_ton":           {"dest_addr_ton", TypeUint8, 1, 0},
+	"error_code":              {"error_code", TypeUint8, 1, 0},
 	"esm_class":               {"esm_class", TypeUint8, 1, 0},
+	"esme_addr":               {"esme_addr", TypeCOctetString, 65, 0},
+	"esme_addr_npi":           {"esme_addr_npi", TypeUint8, 1, 0},
+	"esme_addr_ton":           {"esme_addr_ton", TypeUint8, 1, 0},
+	"final_date":              {"final_date", TypeCOctetString, 17, 0},
 	"interface_version":       {"interface_version", TypeUint8, 1, 0},
 	"password":                {"password", TypeCOctetString, 9, 0},
 	"message_id":              {"message_id", TypeCOctetString, 9, 0},
+	"no_unsuccess":            {"no_unsuccess", TypeUint8, 1, 0},
+	"number_of_dests":         {"number_of_dests", TypeUint8, 1, 0},
 	"priority_flag":           {"priority_flag", TypeUint8, 1, 0},
 	"protocol_id":             {"protocol_id", TypeUint8, 1, 0},
 	"registered_delivery":     {"registered_delivery", TypeUint8, 1, 0},
@@ -78,6 +94,7 @@ var parameterTypeDefinition = map[string]ParameterDefinition{
 	"source_addr":             {"source_addr", TypeCOctetString, 21, 0},
 	"system_id":               {"system_id", TypeCOctetString, 16, 0},
 	"system_type":             {"system_type", TypeCOctetString, 13, 0},
+	"unsuccess_sme":           {"unsuccess_sme", TypeUnsuccessfulSMEList, 0, 0},
 	"validity_period":         {"validity_period", TypeCOctetString, 21, 0},
 
 	// Optional Parameter Set
@@ -217,6 +234,12 @@ func (param *Parameter) Encode() []byte {
 		copy(b[0:], param.Value.([]byte))
 		return b
 
+	case TypeDestinationAddressList:
+		return encodeDestinationAddresses(param.Value.([]DestinationAddress), param.EncodeLength)
+
+	case TypeUnsuccessfulSMEList:
+		return encodeUnsuccessfulSMEs(param.Value.([]UnsuccessfulSME), param.EncodeLength)
+
 	case TypeTLV:
 		binary.BigEndian.PutUint16(encoded[0:2], param.Value.(TLV).Tag)
 		binary.BigEndian.PutUint16(encoded[2:4], param.Value.(TLV).VLength)
@@ -358,6 +381,13 @@ type PDU struct {
 	SequenceNumber      uint32
 	MandatoryParameters []*Parameter
 	OptionalParameters  []*Parameter
+
+	// UnparsedTail holds data that follow the parameters, and are encoded after them.  It is
+	// set by DecodePDULossless when part of a PDU cannot be parsed.
+	UnparsedTail []byte
+
+	originalEncoding   []byte
+	normalizedEncoding []byte
 }
 
 // PDUDefinition describes a PDU.  It contains the set of mandatory Parameters and the
@@ -435,8 +465,16 @@ var pduTypeDefinition = map[CommandIDType]PDUDefinition{
 	}},
 	CommandEnquireLink:     {CommandEnquireLink, 0, []string{}},
 	CommandEnquireLinkResp: {CommandEnquireLinkResp, 0, []string{}},
-	CommandSubmitMulti:     {CommandSubmitMulti, 0, []string{}},
-	CommandSubmitMultiResp: {CommandSubmitMultiResp, 0, []string{}},
+	CommandSubmitMulti: {CommandSubmitMulti, 0, []string{
+		"service_type", "source_addr_ton", "source_addr_npi", "source_addr",
+		"number_of_dests", "dest_address", "esm_class", "protocol_id",
+		"priority_flag", "schedule_delivery_time", "validity_period",
+		"registered_delivery", "replace_if_present_flag", "data_coding",
+		"sm_default_msg_id", "sm_length", "short_message",
+	}},
+	CommandSubmitMultiResp: {CommandSubmitMultiResp, 0, []string{
+		"message_id", "no_unsuccess", "unsuccess_sme",
+	}},
 	CommandAlertNotification: {CommandAlertNotification, 0, []string{
 		"source_addr_ton", "source_addr_npi", "source_addr", "esme_addr_ton",
 		"esme_addr_npi", "esme_addr",
@@ -445,7 +483,9 @@ var pduTypeDefinition = map[CommandIDType]PDUDefinition{
 		"service_type", "source_addr_ton", "source_addr_npi", "source_addr", "dest_addr_ton",
 		"dest_addr_npi", "destination_addr", "esm_class", "registered_delivery", "data_coding",
 	}},
-	CommandDataSmResp: {CommandDataSmResp, 0, []string{}},
+	CommandDataSmResp: {CommandDataSmResp, 0, []string{
+		"message_id",
+	}},
 }
 
 // LengthOfNextPDU reads a stream that should contain at least a fragment of an SMPP PDU.
@@ -460,7 +500,7 @@ func LengthOfNextPDU(stream []byte) uint32 {
 
 // NewPDU creates a new PDU object
 func NewPDU(id CommandIDType, status uint32, sequence uint32, mandatoryParams []*Parameter, optionalParams []*Parameter) *PDU {
-	pdu := PDU{0, id, status, sequence, mandatoryParams, optionalParams}
+	pdu := PDU{CommandID: id, CommandStatus: status, SequenceNumber: sequence, MandatoryParameters: mandatoryParams, OptionalParameters: optionalParams}
 
 	length := uint32(16)
 
@@ -505,20 +545,47 @@ func (pdu *PDU) ComputeLength() uint32 {
 		length += oparam.EncodeLength
 	}
 
-	return length
+	return length + uint32(len(pdu.UnparsedTail))
 }
 
-// Encode converts the 'pdu' object into a byte stream appropriate for network transmission
+// Encode converts the 'pdu' object into a byte stream appropriate for network transmission.  If the
+// PDU was produced by DecodePDULossless and has not been modified, the original encoding is returned
 func (pdu *PDU) Encode() ([]byte, error) {
 	if pdu.CommandLength < 1 {
 		return []byte{}, nil
 	}
 
-	pdu.CommandLength = pdu.ComputeLength()
+	encoded := pdu.encodeFields()
+
+	if pdu.originalEncoding != nil && bytes.Equal(encoded, pdu.normalizedEncoding) {
+		pdu.CommandLength = uint32(len(pdu.originalEncoding))
+		return append([]byte(nil), pdu.originalEncoding...), nil
+	}
+
+	pdu.CommandLength = uint32(len(encoded))
 
-	encoded := make([]byte, pdu.CommandLength)
+	return encoded, nil
+}
 
-	binary.BigEndian.PutUint32(encoded[0:4], pdu.CommandLength)
+// OriginalEncoding returns the octets from which the PDU was decoded by DecodePDULossless, or nil
+// if it was not decoded that way
+func (pdu *PDU) OriginalEncoding() []byte {
+	return pdu.originalEncoding
+}
+
+// DiscardOriginalEncoding causes Encode() to encode the PDU from its fields, even if it is
+// unmodified since it was decoded by DecodePDULossless
+func (pdu *PDU) DiscardOriginalEncoding() {
+	pdu.originalEncoding = nil
+	pdu.normalizedEncoding = nil
+}
+
+// encodeFields encodes the header, the parameters and UnparsedTail
+func (pdu *PDU) encodeFields() []byte {
+	length := pdu.ComputeLength()
+	encoded := make([]byte, length)
+
+	binary.BigEndian.PutUint32(encoded[0:4], length)
 	binary.BigEndian.PutUint32(encoded[4:8], uint32(pdu.CommandID))
 	binary.BigEndian.PutUint32(encoded[8:12], pdu.CommandStatus)
 	binary.BigEndian.PutUint32(encoded[12:16], pdu.SequenceNumber)
@@ -536,12 +603,28 @@ func (pdu *PDU) Encode() ([]byte, error) {
 		s = e
 	}
 
-	return encoded, nil
+	copy(encoded[s:], pdu.UnparsedTail)
+
+	return encoded
 }
 
 // DecodePDU accepts a byte stream in network byte order, and attempts to convert
-// it to a PDU object
+// it to a PDU object.  The PDU does not retain any reference to 'stream'
 func DecodePDU(stream []byte) (*PDU, error) {
+	return decodePDU(stream, false)
+}
+
+// DecodePDULossless is the same as DecodePDU, but is intended for entities, like proxies and recorders,
+// that must be able to forward a PDU exactly as it was received.  Command IDs that are not known, and
+// data that cannot be parsed according to the PDU definition (including a truncated TLV), are not errors;
+// instead, the data from the first octet that cannot be parsed to the end of the PDU are kept in UnparsedTail.
+// The PDU also retains a copy of the original encoding, which Encode() returns as long as the PDU has
+// not been modified in a way that changes its encoding.
+func DecodePDULossless(stream []byte) (*PDU, error) {
+	return decodePDU(stream, true)
+}
+
+func decodePDU(stream []byte, lossless bool) (*PDU, error) {
 	if len(stream) < 16 {
 		return nil, fmt.Errorf("Incoming stream invalid length, is (%d) octets", len(stream))
 	}
@@ -556,16 +639,20 @@ func DecodePDU(stream []byte) (*PDU, error) {
 		return nil, fmt.Errorf("Stream length field value is (%d) but stream length is (%d)", pduLength, len(stream))
 	}
 
+	stream = stream[:pduLength]
+
 	commandID := CommandIDType(uint32(binary.BigEndian.Uint32(stream[4:8])))
 
 	pduDef, exists := pduTypeDefinition[commandID]
 
-	if exists {
-		if pduDef.MinLength > pduLength {
-			return nil, fmt.Errorf("Stream length (%d) less than minimum (%d) for command type (%08x)", pduLength, pduDef.MinLength, commandID)
+	if !lossless {
+		if exists {
+			if pduDef.MinLength > pduLength {
+				return nil, fmt.Errorf("Stream length (%d) less than minimum (%d) for command type (%08x)", pduLength, pduDef.MinLength, commandID)
+			}
+		} else {
+			return nil, fmt.Errorf("Stream command-id (%08x) not known", commandID)
 		}
-	} else {
-		return nil, fmt.Errorf("Stream command-id (%08x) not known", commandID)
 	}
 
 	status := uint32(binary.BigEndian.Uint32(stream[8:12]))
@@ -577,8 +664,14 @@ func DecodePDU(stream []byte) (*PDU, error) {
 	s := 16
 	smLength := uint8(0)
 	smLengthFound := false
+	listLength := uint8(0)
+	unparsedFrom := -1
+
+	if lossless && !exists {
+		unparsedFrom = s
+	}
 
-	for i := 0; i < len(pduDef.MandatoryParameters); i++ {
+	for i := 0; i < len(pduDef.MandatoryParameters) && unparsedFrom < 0; i++ {
 		if s >= int(pduLength) {
 			break
 		}
@@ -595,13 +688,33 @@ func DecodePDU(stream []byte) (*PDU, error) {
 				smLengthFound = true
 			}
 
+			if paramName == "number_of_dests" || paramName == "no_unsuccess" {
+				listLength = uint8(stream[s])
+			}
+
 			s++
 
 		case TypeUint16:
+			if s+2 > len(stream) {
+				if lossless {
+					unparsedFrom = s
+					break
+				}
+				return nil, fmt.Errorf("Parameter (%s) extends beyond end of PDU", paramName)
+			}
+
 			mandatoryPList.PushBack(NewFLParameter(binary.BigEndian.Uint16(stream[s : s+2])))
 			s += 2
 
 		case TypeUint32:
+			if s+4 > len(stream) {
+				if lossless {
+					unparsedFrom = s
+					break
+				}
+				return nil, fmt.Errorf("Parameter (%s) extends beyond end of PDU", paramName)
+			}
+
 			mandatoryPList.PushBack(NewFLParameter(binary.BigEndian.Uint32(stream[s : s+4])))
 			s += 4
 
@@ -609,6 +722,10 @@ func DecodePDU(stream []byte) (*PDU, error) {
 			nullOffset := bytes.IndexByte(stream[s:], 0)
 
 			if nullOffset < 0 {
+				if lossless {
+					unparsedFrom = s
+					break
+				}
 				return nil, fmt.Errorf("Require C-String-Octet type but failed to find null terminator")
 			}
 
@@ -617,27 +734,61 @@ func DecodePDU(stream []byte) (*PDU, error) {
 			s += nullOffset + 1
 
 		case TypeOctetString:
-			if paramName == "short_message" {
-				if smLengthFound {
-					if smLength > 0 {
-						pp := &Parameter{TypeOctetString, uint32(smLength), stream[s : s+int(smLength)]}
-						mandatoryPList.PushBack(pp)
-						s += int(smLength)
-					}
-				} else {
-					return nil, fmt.Errorf("Found short_message field but no sm_length field")
-				}
-			} else {
+			if paramName != "short_message" {
 				return nil, fmt.Errorf("Unknown definition for type (%s)", paramName)
 			}
+
+			if !smLengthFound {
+				return nil, fmt.Errorf("Found short_message field but no sm_length field")
+			}
+
+			if s+int(smLength) > len(stream) {
+				if lossless {
+					unparsedFrom = s
+					break
+				}
+				return nil, fmt.Errorf("short_message length (%d) extends beyond end of PDU", smLength)
+			}
+
+			pp := &Parameter{TypeOctetString, uint32(smLength), append([]byte(nil), stream[s:s+int(smLength)]...)}
+			mandatoryPList.PushBack(pp)
+			s += int(smLength)
+
+		case TypeDestinationAddressList, TypeUnsuccessfulSMEList:
+			param, consumed, err := decodeAddressList(paramDef.Type, stream[s:], listLength)
+			if err != nil {
+				if lossless {
+					unparsedFrom = s
+					break
+				}
+				return nil, err
+			}
+
+			mandatoryPList.PushBack(param)
+			s += consumed
+
+		default:
+			if lossless {
+				unparsedFrom = s
+				break
+			}
+			return nil, fmt.Errorf("Unsupported type for mandatory parameter (%s)", paramName)
 		}
 	}
 
 	// Optional Parameters are all TLV
-	for uint32(s) < pduLength {
+	for unparsedFrom < 0 && uint32(s) < pduLength {
+		if s+4 > len(stream) || s+4+int(binary.BigEndian.Uint16(stream[s+2:s+4])) > len(stream) {
+			if lossless {
+				unparsedFrom = s
+				break
+			}
+			return nil, fmt.Errorf("Optional parameter at offset (%d) extends beyond end of PDU", s)
+		}
+
 		tlvTag := binary.BigEndian.Uint16(stream[s : s+2])
 		tlvLen := binary.BigEndian.Uint16(stream[s+2 : s+4])
-		tlvVal := stream[s+4 : s+4+int(tlvLen)]
+		tlvVal := append([]byte(nil), stream[s+4:s+4+int(tlvLen)]...)
 
 		optionalPList.PushBack(NewTLVParameter(tlvTag, tlvVal))
 
@@ -659,5 +810,17 @@ func DecodePDU(stream []byte) (*PDU, error) {
 		i++
 	}
 
-	return NewPDU(commandID, status, sequenceNumber, mp, op), nil
+	pdu := NewPDU(commandID, status, sequenceNumber, mp, op)
+
+	if lossless {
+		if unparsedFrom >= 0 {
+			pdu.UnparsedTail = append([]byte(nil), stream[unparsedFrom:]...)
+			pdu.CommandLength = pdu.ComputeLength()
+		}
+
+		pdu.originalEncoding = append([]byte(nil), stream...)
+		pdu.normalizedEncoding = pdu.encodeFields()
+	}
+
+	return pdu, nil
 }
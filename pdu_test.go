@@ -2,6 +2,7 @@ package smpp
 
 import (
 	"bytes"
+	"reflect"
 	"testing"
 )
 
@@ -367,3 +368,231 @@ func runPDUTest(t *testing.T, testname string, commandID CommandIDType, status u
 		}
 	}
 }
+
+func TestLosslessDecodeOfUnknownCommand(t *testing.T) {
+	encoded := []byte{
+		0x00, 0x00, 0x00, 0x14,
+		0x00, 0x01, 0x02, 0x03, // command ID not defined
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x07,
+		0xde, 0xad, 0xbe, 0xef,
+	}
+
+	if _, err := DecodePDU(encoded); err == nil {
+		t.Errorf("Expected error on DecodePDU() of unknown command, but got none")
+	}
+
+	pdu, err := DecodePDULossless(encoded)
+
+	if err != nil {
+		t.Fatalf("Expected no error on DecodePDULossless() of unknown command, but got error = (%s)", err)
+	}
+
+	if !bytes.Equal(pdu.UnparsedTail, encoded[16:]) {
+		t.Errorf("Expected UnparsedTail to be (%x), got (%x)", encoded[16:], pdu.UnparsedTail)
+	}
+
+	reEncoded, _ := pdu.Encode()
+	compareByteArrays(t, "Lossless unknown command", encoded, reEncoded)
+}
+
+func TestLosslessDecodePreservesOddEncodings(t *testing.T) {
+	encoded := []byte{
+		0x00, 0x00, 0x00, 0x24,
+		0x80, 0x00, 0x00, 0x09,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x01,
+		0x73, 0x6d, 0x73, 0x63, 0x00, // system_id = smsc
+		0x02, 0x10, 0x00, 0x02, 0x00, 0x34, // SC_interface_version with a two octet value
+		0x00, 0x1e, 0x00, 0x0a, 0x41, 0x42, // receipted_message_id, truncated
+		0x00, 0x00, 0x00,
+	}
+
+	if _, err := DecodePDU(encoded); err == nil {
+		t.Errorf("Expected error on DecodePDU() of truncated TLV, but got none")
+	}
+
+	pdu, err := DecodePDULossless(encoded)
+
+	if err != nil {
+		t.Fatalf("Expected no error on DecodePDULossless(), but got error = (%s)", err)
+	}
+
+	if len(pdu.OptionalParameters) != 1 || len(pdu.UnparsedTail) != 9 {
+		t.Errorf("Expected one TLV and 9 unparsed octets, got (%d) TLVs and (%d) octets", len(pdu.OptionalParameters), len(pdu.UnparsedTail))
+	}
+
+	reEncoded, _ := pdu.Encode()
+	compareByteArrays(t, "Lossless odd encoding", encoded, reEncoded)
+
+	pdu.SequenceNumber = 2
+	modified, _ := pdu.Encode()
+
+	if modified[15] != 2 || !bytes.Equal(modified[16:], encoded[16:]) {
+		t.Errorf("Expected modified PDU to differ from original only in the sequence number, got (%x)", modified)
+	}
+}
+
+func TestDecodeKeepsZeroLengthShortMessage(t *testing.T) {
+	submitSm := NewPDU(CommandSubmitSm, 0, 1, []*Parameter{
+		NewCOctetStringParameter(""),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewCOctetStringParameter("1"),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewCOctetStringParameter("2"),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewCOctetStringParameter(""),
+		NewCOctetStringParameter(""),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewOctetStringFromString(""),
+	}, []*Parameter{
+		NewTLVParameter(0x0424, "payload"),
+	})
+
+	encoded, _ := submitSm.Encode()
+
+	testPDUDecode(t, "submit-sm with zero sm_length", encoded, uint32(len(encoded)), CommandSubmitSm, 0, 1, 18, 1)
+}
+
+func TestCommandSubmitMultiPDU(t *testing.T) {
+	destinations := []DestinationAddress{
+		{AddrTON: 1, AddrNPI: 1, Address: "5678"},
+		{DistributionList: "friends"},
+	}
+
+	encoded := []byte{
+		0x00, 0x00, 0x00, 0x36, // length
+		0x00, 0x00, 0x00, 0x21, // command ID
+		0x00, 0x00, 0x00, 0x00, // status
+		0x00, 0x00, 0x00, 0x07, // sequence number
+		0x00,                         // service_type
+		0x01,                         // source_addr_ton
+		0x01,                         // source_addr_npi
+		0x31, 0x32, 0x33, 0x34, 0x00, // source_addr
+		0x02,                                           // number_of_dests
+		0x01, 0x01, 0x01, 0x35, 0x36, 0x37, 0x38, 0x00, // dest_address: SME address
+		0x02, 0x66, 0x72, 0x69, 0x65, 0x6e, 0x64, 0x73, 0x00, // dest_address: distribution list
+		0x00, 0x00, 0x00, // esm_class, protocol_id, priority_flag
+		0x00,             // schedule_delivery_time
+		0x00,             // validity_period
+		0x01,             // registered_delivery
+		0x00, 0x00, 0x00, // replace_if_present_flag, data_coding, sm_default_msg_id
+		0x02,       // sm_length
+		0x68, 0x69, // short_message
+	}
+
+	runPDUTest(t, "Command submit-multi", CommandSubmitMulti, 0, 7, []*Parameter{
+		NewCOctetStringParameter(""),
+		NewFLParameter(uint8(1)),
+		NewFLParameter(uint8(1)),
+		NewCOctetStringParameter("1234"),
+		NewFLParameter(uint8(len(destinations))),
+		NewDestinationAddressListParameter(destinations),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewCOctetStringParameter(""),
+		NewCOctetStringParameter(""),
+		NewFLParameter(uint8(1)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(2)),
+		NewOctetStringFromString("hi"),
+	}, []*Parameter{}, uint32(len(encoded)), encoded)
+
+	decoded, err := DecodePDU(encoded)
+	if err != nil {
+		t.Fatalf("Expected no error on DecodePDU(), but got error = (%s)", err)
+	}
+	if len(decoded.MandatoryParameters) != 17 || len(decoded.OptionalParameters) != 0 {
+		t.Fatalf("Expected (17) mandatory and no optional parameters, got (%d) and (%d)", len(decoded.MandatoryParameters), len(decoded.OptionalParameters))
+	}
+	if list := decoded.MandatoryParameters[5].Value; !reflect.DeepEqual(list, destinations) {
+		t.Errorf("Expected dest_address (%v), got (%v)", destinations, list)
+	}
+	if shortMessage := decoded.MandatoryParameters[16].Value.([]byte); string(shortMessage) != "hi" {
+		t.Errorf("Expected short_message (hi), got (%s)", shortMessage)
+	}
+	if reEncoded, _ := decoded.Encode(); !bytes.Equal(reEncoded, encoded) {
+		t.Errorf("Re-encoded submit-multi does not match original encoding")
+	}
+
+	badFlag := append([]byte{}, encoded...)
+	badFlag[25] = 0x09
+	if _, err := DecodePDU(badFlag); err == nil {
+		t.Errorf("Expected error for dest_address with unknown dest_flag")
+	}
+	if lossless, err := DecodePDULossless(badFlag); err != nil || len(lossless.UnparsedTail) != len(encoded)-25 {
+		t.Errorf("Expected lossless decode to keep the list from the unknown dest_flag unparsed, got error = (%v)", err)
+	}
+}
+
+func TestCommandSubmitMultiRespPDU(t *testing.T) {
+	unsuccessful := []UnsuccessfulSME{{AddrTON: 1, AddrNPI: 1, Address: "5678", ErrorStatusCode: EsmeRInvDstAdr}}
+
+	encoded := []byte{
+		0x00, 0x00, 0x00, 0x1f, // length
+		0x80, 0x00, 0x00, 0x21, // command ID
+		0x00, 0x00, 0x00, 0x00, // status
+		0x00, 0x00, 0x00, 0x07, // sequence number
+		0x6d, 0x31, 0x00, // message_id
+		0x01,                                     // no_unsuccess
+		0x01, 0x01, 0x35, 0x36, 0x37, 0x38, 0x00, // unsuccess_sme address
+		0x00, 0x00, 0x00, 0x0b, // unsuccess_sme error_status_code
+	}
+
+	runPDUTest(t, "Command submit-multi-resp", CommandSubmitMultiResp, 0, 7, []*Parameter{
+		NewCOctetStringParameter("m1"),
+		NewFLParameter(uint8(len(unsuccessful))),
+		NewUnsuccessfulSMEListParameter(unsuccessful),
+	}, []*Parameter{}, uint32(len(encoded)), encoded)
+
+	decoded, err := DecodePDU(encoded)
+	if err != nil {
+		t.Fatalf("Expected no error on DecodePDU(), but got error = (%s)", err)
+	}
+	if list := decoded.MandatoryParameters[2].Value; !reflect.DeepEqual(list, unsuccessful) {
+		t.Errorf("Expected unsuccess_sme (%v), got (%v)", unsuccessful, list)
+	}
+}
+
+func TestDecodeFixedLengthMandatoryParameters(t *testing.T) {
+	const commandTest = CommandIDType(0x000000ff)
+
+	// no command defined by the spec has a two or four octet mandatory parameter, so define one
+	parameterTypeDefinition["test_uint16"] = ParameterDefinition{"test_uint16", TypeUint16, 2, 0}
+	parameterTypeDefinition["test_uint32"] = ParameterDefinition{"test_uint32", TypeUint32, 4, 0}
+	pduTypeDefinition[commandTest] = PDUDefinition{commandTest, 0, []string{"test_uint16", "test_uint32"}}
+	defer func() {
+		delete(parameterTypeDefinition, "test_uint16")
+		delete(parameterTypeDefinition, "test_uint32")
+		delete(pduTypeDefinition, commandTest)
+	}()
+
+	pdu := NewPDU(commandTest, 0, 3, []*Parameter{NewFLParameter(uint16(0x0102)), NewFLParameter(uint32(0x03040506))}, []*Parameter{})
+	encoded, _ := pdu.Encode()
+
+	decoded, err := DecodePDU(encoded)
+	if err != nil {
+		t.Fatalf("Expected no error on DecodePDU(), but got error = (%s)", err)
+	}
+	if len(decoded.MandatoryParameters) != 2 || decoded.MandatoryParameters[0].Value != uint16(0x0102) || decoded.MandatoryParameters[1].Value != uint32(0x03040506) {
+		t.Errorf("Expected uint16 (0x0102) and uint32 (0x03040506), got (%v)", decoded.MandatoryParameters)
+	}
+
+	// a truncated uint32 is an error
+	truncated := append([]byte(nil), encoded[:len(encoded)-2]...)
+	truncated[3] = byte(len(truncated))
+	if _, err := DecodePDU(truncated); err == nil {
+		t.Errorf("Expected an error on DecodePDU() of a truncated uint32 parameter")
+	}
+}
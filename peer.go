@@ -1,24 +1,823 @@
 package smpp
 
-import "net"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultResponseTimeout is the time that a session waits for the response to a request, unless
+// it is changed with SetResponseTimeout()
+const DefaultResponseTimeout = 30 * time.Second
+
+// incomingQueueLength is the number of received requests that wait in IncomingPDUs() to be consumed
+const incomingQueueLength = 64
 
-type peerStates int
+// PeerEventType identifies the kind of event that a Peer reports to its observers
+type PeerEventType int
 
 const (
-	peerDisconnected peerStates = iota
-	peerUnbound
-	peerBound
+	// EventStateChange is reported when the session state changes
+	EventStateChange PeerEventType = iota
+	// EventUnmatchedResponse is reported when a response arrives whose sequence number does not
+	// match an outstanding request
+	EventUnmatchedResponse
+	// EventLateResponse is reported when a response arrives for a request that has already timed out
+	EventLateResponse
+	// EventUnbindReceived is reported when the peer sends unbind, before the session answers it
+	EventUnbindReceived
+	// EventGenericNackReceived is reported for each generic_nack from the peer, whether or not it
+	// matches an outstanding request
+	EventGenericNackReceived
 )
 
-// Peer represents a peer for an SMPP entity (an ESME or SMSC)
+// PeerEvent is passed to a PeerObserver.  PreviousState and State are set for EventStateChange.
+// PDU is set for every other event type.  Err is the reason for the event, if there is one (e.g., the
+// transport error that closed the session).
+type PeerEvent struct {
+	Type          PeerEventType
+	Peer          *Peer
+	PreviousState SessionState
+	State         SessionState
+	PDU           *PDU
+	Err           error
+}
+
+// PeerObserver is a function that receives events from a Peer.  Observers are called synchronously
+// from the goroutine that caused the event, so they should not block.
+type PeerObserver func(event *PeerEvent)
+
+// Peer represents a peer for an SMPP entity (an ESME or SMSC).  A Peer is an SMPP session: it tracks
+// the session state, rejects requests that are not permitted in that state, and reads PDUs from the
+// transport in its own goroutine.  Inbound requests that are not permitted in the current state are
+// answered automatically with ESME_RINVBNDSTS (or ESME_RALYBND for a bind on a bound session), and
+// are not delivered.  Inbound enquire_link and unbind requests are also answered by the session.  Requests sent
+// with SendRequest() are assigned a sequence number, and their responses are matched to them by the
+// session.
 type Peer struct {
-	connectionToRemotePeer *net.TCPConn
-	state                  peerStates
+	connectionToRemotePeer net.Conn
+	localRole              sessionRole
+	reader                 *NetworkStreamReader
+	writer                 *NetworkStreamWriter
+
+	mutex              sync.Mutex
+	state              SessionState
+	observers          []PeerObserver
+	interceptors       []Interceptor
+	eventLogger        *EventLogger
+	metrics            *Metrics
+	nextSequenceNumber uint32
+	outstanding        map[uint32]*ResponseFuture
+	windowSize         int
+	windowReleased     chan struct{}
+	responseTimeout    time.Duration
+	commandTimeouts    map[CommandIDType]time.Duration
+	timeoutPolicy      TimeoutPolicy
+	rateLimiters       map[CommandIDType]*tokenBucket
+	throttlePolicy     ThrottlePolicy
+	expiredSequences   map[uint32]bool
+	expiredOrder       []uint32
+	closeReason        error
+	closing            bool
+
+	enquireLinkInterval  time.Duration
+	enquireLinkMaxMisses int
+	enquireLinkMisses    int
+	lastReceiveTime      time.Time
+	keepaliveChanged     chan struct{}
+
+	incoming chan *PDU
+	closed   chan struct{}
 }
 
 // NewPeerWithConnection instantiates a Peer object, providing it with an already
 // created connection.  This can be useful if you wish to use local binds for a
-// Peer connection
-func NewPeerWithConnection(c *net.TCPConn) *Peer {
-	return &Peer{connectionToRemotePeer: c, state: peerUnbound}
+// Peer connection, or a transport other than TCP (e.g., TLS, a Unix domain socket
+// or net.Pipe()).  The local entity is the ESME, and the session starts in StateOpen
+func NewPeerWithConnection(c net.Conn) *Peer {
+	return newPeer(c, roleESME)
+}
+
+func newPeer(c net.Conn, localRole sessionRole) *Peer {
+	peer := &Peer{
+		connectionToRemotePeer: c,
+		localRole:              localRole,
+		reader:                 NewNetworkStreamReader(c),
+		writer:                 NewNetworkStreamWriter(c),
+		state:                  StateOpen,
+		observers:              make([]PeerObserver, 0),
+		nextSequenceNumber:     1,
+		outstanding:            make(map[uint32]*ResponseFuture),
+		windowReleased:         make(chan struct{}),
+		responseTimeout:        DefaultResponseTimeout,
+		commandTimeouts:        make(map[CommandIDType]time.Duration),
+		rateLimiters:           make(map[CommandIDType]*tokenBucket),
+		throttlePolicy:         DefaultThrottlePolicy,
+		expiredSequences:       make(map[uint32]bool),
+		expiredOrder:           make([]uint32, 0, maximumExpiredSequences),
+		enquireLinkMaxMisses:   DefaultEnquireLinkMaxMisses,
+		lastReceiveTime:        time.Now(),
+		keepaliveChanged:       make(chan struct{}),
+		incoming:               make(chan *PDU, incomingQueueLength),
+		closed:                 make(chan struct{}),
+	}
+
+	// unknown command_ids must decode so that they can be answered with generic_nack.  A known request
+	// that decodes only in part is rejected by rejectMalformedRequest().
+	peer.reader.SetLosslessDecoding(true)
+
+	if tcpConn := underlyingTCPConn(c); tcpConn != nil {
+		tcpConn.SetNoDelay(true)
+		tcpConn.SetKeepAlive(true)
+		tcpConn.SetKeepAlivePeriod(DefaultTCPKeepAlivePeriod)
+	}
+
+	go peer.readLoop()
+	go peer.keepaliveLoop()
+
+	return peer
+}
+
+// State returns the current session state
+func (peer *Peer) State() SessionState {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	return peer.state
+}
+
+// AddObserver adds a function that is called for each event on the session
+func (peer *Peer) AddObserver(observer PeerObserver) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	peer.observers = append(peer.observers, observer)
+}
+
+// SetResponseTimeout sets how long the session waits for the response to a request sent with
+// SendRequest(), unless a timeout is set for the request's command or for the call
+func (peer *Peer) SetResponseTimeout(timeout time.Duration) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	peer.responseTimeout = timeout
+}
+
+// SetTap attaches a PDUTap (e.g., a SessionRecorder or a PcapWriter) to both directions of the session,
+// so that it receives every PDU read from and written to the transport.  Set to nil to detach the
+// current tap.  To see the bind, set the tap before binding, for example from the session configurator of
+// an ESME or an SMSC.
+func (peer *Peer) SetTap(tap PDUTap) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	peer.reader.SetTap(tap)
+	peer.writer.SetTap(tap)
+}
+
+// SetMaxPDULength sets the largest command_length that the session accepts from the peer.  A PDU header
+// with a larger command_length closes the session with a DecodeError.  A length of zero restores
+// DefaultMaxPDULength.
+func (peer *Peer) SetMaxPDULength(length int) {
+	peer.reader.SetMaxPDULength(length)
+}
+
+// IncomingPDUs returns a channel on which the peer delivers every received request that is not
+// consumed by the session itself.  The channel is closed when the session reaches StateClosed.  Up to 64
+// requests wait in the channel; while it is full, further requests are answered with ESME_RTHROTTLED
+// (or dropped, if they have no response), so that the session keeps reading responses.
+func (peer *Peer) IncomingPDUs() <-chan *PDU {
+	return peer.incoming
+}
+
+// Done returns a channel that is closed when the session reaches StateClosed
+func (peer *Peer) Done() <-chan struct{} {
+	return peer.closed
+}
+
+// Err returns the reason that the session was closed, or nil if it is not closed or was closed by
+// Disconnect()
+func (peer *Peer) Err() error {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	return peer.closeReason
+}
+
+// SendPDU writes a PDU to the peer.  If the PDU is a request that the local entity may not send in
+// the current session state, it is not written, and a *SessionStateError is returned.  The session
+// state is updated after the PDU is written (e.g., sending unbind_resp moves the session to StateUnbound).
+func (peer *Peer) SendPDU(pdu *PDU) error {
+	return peer.writePDU(pdu, false)
+}
+
+// writePDU is SendPDU(), except that requests are also permitted while the session is closing if
+// 'partOfShutdown' is true
+func (peer *Peer) writePDU(pdu *PDU, partOfShutdown bool) error {
+	peer.mutex.Lock()
+	state := peer.state
+	closing := peer.closing
+	peer.mutex.Unlock()
+
+	if state == StateClosed {
+		return fmt.Errorf("Peer has no connected transport")
+	}
+
+	if closing && pdu.IsRequest() && !partOfShutdown {
+		return ErrSessionClosing
+	}
+
+	if !requestIsPermitted(pdu.CommandID, peer.localRole, state) {
+		return &SessionStateError{CommandID: pdu.CommandID, State: state}
+	}
+
+	written, err := peer.transmit(pdu)
+	if err != nil {
+		return err
+	}
+
+	if written {
+		peer.applyTransition(pdu)
+	}
+
+	return nil
+}
+
+// Close shuts the session down gracefully.  New requests are refused with ErrSessionClosing, and Close
+// waits for outstanding requests to complete.  Then, if the session is bound, it sends unbind and waits
+// for unbind_resp.  Finally, it closes the transport.  If 'ctx' is done before the outstanding requests
+// complete or unbind_resp arrives, Close stops waiting, closes the transport, and returns the context
+// error.
+func (peer *Peer) Close(ctx context.Context) error {
+	peer.mutex.Lock()
+	if peer.state == StateClosed {
+		peer.mutex.Unlock()
+		return nil
+	}
+	peer.closing = true
+	peer.mutex.Unlock()
+
+	err := peer.awaitOutstandingRequests(ctx)
+
+	if peer.State().IsBound() {
+		unbind := NewPDU(CommandUnbind, 0, 0, []*Parameter{}, []*Parameter{})
+		future, sendErr := peer.sendRequest(unbind, requestOptions{ignoreWindow: true, partOfShutdown: true, policy: &TimeoutPolicy{Action: FailOnTimeout}})
+
+		if sendErr != nil {
+			err = sendErr
+		} else if err == nil {
+			select {
+			case <-future.Done():
+				_, err = future.Wait()
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+	}
+
+	peer.closeSession(nil)
+	peer.connectionToRemotePeer.Close()
+
+	return err
+}
+
+// answerUnbind shuts the session down when the peer sends unbind.  New requests are refused, and the
+// outstanding requests are allowed to complete or time out.  Then unbind_resp is sent, and the
+// transport is closed.  The session closes with ErrPeerUnbound.
+func (peer *Peer) answerUnbind(unbind *PDU) {
+	peer.mutex.Lock()
+	peer.closing = true
+	peer.mutex.Unlock()
+
+	peer.awaitOutstandingRequests(context.Background())
+
+	peer.writePDU(NewPDU(CommandUnbindResp, EsmeROK, unbind.SequenceNumber, []*Parameter{}, []*Parameter{}), true)
+
+	peer.closeSession(ErrPeerUnbound)
+	peer.connectionToRemotePeer.Close()
+}
+
+// awaitOutstandingRequests waits until no requests are outstanding, the session closes, or 'ctx' is done
+func (peer *Peer) awaitOutstandingRequests(ctx context.Context) error {
+	for {
+		peer.mutex.Lock()
+		outstanding := len(peer.outstanding)
+		released := peer.windowReleased
+		peer.mutex.Unlock()
+
+		if outstanding == 0 {
+			return nil
+		}
+
+		select {
+		case <-released:
+		case <-peer.closed:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Disconnect closes the transport without unbinding.  The session moves to StateClosed.
+func (peer *Peer) Disconnect() error {
+	peer.closeSession(nil)
+
+	return peer.connectionToRemotePeer.Close()
+}
+
+// SetWindowSize sets the maximum number of requests sent with SendRequest() or TrySendRequest() that
+// may be waiting for a response at the same time.  A size of zero (the default) means there is no limit.
+func (peer *Peer) SetWindowSize(size int) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	peer.windowSize = size
+	peer.releaseWindow()
+}
+
+// WindowSize returns the window size set by SetWindowSize()
+func (peer *Peer) WindowSize() int {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	return peer.windowSize
+}
+
+// SendRequest assigns the next sequence number to a request and writes it to the peer.  If the window
+// is full, SendRequest blocks until a slot is released by a response, a generic_nack or a timeout, and
+// then until the rate limit for the command (see SetRateLimit()) allows the request.  The
+// returned ResponseFuture completes with the matching response or generic_nack, or with ErrResponseTimeout
+// if nothing arrives within the response timeout.  An error is returned, and nothing is written, if the
+// PDU is not a request or is not permitted in the current session state.
+func (peer *Peer) SendRequest(request *PDU) (*ResponseFuture, error) {
+	return peer.sendRequest(request, requestOptions{blockIfWindowIsFull: true})
+}
+
+// SendRequestContext is the same as SendRequest(), except that if 'ctx' is done while it is blocked
+// on a full window or a rate limit, it returns ctx.Err(), and nothing is written
+func (peer *Peer) SendRequestContext(ctx context.Context, request *PDU) (*ResponseFuture, error) {
+	return peer.sendRequest(request, requestOptions{ctx: ctx, blockIfWindowIsFull: true})
+}
+
+// TrySendRequest is the same as SendRequest(), except that if the window is full, it returns
+// ErrWindowFull immediately instead of blocking, and if the rate limit for the command (see
+// SetRateLimit()) allows no request now, it returns ErrRateLimited
+func (peer *Peer) TrySendRequest(request *PDU) (*ResponseFuture, error) {
+	return peer.sendRequest(request, requestOptions{})
+}
+
+// requestOptions control how sendRequest() treats a single request.  A zero timeout means the
+// command or session response timeout, and a nil policy means the session timeout policy.  A nil ctx
+// never cancels the wait for a window slot.
+type requestOptions struct {
+	ctx                 context.Context
+	blockIfWindowIsFull bool
+	ignoreWindow        bool
+	partOfShutdown      bool
+	timeout             time.Duration
+	policy              *TimeoutPolicy
+}
+
+func (peer *Peer) sendRequest(request *PDU, options requestOptions) (*ResponseFuture, error) {
+	if !request.IsRequest() {
+		return nil, fmt.Errorf("%s is not a request", request.CommandName())
+	}
+
+	var cancelled <-chan struct{}
+	if options.ctx != nil {
+		if err := options.ctx.Err(); err != nil {
+			return nil, err
+		}
+		cancelled = options.ctx.Done()
+	}
+
+	future := newResponseFuture(request)
+
+	peer.mutex.Lock()
+	if peer.closing && !options.partOfShutdown {
+		peer.mutex.Unlock()
+		return nil, ErrSessionClosing
+	}
+
+	for !options.ignoreWindow && peer.state != StateClosed {
+		if peer.windowSize > 0 && len(peer.outstanding) >= peer.windowSize {
+			if !options.blockIfWindowIsFull {
+				peer.mutex.Unlock()
+				return nil, ErrWindowFull
+			}
+
+			released := peer.windowReleased
+			peer.mutex.Unlock()
+
+			select {
+			case <-released:
+			case <-peer.closed:
+			case <-cancelled:
+				return nil, options.ctx.Err()
+			}
+
+			peer.mutex.Lock()
+			continue
+		}
+
+		delay := peer.rateLimitDelay(request.CommandID)
+		if delay == 0 {
+			break
+		}
+
+		if !options.blockIfWindowIsFull {
+			peer.mutex.Unlock()
+			return nil, ErrRateLimited
+		}
+
+		peer.mutex.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-peer.closed:
+			timer.Stop()
+		case <-cancelled:
+			timer.Stop()
+			return nil, options.ctx.Err()
+		}
+
+		peer.mutex.Lock()
+	}
+
+	if peer.state == StateClosed {
+		peer.mutex.Unlock()
+		return nil, fmt.Errorf("Peer has no connected transport")
+	}
+	request.SequenceNumber = peer.allocateUnusedSequenceNumber()
+	peer.outstanding[request.SequenceNumber] = future
+	future.timeout = peer.responseTimeoutFor(request.CommandID, options.timeout)
+	future.sent = time.Now()
+	future.policy = peer.timeoutPolicy
+	if options.policy != nil {
+		future.policy = *options.policy
+	}
+	peer.mutex.Unlock()
+
+	peer.startResponseTimer(future)
+
+	if err := peer.writePDU(request, true); err != nil {
+		peer.removeOutstanding(future)
+		future.complete(nil, err)
+		return nil, err
+	}
+
+	return future, nil
+}
+
+// OutstandingRequests returns the number of requests sent with SendRequest() that are waiting for
+// a response.  This is the current occupancy of the window.
+func (peer *Peer) OutstandingRequests() int {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	return len(peer.outstanding)
+}
+
+// allocateSequenceNumber returns the next sequence number for a request sent to the peer
+func (peer *Peer) allocateSequenceNumber() uint32 {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	return peer.allocateUnusedSequenceNumber()
+}
+
+// allocateUnusedSequenceNumber returns the next sequence number in the range 0x00000001 through
+// 0x7fffffff, wrapping to 1, and skipping any number that is still outstanding.  The caller must
+// hold the peer mutex.
+func (peer *Peer) allocateUnusedSequenceNumber() uint32 {
+	for {
+		sequenceNumber := peer.nextSequenceNumber
+
+		peer.nextSequenceNumber++
+		if peer.nextSequenceNumber > 0x7fffffff {
+			peer.nextSequenceNumber = 1
+		}
+
+		if _, isOutstanding := peer.outstanding[sequenceNumber]; !isOutstanding {
+			return sequenceNumber
+		}
+	}
+}
+
+// removeOutstanding removes the future from the set of outstanding requests.  It returns false if
+// the future was not outstanding.
+func (peer *Peer) removeOutstanding(future *ResponseFuture) bool {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	sequenceNumber := future.request.SequenceNumber
+	if peer.outstanding[sequenceNumber] != future {
+		return false
+	}
+
+	delete(peer.outstanding, sequenceNumber)
+	peer.releaseWindow()
+
+	return true
+}
+
+// releaseWindow wakes any senders that are waiting for a window slot.  The caller must hold the
+// peer mutex.
+func (peer *Peer) releaseWindow() {
+	close(peer.windowReleased)
+	peer.windowReleased = make(chan struct{})
+}
+
+func (peer *Peer) readLoop() {
+	defer close(peer.incoming)
+
+	for {
+		pdus, err := peer.reader.Read()
+
+		if len(pdus) > 0 {
+			peer.mutex.Lock()
+			peer.lastReceiveTime = time.Now()
+			peer.enquireLinkMisses = 0
+			peer.mutex.Unlock()
+		}
+
+		for _, pdu := range pdus {
+			peer.receive(pdu)
+		}
+
+		if err != nil {
+			var decodeError *DecodeError
+			if errors.As(err, &decodeError) {
+				peer.currentMetrics().countDecodeError()
+			}
+
+			peer.connectionToRemotePeer.Close()
+			peer.closeSession(err)
+			return
+		}
+	}
+}
+
+func (peer *Peer) handleInboundPDU(pdu *PDU) {
+	if pdu.IsRequest() {
+		if _, isDefined := pduTypeDefinition[pdu.CommandID]; !isDefined {
+			peer.transmit(NewPDU(CommandGenericNack, EsmeRInvCmdID, pdu.SequenceNumber, []*Parameter{}, []*Parameter{}))
+			return
+		}
+
+		state := peer.State()
+		if !requestIsPermitted(pdu.CommandID, peer.remoteRole(), state) {
+			peer.rejectRequest(pdu, state)
+			return
+		}
+
+		if pdu.CommandID == CommandEnquireLink {
+			peer.transmit(NewPDU(CommandEnquireLinkResp, EsmeROK, pdu.SequenceNumber, []*Parameter{}, []*Parameter{}))
+			return
+		}
+
+		if pdu.CommandID == CommandUnbind {
+			peer.notify(&PeerEvent{Type: EventUnbindReceived, Peer: peer, State: state, PDU: pdu})
+			go peer.answerUnbind(pdu)
+			return
+		}
+
+		peer.applyThrottleSignals(pdu, pdu.CommandID)
+		peer.applyTransition(pdu)
+		peer.deliver(pdu)
+		return
+	}
+
+	peer.applyTransition(pdu)
+
+	if pdu.CommandID == CommandGenericNack {
+		peer.notify(&PeerEvent{Type: EventGenericNackReceived, Peer: peer, State: peer.State(), PDU: pdu})
+	}
+
+	peer.mutex.Lock()
+	future, isOutstanding := peer.outstanding[pdu.SequenceNumber]
+	if isOutstanding && !responseMatchesRequest(pdu, future.request) {
+		isOutstanding = false
+	}
+	if isOutstanding {
+		delete(peer.outstanding, pdu.SequenceNumber)
+		peer.releaseWindow()
+	}
+	peer.mutex.Unlock()
+
+	if isOutstanding {
+		peer.applyThrottleSignals(pdu, future.request.CommandID)
+		peer.currentMetrics().observeLatency(future.request.CommandID, time.Since(future.sent))
+		future.complete(pdu, nil)
+		return
+	}
+
+	peer.applyThrottleSignals(pdu, pdu.CommandID)
+
+	if peer.takeExpiredSequence(pdu.SequenceNumber) {
+		peer.notify(&PeerEvent{Type: EventLateResponse, Peer: peer, State: peer.State(), PDU: pdu})
+		return
+	}
+
+	peer.notify(&PeerEvent{Type: EventUnmatchedResponse, Peer: peer, State: peer.State(), PDU: pdu})
+}
+
+// responseMatchesRequest returns true if 'response' is the response to 'request' or a generic_nack.
+// The sequence numbers are not compared.
+func responseMatchesRequest(response *PDU, request *PDU) bool {
+	return response.CommandID == CommandGenericNack || response.CommandID == request.CommandID|0x80000000
+}
+
+// deliver passes a PDU to IncomingPDUs() without blocking the read loop, so that responses are still
+// read while requests are not being consumed.  A request that does not fit is answered with
+// ESME_RTHROTTLED, so that the peer retries it later.
+func (peer *Peer) deliver(pdu *PDU) {
+	select {
+	case peer.incoming <- pdu:
+		return
+	default:
+	}
+
+	if _, hasResponse := pduTypeDefinition[pdu.CommandID|0x80000000]; hasResponse {
+		peer.transmit(responsePDU(pdu, &Response{CommandStatus: EsmeRThrottled}))
+	}
+}
+
+// rejectMalformedRequest answers a request with a known command_id that could not be decoded completely
+// (so it has an UnparsedTail) with generic_nack and ESME_RINVCMDLEN, and counts it as a decode error.  It
+// returns true if the request was rejected.  The session decodes losslessly only so that unknown
+// command_ids can be answered, and a truncated request must not reach the interceptors or the handler.
+func (peer *Peer) rejectMalformedRequest(pdu *PDU) bool {
+	if !pdu.IsRequest() || len(pdu.UnparsedTail) == 0 {
+		return false
+	}
+	if _, isDefined := pduTypeDefinition[pdu.CommandID]; !isDefined {
+		return false
+	}
+
+	peer.currentMetrics().countDecodeError()
+	peer.log(LogEventError, "Request could not be decoded", pduLogFields(pdu)...)
+	peer.transmit(NewPDU(CommandGenericNack, EsmeRInvCmdLen, pdu.SequenceNumber, []*Parameter{}, []*Parameter{}))
+
+	return true
+}
+
+// rejectRequest answers an inbound request that is not permitted in 'state'.  As the specification
+// permits for a non-zero command_status, the response has no body
+func (peer *Peer) rejectRequest(request *PDU, state SessionState) {
+	status := uint32(EsmeRInvBndSts)
+
+	switch request.CommandID {
+	case CommandBindTransmitter, CommandBindReceiver, CommandBindTransceiver:
+		if state.IsBound() {
+			status = EsmeRAlyBnd
+		}
+	}
+
+	responseID := request.CommandID | 0x80000000
+	if _, isDefined := pduTypeDefinition[responseID]; !isDefined {
+		responseID = CommandGenericNack
+	}
+
+	peer.transmit(NewPDU(responseID, status, request.SequenceNumber, []*Parameter{}, []*Parameter{}))
+}
+
+func (peer *Peer) remoteRole() sessionRole {
+	if peer.localRole == roleESME {
+		return roleSMSC
+	}
+
+	return roleESME
+}
+
+func (peer *Peer) applyTransition(pdu *PDU) {
+	peer.mutex.Lock()
+	previousState := peer.state
+	state := nextSessionState(previousState, pdu)
+	peer.state = state
+	peer.mutex.Unlock()
+
+	peer.notifyStateChange(previousState, state, nil)
+}
+
+// closeSession moves the session to StateClosed, recording 'reason' if it is the first close
+func (peer *Peer) closeSession(reason error) {
+	peer.mutex.Lock()
+	previousState := peer.state
+	if previousState == StateClosed {
+		peer.mutex.Unlock()
+		return
+	}
+	peer.state = StateClosed
+	peer.closeReason = reason
+	close(peer.closed)
+	outstanding := peer.outstanding
+	peer.outstanding = make(map[uint32]*ResponseFuture)
+	peer.mutex.Unlock()
+
+	futureError := reason
+	if futureError == nil {
+		futureError = fmt.Errorf("Session closed")
+	}
+
+	for _, future := range outstanding {
+		future.complete(nil, futureError)
+	}
+
+	if reason != nil && reason != ErrPeerUnbound {
+		peer.log(LogEventError, "Session closed by error", LogField{"error", reason})
+	}
+
+	peer.currentMetrics().removeSession(peer)
+
+	peer.notifyStateChange(previousState, StateClosed, reason)
+}
+
+func (peer *Peer) notifyStateChange(previousState SessionState, state SessionState, reason error) {
+	if previousState == state {
+		return
+	}
+
+	if reason != nil {
+		peer.log(LogEventStateChange, "Session state changed", LogField{"from", previousState.String()}, LogField{"to", state.String()}, LogField{"reason", reason})
+	} else {
+		peer.log(LogEventStateChange, "Session state changed", LogField{"from", previousState.String()}, LogField{"to", state.String()})
+	}
+
+	peer.notify(&PeerEvent{Type: EventStateChange, Peer: peer, PreviousState: previousState, State: state, Err: reason})
+}
+
+func (peer *Peer) notify(event *PeerEvent) {
+	peer.mutex.Lock()
+	observers := append([]PeerObserver(nil), peer.observers...)
+	peer.mutex.Unlock()
+
+	for _, observer := range observers {
+		observer(event)
+	}
+}
+
+// SetEventLogger sets the EventLogger to which the session logs its activity.  A nil EventLogger, the
+// default, logs nothing.
+func (peer *Peer) SetEventLogger(eventLogger *EventLogger) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	peer.eventLogger = eventLogger
+}
+
+// log passes an entry to the session's EventLogger, with the address of the remote end of the transport
+func (peer *Peer) log(eventType LogEventType, message string, fields ...LogField) {
+	peer.mutex.Lock()
+	eventLogger := peer.eventLogger
+	peer.mutex.Unlock()
+
+	if !eventLogger.Enabled(eventType) {
+		return
+	}
+
+	eventLogger.Log(eventType, message, append(fields, LogField{"remote_addr", peer.connectionToRemotePeer.RemoteAddr().String()})...)
+}
+
+// SetMetrics sets the Metrics in which the session's activity is counted.  A nil Metrics, the default,
+// counts nothing.
+func (peer *Peer) SetMetrics(metrics *Metrics) {
+	peer.mutex.Lock()
+	previous := peer.metrics
+	peer.metrics = metrics
+	closed := peer.state == StateClosed
+	peer.mutex.Unlock()
+
+	previous.removeSession(peer)
+	if !closed {
+		metrics.addSession(peer)
+	}
+}
+
+func (peer *Peer) currentMetrics() *Metrics {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	return peer.metrics
+}
+
+// observePDU logs and counts a PDU written to or read from the transport
+func (peer *Peer) observePDU(direction PDUDirection, pdu *PDU) {
+	peer.mutex.Lock()
+	metrics := peer.metrics
+	congestionThreshold := peer.throttlePolicy.CongestionThreshold
+	peer.mutex.Unlock()
+
+	metrics.observePDU(direction, pdu, congestionThreshold)
+
+	if direction == DirectionInbound {
+		peer.log(LogEventPDUReceived, "Received PDU", pduLogFields(pdu)...)
+	} else {
+		peer.log(LogEventPDUSent, "Sent PDU", pduLogFields(pdu)...)
+	}
 }
@@ -0,0 +1,804 @@
+package smpp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newLoopbackPeer returns a Peer with the local role 'localRole' on one end of a loopback TCP
+// connection, and the other end of the connection
+func newLoopbackPeer(t *testing.T, localRole sessionRole) (*Peer, net.Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on loopback: %s", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	conn, err := net.DialTCP("tcp", nil, listener.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatalf("Failed to connect on loopback: %s", err)
+	}
+
+	remote, isOpen := <-accepted
+	if !isOpen {
+		t.Fatalf("Failed to accept on loopback")
+	}
+
+	peer := newPeer(conn, localRole)
+	t.Cleanup(func() {
+		peer.Disconnect()
+		remote.Close()
+	})
+
+	return peer, remote
+}
+
+func readPDUOrFail(t *testing.T, reader *NetworkStreamReader) *PDU {
+	return readPDUsOrFail(t, reader, 1)[0]
+}
+
+// readPDUsOrFail reads until at least 'count' PDUs have arrived
+func readPDUsOrFail(t *testing.T, reader *NetworkStreamReader, count int) []*PDU {
+	pdus := make([]*PDU, 0, count)
+
+	for len(pdus) < count {
+		extracted, err := reader.ExtractNextPDUs()
+		if err != nil {
+			t.Fatalf("Expected no error on ExtractNextPDUs(), but got error = (%s)", err)
+		}
+		pdus = append(pdus, extracted...)
+	}
+
+	return pdus
+}
+
+func TestRequestIsPermitted(t *testing.T) {
+	for _, testCase := range []struct {
+		commandID CommandIDType
+		sender    sessionRole
+		state     SessionState
+		permitted bool
+	}{
+		{CommandBindTransmitter, roleESME, StateOpen, true},
+		{CommandBindTransmitter, roleESME, StateOutbound, false},
+		{CommandBindReceiver, roleESME, StateOutbound, true},
+		{CommandBindTransceiver, roleSMSC, StateOpen, false},
+		{CommandOutbind, roleSMSC, StateOpen, true},
+		{CommandSubmitSm, roleESME, StateBoundTx, true},
+		{CommandSubmitSm, roleESME, StateBoundRx, false},
+		{CommandDeliverSm, roleSMSC, StateBoundRx, true},
+		{CommandDeliverSm, roleSMSC, StateBoundTx, false},
+		{CommandDataSm, roleESME, StateBoundRx, false},
+		{CommandDataSm, roleSMSC, StateBoundRx, true},
+		{CommandUnbind, roleESME, StateOpen, false},
+		{CommandEnquireLink, roleESME, StateUnbound, false},
+		{CommandSubmitSmResp, roleSMSC, StateUnbound, true},
+		{CommandEnquireLinkResp, roleESME, StateClosed, false},
+	} {
+		if permitted := requestIsPermitted(testCase.commandID, testCase.sender, testCase.state); permitted != testCase.permitted {
+			t.Errorf("requestIsPermitted(%s, %d, %s): expected (%t), got (%t)", CommandName(testCase.commandID), testCase.sender, testCase.state, testCase.permitted, permitted)
+		}
+	}
+}
+
+func TestOutboundStateTransitions(t *testing.T) {
+	outbind := NewPDU(CommandOutbind, 0, 1, []*Parameter{}, []*Parameter{})
+	bindReceiverResp := NewPDU(CommandBindReceiverResp, EsmeROK, 2, []*Parameter{}, []*Parameter{})
+
+	if state := nextSessionState(StateOpen, outbind); state != StateOutbound {
+		t.Errorf("Expected OUTBOUND after outbind, got (%s)", state)
+	}
+
+	if state := nextSessionState(StateOutbound, bindReceiverResp); state != StateBoundRx {
+		t.Errorf("Expected BOUND_RX after bind-receiver-resp in OUTBOUND, got (%s)", state)
+	}
+}
+
+func TestSubmitSmRejectedLocallyOnReceiverBind(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+
+	go func() {
+		bind, err := remoteReader.ExtractNextPDUs()
+		if err == nil {
+			remoteWriter.Write(NewPDU(CommandBindReceiverResp, 0, bind[0].SequenceNumber, []*Parameter{NewCOctetStringParameter("smsc")}, []*Parameter{}))
+		}
+	}()
+
+	if _, _, err := (&ESME{}).BindToPeer(peer, BindInfo{Type: ReceiverBind, SystemID: "esme01"}); err != nil {
+		t.Fatalf("Expected no error on BindToPeer(), but got error = (%s)", err)
+	}
+
+	if peer.State() != StateBoundRx {
+		t.Fatalf("Expected session state BOUND_RX, got (%s)", peer.State())
+	}
+
+	err := peer.SendPDU(NewPDU(CommandSubmitSm, 0, peer.allocateSequenceNumber(), []*Parameter{}, []*Parameter{}))
+
+	var stateError *SessionStateError
+	if !errors.As(err, &stateError) {
+		t.Fatalf("Expected *SessionStateError on SendPDU(submit-sm), got (%v)", err)
+	}
+
+	if stateError.State != StateBoundRx || stateError.CommandID != CommandSubmitSm {
+		t.Errorf("Expected error for submit-sm in BOUND_RX, got (%s)", stateError)
+	}
+}
+
+func TestDisallowedInboundRequestsAreAnswered(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+
+	remoteWriter.Write(NewPDU(CommandDeliverSm, 0, 7, []*Parameter{}, []*Parameter{}))
+
+	response := readPDUOrFail(t, remoteReader)
+	if response.CommandID != CommandDeliverSmResp || response.CommandStatus != EsmeRInvBndSts || response.SequenceNumber != 7 {
+		t.Errorf("Expected deliver-sm-resp with ESME_RINVBNDSTS and sequence 7, got %s", response)
+	}
+
+	remoteWriter.WriteEncoded([]byte{0, 0, 0, 0x10, 0, 0, 0x77, 0x77, 0, 0, 0, 0, 0, 0, 0, 8})
+
+	response = readPDUOrFail(t, remoteReader)
+	if response.CommandID != CommandGenericNack || response.CommandStatus != EsmeRInvCmdID || response.SequenceNumber != 8 {
+		t.Errorf("Expected generic-nack with ESME_RINVCMDID and sequence 8, got %s", response)
+	}
+
+	remoteWriter.Write(NewPDU(CommandOutbind, 0, 9, []*Parameter{NewCOctetStringParameter("smsc"), NewCOctetStringParameter("")}, []*Parameter{}))
+
+	select {
+	case pdu := <-peer.IncomingPDUs():
+		if pdu.CommandID != CommandOutbind || pdu.SequenceNumber != 9 {
+			t.Errorf("Expected outbind with sequence 9 on IncomingPDUs(), got %s", pdu)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for outbind on IncomingPDUs()")
+	}
+}
+
+func TestBindOnBoundSessionAnsweredWithAlreadyBound(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleSMSC)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+
+	bind := (&BindInfo{Type: TransmitterBind, SystemID: "esme01"}).bindPDU(1)
+	remoteWriter.Write(bind)
+
+	received := <-peer.IncomingPDUs()
+	if err := peer.SendPDU(NewPDU(CommandBindTransmitterResp, 0, received.SequenceNumber, []*Parameter{NewCOctetStringParameter("smsc")}, []*Parameter{})); err != nil {
+		t.Fatalf("Expected no error on SendPDU(bind-transmitter-resp), but got error = (%s)", err)
+	}
+	readPDUOrFail(t, remoteReader)
+
+	if peer.State() != StateBoundTx {
+		t.Fatalf("Expected session state BOUND_TX, got (%s)", peer.State())
+	}
+
+	remoteWriter.Write((&BindInfo{Type: TransmitterBind, SystemID: "esme01"}).bindPDU(2))
+
+	response := readPDUOrFail(t, remoteReader)
+	if response.CommandID != CommandBindTransmitterResp || response.CommandStatus != EsmeRAlyBnd {
+		t.Errorf("Expected bind-transmitter-resp with ESME_RALYBND, got %s", response)
+	}
+}
+
+func TestStateObserversSeeEveryTransition(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+
+	var mutex sync.Mutex
+	transitions := make([]string, 0)
+	closed := make(chan struct{})
+
+	peer.AddObserver(func(event *PeerEvent) {
+		if event.Type != EventStateChange {
+			return
+		}
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		transitions = append(transitions, event.PreviousState.String()+">"+event.State.String())
+		if event.State == StateClosed {
+			close(closed)
+		}
+	})
+
+	remoteWriter.Write(NewPDU(CommandOutbind, 0, 1, []*Parameter{NewCOctetStringParameter("smsc"), NewCOctetStringParameter("")}, []*Parameter{}))
+	<-peer.IncomingPDUs()
+
+	go func() {
+		bind, err := remoteReader.ExtractNextPDUs()
+		if err != nil {
+			return
+		}
+		remoteWriter.Write(NewPDU(CommandBindReceiverResp, 0, bind[0].SequenceNumber, []*Parameter{NewCOctetStringParameter("smsc")}, []*Parameter{}))
+		remoteWriter.Write(NewPDU(CommandUnbind, 0, 2, []*Parameter{}, []*Parameter{}))
+	}()
+
+	if _, _, err := (&ESME{}).BindToPeer(peer, BindInfo{Type: ReceiverBind, SystemID: "esme01"}); err != nil {
+		t.Fatalf("Expected no error on BindToPeer(), but got error = (%s)", err)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for CLOSED")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	expected := []string{"OPEN>OUTBOUND", "OUTBOUND>BOUND_RX", "BOUND_RX>UNBOUND", "UNBOUND>CLOSED"}
+	if len(transitions) != len(expected) {
+		t.Fatalf("Expected transitions %v, got %v", expected, transitions)
+	}
+
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Errorf("Expected transitions %v, got %v", expected, transitions)
+			break
+		}
+	}
+
+	if peer.Err() != ErrPeerUnbound {
+		t.Errorf("Expected Err() to be ErrPeerUnbound, got (%v)", peer.Err())
+	}
+}
+
+func TestSendRequestCorrelatesResponses(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+
+	first, err := peer.SendRequest(NewPDU(CommandEnquireLink, 0, 0x5e, []*Parameter{}, []*Parameter{}))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	second, err := peer.SendRequest(NewPDU(CommandEnquireLink, 0, 0x5e, []*Parameter{}, []*Parameter{}))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	if first.Request().SequenceNumber != 1 || second.Request().SequenceNumber != 2 {
+		t.Fatalf("Expected sequence numbers (1) and (2), got (%d) and (%d)", first.Request().SequenceNumber, second.Request().SequenceNumber)
+	}
+
+	if peer.OutstandingRequests() != 2 {
+		t.Errorf("Expected (2) outstanding requests, got (%d)", peer.OutstandingRequests())
+	}
+
+	readPDUsOrFail(t, remoteReader, 2)
+
+	remoteWriter.Write(NewPDU(CommandGenericNack, EsmeRSysErr, 2, []*Parameter{}, []*Parameter{}))
+	remoteWriter.Write(NewPDU(CommandEnquireLinkResp, 0, 1, []*Parameter{}, []*Parameter{}))
+
+	response, err := first.Wait()
+	if err != nil || response.CommandID != CommandEnquireLinkResp || response.SequenceNumber != 1 {
+		t.Errorf("Expected enquire-link-resp with sequence 1 for first request, got (%v), error = (%v)", response, err)
+	}
+
+	response, err = second.Wait()
+	if err != nil || response.CommandID != CommandGenericNack || response.SequenceNumber != 2 {
+		t.Errorf("Expected generic-nack with sequence 2 for second request, got (%v), error = (%v)", response, err)
+	}
+
+	if peer.OutstandingRequests() != 0 {
+		t.Errorf("Expected no outstanding requests, got (%d)", peer.OutstandingRequests())
+	}
+}
+
+func TestSendRequestTimesOutAndReportsLateResponse(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+
+	late := make(chan *PDU, 1)
+	peer.AddObserver(func(event *PeerEvent) {
+		if event.Type == EventLateResponse {
+			late <- event.PDU
+		}
+	})
+
+	peer.SetResponseTimeout(20 * time.Millisecond)
+
+	future, err := peer.SendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{}))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	request := readPDUOrFail(t, remoteReader)
+
+	if _, err := future.Wait(); err != ErrResponseTimeout {
+		t.Fatalf("Expected ErrResponseTimeout from Wait(), got (%v)", err)
+	}
+
+	remoteWriter.Write(NewPDU(CommandEnquireLinkResp, 0, request.SequenceNumber, []*Parameter{}, []*Parameter{}))
+
+	select {
+	case pdu := <-late:
+		if pdu.SequenceNumber != request.SequenceNumber {
+			t.Errorf("Expected late response with sequence (%d), got %s", request.SequenceNumber, pdu)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for EventLateResponse")
+	}
+}
+
+func TestSequenceNumbersWrapAndSkipOutstanding(t *testing.T) {
+	peer, _ := newLoopbackPeer(t, roleESME)
+
+	peer.mutex.Lock()
+	peer.outstanding[1] = newResponseFuture(NewPDU(CommandEnquireLink, 0, 1, []*Parameter{}, []*Parameter{}))
+	peer.nextSequenceNumber = 0x7ffffffe
+	peer.mutex.Unlock()
+
+	for _, expected := range []uint32{0x7ffffffe, 0x7fffffff, 2, 3} {
+		if sequenceNumber := peer.allocateSequenceNumber(); sequenceNumber != expected {
+			t.Errorf("Expected sequence number (0x%08x), got (0x%08x)", expected, sequenceNumber)
+		}
+	}
+}
+
+func TestOutstandingRequestsCompleteWhenSessionCloses(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+
+	future, err := peer.SendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{}))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	remote.Close()
+
+	if _, err := future.Wait(); err == nil || err == ErrResponseTimeout {
+		t.Errorf("Expected session close error from Wait(), got (%v)", err)
+	}
+}
+
+func TestWindowAppliesBackpressure(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+
+	peer.SetWindowSize(2)
+
+	for i := 0; i < 2; i++ {
+		if _, err := peer.TrySendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{})); err != nil {
+			t.Fatalf("Expected no error on TrySendRequest() (%d), but got error = (%s)", i+1, err)
+		}
+	}
+
+	if _, err := peer.TrySendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{})); err != ErrWindowFull {
+		t.Fatalf("Expected ErrWindowFull on TrySendRequest() with full window, got (%v)", err)
+	}
+
+	if peer.OutstandingRequests() != 2 {
+		t.Errorf("Expected window occupancy (2), got (%d)", peer.OutstandingRequests())
+	}
+
+	blockedSend := make(chan *ResponseFuture)
+	go func() {
+		future, _ := peer.SendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{}))
+		blockedSend <- future
+	}()
+
+	select {
+	case <-blockedSend:
+		t.Fatalf("SendRequest() returned while the window was full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	requests := readPDUsOrFail(t, remoteReader, 2)
+	remoteWriter.Write(NewPDU(CommandEnquireLinkResp, 0, requests[0].SequenceNumber, []*Parameter{}, []*Parameter{}))
+
+	select {
+	case future := <-blockedSend:
+		if future == nil {
+			t.Fatalf("Expected blocked SendRequest() to succeed after a response")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("SendRequest() remained blocked after a response released a window slot")
+	}
+}
+
+func TestSendRequestContextAndWaitContextAreCancelled(t *testing.T) {
+	peer, _ := newLoopbackPeer(t, roleESME)
+
+	peer.SetWindowSize(1)
+
+	future, err := peer.SendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{}))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := peer.SendRequestContext(ctx, NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{})); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded from SendRequestContext() with full window, got (%v)", err)
+	}
+
+	if peer.OutstandingRequests() != 1 {
+		t.Errorf("Expected window occupancy (1) after cancelled send, got (%d)", peer.OutstandingRequests())
+	}
+
+	if _, err := future.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded from WaitContext(), got (%v)", err)
+	}
+}
+
+func TestWindowSlotReleasedOnTimeout(t *testing.T) {
+	peer, _ := newLoopbackPeer(t, roleESME)
+
+	peer.SetWindowSize(1)
+	peer.SetResponseTimeout(20 * time.Millisecond)
+
+	future, err := peer.TrySendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{}))
+	if err != nil {
+		t.Fatalf("Expected no error on TrySendRequest(), but got error = (%s)", err)
+	}
+
+	if _, err := peer.SendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{})); err != nil {
+		t.Fatalf("Expected blocked SendRequest() to succeed after a timeout, but got error = (%s)", err)
+	}
+
+	if _, err := future.Wait(); err != ErrResponseTimeout {
+		t.Errorf("Expected ErrResponseTimeout for first request, got (%v)", err)
+	}
+}
+
+func TestInboundEnquireLinkAnsweredBySession(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+
+	remoteWriter.Write(NewPDU(CommandEnquireLink, 0, 33, []*Parameter{}, []*Parameter{}))
+
+	response := readPDUOrFail(t, remoteReader)
+	if response.CommandID != CommandEnquireLinkResp || response.CommandStatus != EsmeROK || response.SequenceNumber != 33 {
+		t.Errorf("Expected enquire-link-resp with ESME_ROK and sequence 33, got %s", response)
+	}
+
+	select {
+	case pdu := <-peer.IncomingPDUs():
+		t.Errorf("Expected enquire-link not to be delivered, got %s", pdu)
+	default:
+	}
+}
+
+func TestKeepaliveDeclaresSilentPeerDead(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+
+	closed := make(chan error, 1)
+	peer.AddObserver(func(event *PeerEvent) {
+		if event.Type == EventStateChange && event.State == StateClosed {
+			closed <- event.Err
+		}
+	})
+
+	peer.SetEnquireLinkMaxMisses(2)
+	peer.SetEnquireLinkInterval(30 * time.Millisecond)
+
+	probes := readPDUsOrFail(t, remoteReader, 2)
+	for _, probe := range probes {
+		if probe.CommandID != CommandEnquireLink {
+			t.Errorf("Expected enquire-link probe, got %s", probe)
+		}
+	}
+
+	select {
+	case reason := <-closed:
+		if !errors.Is(reason, ErrPeerDead) {
+			t.Errorf("Expected ErrPeerDead as close reason, got (%v)", reason)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for session to close")
+	}
+
+	if !errors.Is(peer.Err(), ErrPeerDead) {
+		t.Errorf("Expected Err() to be ErrPeerDead, got (%v)", peer.Err())
+	}
+}
+
+func TestKeepaliveAnsweredKeepsSessionOpen(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+
+	peer.SetEnquireLinkMaxMisses(1)
+	peer.SetEnquireLinkInterval(20 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		probe := readPDUOrFail(t, remoteReader)
+		remoteWriter.Write(NewPDU(CommandEnquireLinkResp, 0, probe.SequenceNumber, []*Parameter{}, []*Parameter{}))
+	}
+
+	if peer.State() != StateOpen {
+		t.Errorf("Expected session state OPEN while keepalives are answered, got (%s)", peer.State())
+	}
+}
+
+func TestCommandAndCallResponseTimeouts(t *testing.T) {
+	peer, _ := newLoopbackPeer(t, roleESME)
+
+	peer.SetResponseTimeout(time.Hour)
+	peer.SetCommandResponseTimeout(CommandEnquireLink, 10*time.Millisecond)
+
+	commandTimeout, err := peer.SendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{}))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	peer.SetCommandResponseTimeout(CommandEnquireLink, time.Hour)
+
+	callTimeout, err := peer.SendRequestWithOptions(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{}), RequestOptions{Timeout: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequestWithOptions(), but got error = (%s)", err)
+	}
+
+	for _, future := range []*ResponseFuture{commandTimeout, callTimeout} {
+		select {
+		case <-future.Done():
+			if _, err := future.Wait(); err != ErrResponseTimeout {
+				t.Errorf("Expected ErrResponseTimeout, got (%v)", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Request did not time out")
+		}
+	}
+}
+
+func TestRetryOnTimeoutResendsWithNewSequenceNumber(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+
+	peer.SetResponseTimeout(30 * time.Millisecond)
+	peer.SetTimeoutPolicy(TimeoutPolicy{Action: RetryOnTimeout, MaxRetries: 2})
+
+	future, err := peer.SendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{}))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	attempts := readPDUsOrFail(t, remoteReader, 2)
+	if attempts[0].SequenceNumber == attempts[1].SequenceNumber {
+		t.Fatalf("Expected resent request to have a new sequence number, both have (%d)", attempts[0].SequenceNumber)
+	}
+
+	remoteWriter.Write(NewPDU(CommandEnquireLinkResp, 0, attempts[1].SequenceNumber, []*Parameter{}, []*Parameter{}))
+
+	response, err := future.Wait()
+	if err != nil {
+		t.Fatalf("Expected response to resent request, got error = (%s)", err)
+	}
+
+	if response.SequenceNumber != attempts[1].SequenceNumber || future.Request().SequenceNumber != attempts[1].SequenceNumber {
+		t.Errorf("Expected response and request sequence (%d), got (%d) and (%d)", attempts[1].SequenceNumber, response.SequenceNumber, future.Request().SequenceNumber)
+	}
+}
+
+func TestDisconnectOnTimeoutClosesSession(t *testing.T) {
+	peer, _ := newLoopbackPeer(t, roleESME)
+
+	policy := TimeoutPolicy{Action: DisconnectOnTimeout}
+	future, err := peer.SendRequestWithOptions(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{}), RequestOptions{Timeout: 10 * time.Millisecond, TimeoutPolicy: &policy})
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequestWithOptions(), but got error = (%s)", err)
+	}
+
+	if _, err := future.Wait(); err != ErrResponseTimeout {
+		t.Fatalf("Expected ErrResponseTimeout, got (%v)", err)
+	}
+
+	if peer.State() != StateClosed || !errors.Is(peer.Err(), ErrResponseTimeout) {
+		t.Errorf("Expected session CLOSED with ErrResponseTimeout, got (%s) with (%v)", peer.State(), peer.Err())
+	}
+}
+
+// bindLoopbackPeer binds the local ESME session as a transceiver, answering the bind from 'remote'
+func bindLoopbackPeer(t *testing.T, peer *Peer, remoteReader *NetworkStreamReader, remoteWriter *NetworkStreamWriter) {
+	go func() {
+		bind, err := remoteReader.ExtractNextPDUs()
+		if err == nil {
+			remoteWriter.Write(bindResponseFor(bind[0], EsmeROK))
+		}
+	}()
+
+	if _, _, err := (&ESME{}).BindToPeer(peer, BindInfo{Type: TransceiverBind, SystemID: "esme01"}); err != nil {
+		t.Fatalf("Expected no error on BindToPeer(), but got error = (%s)", err)
+	}
+}
+
+func TestCloseWaitsForOutstandingRequestsThenUnbinds(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+
+	bindLoopbackPeer(t, peer, remoteReader, remoteWriter)
+
+	inFlight, err := peer.SendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{}))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	closeResult := make(chan error, 1)
+	go func() {
+		closeResult <- peer.Close(context.Background())
+	}()
+
+	enquireLink := readPDUOrFail(t, remoteReader)
+
+	for peer.OutstandingRequests() != 1 || func() bool { peer.mutex.Lock(); defer peer.mutex.Unlock(); return !peer.closing }() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := peer.SendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{})); err != ErrSessionClosing {
+		t.Errorf("Expected ErrSessionClosing on SendRequest() while closing, got (%v)", err)
+	}
+
+	remoteWriter.Write(NewPDU(CommandEnquireLinkResp, 0, enquireLink.SequenceNumber, []*Parameter{}, []*Parameter{}))
+
+	unbind := readPDUOrFail(t, remoteReader)
+	if unbind.CommandID != CommandUnbind {
+		t.Fatalf("Expected unbind after in-flight request completed, got %s", unbind)
+	}
+
+	if _, err := inFlight.Wait(); err != nil {
+		t.Errorf("Expected in-flight request to complete, got error = (%s)", err)
+	}
+
+	remoteWriter.Write(NewPDU(CommandUnbindResp, 0, unbind.SequenceNumber, []*Parameter{}, []*Parameter{}))
+
+	if err := <-closeResult; err != nil {
+		t.Errorf("Expected no error on Close(), but got error = (%s)", err)
+	}
+
+	if peer.State() != StateClosed {
+		t.Errorf("Expected session state CLOSED after Close(), got (%s)", peer.State())
+	}
+}
+
+func TestCloseStopsWaitingAtDeadline(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+
+	bindLoopbackPeer(t, peer, remoteReader, remoteWriter)
+
+	if _, err := peer.SendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{})); err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	if err := peer.Close(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded from Close(), got (%v)", err)
+	}
+
+	if peer.State() != StateClosed {
+		t.Errorf("Expected session state CLOSED after Close(), got (%s)", peer.State())
+	}
+}
+
+func TestPeerUnbindIsAnsweredAndSessionCloses(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+
+	bindLoopbackPeer(t, peer, remoteReader, remoteWriter)
+
+	remoteWriter.Write(NewPDU(CommandUnbind, 0, 77, []*Parameter{}, []*Parameter{}))
+
+	response := readPDUOrFail(t, remoteReader)
+	if response.CommandID != CommandUnbindResp || response.CommandStatus != EsmeROK || response.SequenceNumber != 77 {
+		t.Errorf("Expected unbind-resp with ESME_ROK and sequence 77, got %s", response)
+	}
+
+	if _, err := remoteReader.ExtractNextPDUs(); err == nil {
+		t.Errorf("Expected the session to close the transport after unbind-resp")
+	}
+
+	if peer.State() != StateClosed || peer.Err() != ErrPeerUnbound {
+		t.Errorf("Expected session CLOSED with ErrPeerUnbound, got (%s) with (%v)", peer.State(), peer.Err())
+	}
+}
+
+func TestUnconsumedRequestsDoNotStopReadingResponses(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+	bindLoopbackPeer(t, peer, remoteReader, remoteWriter)
+
+	// nothing consumes IncomingPDUs(), so the requests beyond the queue are throttled
+	for sequence := uint32(1); sequence <= incomingQueueLength+6; sequence++ {
+		remoteWriter.Write(newShortMessagePDU(CommandDeliverSm, sequence, "hello"))
+	}
+
+	for i, response := range readPDUsOrFail(t, remoteReader, 6) {
+		if response.CommandID != CommandDeliverSmResp || response.CommandStatus != EsmeRThrottled || response.SequenceNumber != uint32(incomingQueueLength+1+i) {
+			t.Errorf("Expected deliver_sm_resp with ESME_RTHROTTLED for sequence (%d), got %s", incomingQueueLength+1+i, response)
+		}
+	}
+
+	future, err := peer.SendRequestWithOptions(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{}), RequestOptions{Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequestWithOptions(), but got error = (%s)", err)
+	}
+
+	request := readPDUOrFail(t, remoteReader)
+	remoteWriter.Write(NewPDU(CommandEnquireLinkResp, EsmeROK, request.SequenceNumber, []*Parameter{}, []*Parameter{}))
+
+	if _, err := future.Wait(); err != nil {
+		t.Errorf("Expected the response to be read while requests were queued, but got error = (%s)", err)
+	}
+	if queued := len(peer.IncomingPDUs()); queued != incomingQueueLength {
+		t.Errorf("Expected (%d) queued requests, got (%d)", incomingQueueLength, queued)
+	}
+}
+
+func TestTruncatedRequestIsAnsweredWithInvalidCommandLength(t *testing.T) {
+	metrics := NewMetrics()
+	peer, remote := newLoopbackPeer(t, roleSMSC)
+	peer.SetMetrics(metrics)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+
+	intercepted := make(chan *PDU, 10)
+	peer.AddInterceptor(func(peer *Peer, direction PDUDirection, pdu *PDU) Interception {
+		if direction == DirectionInbound {
+			intercepted <- pdu
+		}
+		return PassPDU(pdu)
+	})
+
+	remoteWriter.Write((&BindInfo{Type: TransceiverBind, SystemID: "esme01"}).bindPDU(1))
+	<-intercepted
+	received := <-peer.IncomingPDUs()
+	peer.SendPDU(NewPDU(CommandBindTransceiverResp, 0, received.SequenceNumber, []*Parameter{NewCOctetStringParameter("smsc")}, []*Parameter{}))
+	readPDUOrFail(t, remoteReader)
+
+	// a submit_sm that ends in the middle of source_addr, which has no null terminator
+	encoded, _ := newShortMessagePDU(CommandSubmitSm, 2, "hello").Encode()
+	truncated := append([]byte(nil), encoded[:21]...)
+	binary.BigEndian.PutUint32(truncated[0:4], uint32(len(truncated)))
+	remote.Write(truncated)
+
+	response := readPDUOrFail(t, remoteReader)
+	if response.CommandID != CommandGenericNack || response.CommandStatus != EsmeRInvCmdLen || response.SequenceNumber != 2 {
+		t.Errorf("Expected generic_nack with ESME_RINVCMDLEN for sequence (2), got %s", response)
+	}
+
+	select {
+	case pdu := <-intercepted:
+		t.Errorf("Expected the truncated request not to reach the interceptors, got %s", pdu)
+	case pdu := <-peer.IncomingPDUs():
+		t.Errorf("Expected the truncated request not to be delivered, got %s", pdu)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if peer.State() != StateBoundTrx {
+		t.Errorf("Expected the session to remain BOUND_TRX, got (%s)", peer.State())
+	}
+	expectSamples(t, scrapeOrFail(t, metrics), `smpp_decode_errors_total 1`)
+}
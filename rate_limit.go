@@ -0,0 +1,231 @@
+package smpp
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// congestionStateTag is the tag of the SMPP v5 congestion_state TLV, whose value is the SMSC's load
+// from 0 (idle) to 100 (congested)
+const congestionStateTag = 0x0428
+
+// RateLimit is a token bucket for the requests with one command_id.  Rate is the number of requests per
+// second, and Burst is the number that may be sent at once after a quiet period.  A Burst less than 1 is
+// treated as 1.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ThrottlePolicy controls how a session adapts its rate limits to the SMSC.  Each throttle signal (a
+// response with ESME_RTHROTTLED for a command, or a congestion_state TLV at or above
+// CongestionThreshold on any PDU) multiplies the current rate by SlowDownFactor, but not below
+// MinimumFraction of the configured rate.  After each QuietPeriod without a signal, the current rate is
+// multiplied by SpeedUpFactor, up to the configured rate.  An ESME_RTHROTTLED slows only the limiter
+// for the command that was throttled, and congestion slows every limiter.  A MinimumFraction or
+// QuietPeriod that is not positive is replaced with the one from DefaultThrottlePolicy, because a
+// limiter slowed to a rate of zero would never send or recover.
+type ThrottlePolicy struct {
+	SlowDownFactor      float64
+	MinimumFraction     float64
+	QuietPeriod         time.Duration
+	SpeedUpFactor       float64
+	CongestionThreshold uint8
+}
+
+// DefaultThrottlePolicy is the ThrottlePolicy of a new session
+var DefaultThrottlePolicy = ThrottlePolicy{
+	SlowDownFactor:      0.5,
+	MinimumFraction:     0.1,
+	QuietPeriod:         10 * time.Second,
+	SpeedUpFactor:       1.5,
+	CongestionThreshold: 90,
+}
+
+// RateLimiterState describes a rate limiter at a moment.  CurrentRate is less than ConfiguredRate while
+// the limiter is slowed by throttle signals.  Tokens is the number of requests that could be sent
+// immediately.
+type RateLimiterState struct {
+	CommandID       CommandIDType
+	ConfiguredRate  float64
+	CurrentRate     float64
+	Burst           int
+	Tokens          float64
+	ThrottleSignals uint64
+}
+
+// tokenBucket is the rate limiter for one command_id.  It is protected by the peer mutex.
+type tokenBucket struct {
+	limit           RateLimit
+	rate            float64
+	tokens          float64
+	lastRefill      time.Time
+	lastAdjustment  time.Time
+	throttleSignals uint64
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+
+	return &tokenBucket{limit: limit, rate: limit.Rate, tokens: float64(limit.Burst), lastRefill: now, lastAdjustment: now}
+}
+
+// refill restores the rate after quiet periods, then adds the tokens accumulated since the last refill
+func (bucket *tokenBucket) refill(now time.Time, policy ThrottlePolicy) {
+	for bucket.rate < bucket.limit.Rate && policy.QuietPeriod > 0 && now.Sub(bucket.lastAdjustment) >= policy.QuietPeriod {
+		bucket.lastAdjustment = bucket.lastAdjustment.Add(policy.QuietPeriod)
+		if policy.SpeedUpFactor > 1 {
+			bucket.rate = math.Min(bucket.rate*policy.SpeedUpFactor, bucket.limit.Rate)
+		} else {
+			bucket.rate = bucket.limit.Rate
+		}
+	}
+
+	if elapsed := now.Sub(bucket.lastRefill); elapsed > 0 {
+		bucket.tokens = math.Min(bucket.tokens+elapsed.Seconds()*bucket.rate, float64(bucket.limit.Burst))
+	}
+	bucket.lastRefill = now
+}
+
+// take removes a token if there is one, and returns zero.  Otherwise, it returns the time until there
+// will be one.
+func (bucket *tokenBucket) take(now time.Time, policy ThrottlePolicy) time.Duration {
+	bucket.refill(now, policy)
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0
+	}
+
+	if bucket.rate <= 0 {
+		if policy.QuietPeriod > 0 {
+			return policy.QuietPeriod
+		}
+		return DefaultThrottlePolicy.QuietPeriod
+	}
+
+	return time.Duration((1 - bucket.tokens) / bucket.rate * float64(time.Second))
+}
+
+// throttle slows the bucket in response to a throttle signal
+func (bucket *tokenBucket) throttle(now time.Time, policy ThrottlePolicy) {
+	bucket.refill(now, policy)
+
+	bucket.throttleSignals++
+	bucket.rate = math.Max(bucket.rate*policy.SlowDownFactor, bucket.limit.Rate*policy.MinimumFraction)
+	bucket.lastAdjustment = now
+}
+
+// SetRateLimit limits the rate at which requests with the command 'commandID' are sent.  SendRequest()
+// blocks until the limiter allows the request, and TrySendRequest() returns ErrRateLimited instead.
+// A Rate of zero removes the limit.  The requests that the session sends itself (enquire_link for the
+// keepalive and unbind for Close()) are not limited.
+func (peer *Peer) SetRateLimit(commandID CommandIDType, limit RateLimit) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	if limit.Rate <= 0 {
+		delete(peer.rateLimiters, commandID)
+		return
+	}
+
+	peer.rateLimiters[commandID] = newTokenBucket(limit, time.Now())
+}
+
+// SetThrottlePolicy sets how the rate limits adapt to ESME_RTHROTTLED and congestion_state.  The default
+// is DefaultThrottlePolicy.
+func (peer *Peer) SetThrottlePolicy(policy ThrottlePolicy) {
+	if policy.MinimumFraction <= 0 {
+		policy.MinimumFraction = DefaultThrottlePolicy.MinimumFraction
+	}
+	if policy.QuietPeriod <= 0 {
+		policy.QuietPeriod = DefaultThrottlePolicy.QuietPeriod
+	}
+
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	peer.throttlePolicy = policy
+}
+
+// RateLimiterStates returns the state of each rate limiter, ordered by command_id
+func (peer *Peer) RateLimiterStates() []RateLimiterState {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	now := time.Now()
+	states := make([]RateLimiterState, 0, len(peer.rateLimiters))
+
+	for commandID, bucket := range peer.rateLimiters {
+		bucket.refill(now, peer.throttlePolicy)
+		states = append(states, RateLimiterState{
+			CommandID:       commandID,
+			ConfiguredRate:  bucket.limit.Rate,
+			CurrentRate:     bucket.rate,
+			Burst:           bucket.limit.Burst,
+			Tokens:          bucket.tokens,
+			ThrottleSignals: bucket.throttleSignals,
+		})
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].CommandID < states[j].CommandID })
+
+	return states
+}
+
+// rateLimitDelay takes a token for a request with 'commandID', and returns zero, or returns the time
+// until a token will be available.  The caller must hold the peer mutex.
+func (peer *Peer) rateLimitDelay(commandID CommandIDType) time.Duration {
+	bucket, isLimited := peer.rateLimiters[commandID]
+	if !isLimited {
+		return 0
+	}
+
+	return bucket.take(time.Now(), peer.throttlePolicy)
+}
+
+// applyThrottleSignals slows the rate limiters if 'pdu' is a throttled response to a request with
+// 'requestCommandID', or carries a congestion_state at or above the threshold
+func (peer *Peer) applyThrottleSignals(pdu *PDU, requestCommandID CommandIDType) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	if len(peer.rateLimiters) == 0 {
+		return
+	}
+
+	now := time.Now()
+
+	if pdu.CommandStatus == EsmeRThrottled {
+		if bucket, isLimited := peer.rateLimiters[requestCommandID]; isLimited {
+			bucket.throttle(now, peer.throttlePolicy)
+		}
+	}
+
+	if congestion, isPresent := congestionState(pdu); isPresent && congestion >= peer.throttlePolicy.CongestionThreshold {
+		for _, bucket := range peer.rateLimiters {
+			bucket.throttle(now, peer.throttlePolicy)
+		}
+	}
+}
+
+// congestionState returns the value of the congestion_state TLV, if the PDU has one
+func congestionState(pdu *PDU) (uint8, bool) {
+	for _, param := range pdu.OptionalParameters {
+		if tlv, isTLV := param.Value.(TLV); isTLV && tlv.Tag == congestionStateTag {
+			switch value := tlv.Value.(type) {
+			case uint8:
+				return value, true
+			case []byte:
+				if len(value) == 1 {
+					return value[0], true
+				}
+			}
+		}
+	}
+
+	return 0, false
+}
@@ -0,0 +1,183 @@
+package smpp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRefillsAndRecovers(t *testing.T) {
+	policy := ThrottlePolicy{SlowDownFactor: 0.5, MinimumFraction: 0.2, QuietPeriod: 10 * time.Second, SpeedUpFactor: 2}
+	start := time.Now()
+	bucket := newTokenBucket(RateLimit{Rate: 10, Burst: 2}, start)
+
+	for i := 0; i < 2; i++ {
+		if delay := bucket.take(start, policy); delay != 0 {
+			t.Fatalf("Expected token (%d) of burst without delay, got delay (%s)", i+1, delay)
+		}
+	}
+
+	if delay := bucket.take(start, policy); delay != 100*time.Millisecond {
+		t.Errorf("Expected delay (100ms) with empty bucket at 10/s, got (%s)", delay)
+	}
+
+	if delay := bucket.take(start.Add(100*time.Millisecond), policy); delay != 0 {
+		t.Errorf("Expected token after 100ms at 10/s, got delay (%s)", delay)
+	}
+
+	for i := 0; i < 3; i++ {
+		bucket.throttle(start, policy)
+	}
+	if bucket.rate != 2 {
+		t.Errorf("Expected rate limited to minimum (2/s) after three signals, got (%v)", bucket.rate)
+	}
+
+	for _, expected := range []struct {
+		elapsed time.Duration
+		rate    float64
+	}{
+		{9 * time.Second, 2},
+		{10 * time.Second, 4},
+		{20 * time.Second, 8},
+		{30 * time.Second, 10},
+		{60 * time.Second, 10},
+	} {
+		bucket.refill(start.Add(expected.elapsed), policy)
+		if bucket.rate != expected.rate {
+			t.Errorf("Expected rate (%v) after quiet period of (%s), got (%v)", expected.rate, expected.elapsed, bucket.rate)
+		}
+	}
+
+	if bucket.tokens != 2 {
+		t.Errorf("Expected tokens capped at burst (2), got (%v)", bucket.tokens)
+	}
+}
+
+func TestRateLimitAppliesToSendRequest(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	go func() {
+		reader := NewNetworkStreamReader(remote)
+		for {
+			if _, err := reader.ExtractNextPDUs(); err != nil {
+				return
+			}
+		}
+	}()
+
+	peer.SetRateLimit(CommandEnquireLink, RateLimit{Rate: 20, Burst: 1})
+
+	if _, err := peer.TrySendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{})); err != nil {
+		t.Fatalf("Expected no error on first TrySendRequest(), but got error = (%s)", err)
+	}
+
+	if _, err := peer.TrySendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{})); err != ErrRateLimited {
+		t.Errorf("Expected ErrRateLimited on TrySendRequest() with empty bucket, got (%v)", err)
+	}
+
+	started := time.Now()
+	if _, err := peer.SendRequest(NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{})); err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+	if waited := time.Since(started); waited < 25*time.Millisecond {
+		t.Errorf("Expected SendRequest() to wait for a token (about 50ms), but it returned after (%s)", waited)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := peer.SendRequestContext(ctx, NewPDU(CommandEnquireLink, 0, 0, []*Parameter{}, []*Parameter{})); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded while waiting for a token, got (%v)", err)
+	}
+
+	if _, err := peer.TrySendRequest(NewPDU(CommandSubmitSm, 0, 0, []*Parameter{}, []*Parameter{})); err == ErrRateLimited {
+		t.Errorf("Expected no rate limit on a command without one")
+	}
+
+	peer.SetRateLimit(CommandEnquireLink, RateLimit{})
+	if states := peer.RateLimiterStates(); len(states) != 0 {
+		t.Errorf("Expected rate limit to be removed, got (%v)", states)
+	}
+}
+
+func TestThrottleSignalsSlowRateLimiters(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+	bindLoopbackPeer(t, peer, remoteReader, remoteWriter)
+
+	peer.SetRateLimit(CommandSubmitSm, RateLimit{Rate: 100, Burst: 10})
+	peer.SetRateLimit(CommandQuerySm, RateLimit{Rate: 40, Burst: 10})
+
+	answer := func(status uint32, optionalParameters []*Parameter) {
+		future, err := peer.SendRequest(newShortMessagePDU(CommandSubmitSm, 0, "hello"))
+		if err != nil {
+			t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+		}
+
+		request := readPDUOrFail(t, remoteReader)
+		mandatoryParameters := []*Parameter{}
+		if status == EsmeROK {
+			mandatoryParameters = append(mandatoryParameters, NewCOctetStringParameter("msg-01"))
+		}
+		remoteWriter.Write(NewPDU(CommandSubmitSmResp, status, request.SequenceNumber, mandatoryParameters, optionalParameters))
+
+		if _, err := future.Wait(); err != nil {
+			t.Fatalf("Expected no error on Wait(), but got error = (%s)", err)
+		}
+	}
+
+	answer(EsmeRThrottled, []*Parameter{})
+
+	states := peer.RateLimiterStates()
+	if len(states) != 2 || states[0].CommandID != CommandQuerySm || states[1].CommandID != CommandSubmitSm {
+		t.Fatalf("Expected states for query_sm and submit_sm in order, got (%v)", states)
+	}
+	if states[1].CurrentRate != 50 || states[1].ThrottleSignals != 1 || states[0].CurrentRate != 40 {
+		t.Errorf("Expected ESME_RTHROTTLED to halve only the submit_sm rate, got (%v)", states)
+	}
+
+	answer(EsmeROK, []*Parameter{NewTLVParameter(congestionStateTag, uint8(50))})
+	if states := peer.RateLimiterStates(); states[1].CurrentRate != 50 {
+		t.Errorf("Expected congestion_state below threshold to be ignored, got (%v)", states)
+	}
+
+	answer(EsmeROK, []*Parameter{NewTLVParameter(congestionStateTag, uint8(95))})
+	states = peer.RateLimiterStates()
+	if states[0].CurrentRate != 20 || states[1].CurrentRate != 25 {
+		t.Errorf("Expected congestion_state above threshold to halve every rate, got (%v)", states)
+	}
+}
+
+func TestZeroValuedThrottlePolicyStillLimits(t *testing.T) {
+	peer, remote := newLoopbackPeer(t, roleESME)
+	remoteReader := NewNetworkStreamReader(remote)
+	remoteWriter := NewNetworkStreamWriter(remote)
+	bindLoopbackPeer(t, peer, remoteReader, remoteWriter)
+
+	peer.SetThrottlePolicy(ThrottlePolicy{})
+	peer.SetRateLimit(CommandSubmitSm, RateLimit{Rate: 10, Burst: 1})
+
+	future, err := peer.SendRequest(newShortMessagePDU(CommandSubmitSm, 0, "hello"))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+	request := readPDUOrFail(t, remoteReader)
+	remoteWriter.Write(NewPDU(CommandSubmitSmResp, EsmeRThrottled, request.SequenceNumber, []*Parameter{}, []*Parameter{}))
+	future.Wait()
+
+	// the rate is slowed to the default minimum fraction, rather than to zero
+	if states := peer.RateLimiterStates(); len(states) != 1 || states[0].CurrentRate != 10*DefaultThrottlePolicy.MinimumFraction {
+		t.Errorf("Expected the submit_sm rate slowed to (%v), got (%v)", 10*DefaultThrottlePolicy.MinimumFraction, states)
+	}
+
+	if _, err := peer.TrySendRequest(newShortMessagePDU(CommandSubmitSm, 0, "hello")); err != ErrRateLimited {
+		t.Errorf("Expected ErrRateLimited with an empty bucket, got (%v)", err)
+	}
+
+	// a bucket at a rate of zero still reports a wait
+	bucket := newTokenBucket(RateLimit{Rate: 10, Burst: 1}, time.Now())
+	bucket.tokens, bucket.rate = 0, 0
+	if delay := bucket.take(bucket.lastRefill, ThrottlePolicy{}); delay <= 0 {
+		t.Errorf("Expected a positive delay from a bucket at a rate of zero, got (%s)", delay)
+	}
+}
@@ -0,0 +1,333 @@
+package smpp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// RecordedPDU is a single PDU in a session recording.  Elapsed is the time between the start
+// of the recording and the moment the PDU was read or written, and Encoded is the PDU exactly
+// as it appeared on the wire
+type RecordedPDU struct {
+	Direction PDUDirection
+	Elapsed   time.Duration
+	Encoded   []byte
+}
+
+// jsonRecordedPDU is the layout of one line in a session recording file
+type jsonRecordedPDU struct {
+	Direction string `json:"direction"`
+	ElapsedNs int64  `json:"elapsed_ns"`
+	PDU       string `json:"pdu"`
+}
+
+// SessionRecorder writes every PDU that it receives as a PDUTap to a stream in JSON-lines format,
+// one PDU per line, for example:
+//
+//	{"direction":"outbound","elapsed_ns":1520333,"pdu":"00000010000000150000000000000002"}
+//
+// The elapsed time is measured from the creation of the SessionRecorder using the monotonic clock.
+// Attach the same SessionRecorder to the NetworkStreamReader and NetworkStreamWriter of a connection,
+// or to a live session with Peer.SetTap(), to record both sides of a session.
+type SessionRecorder struct {
+	outputStream io.Writer
+	startTime    time.Time
+	firstError   error
+	mutex        sync.Mutex
+}
+
+// NewSessionRecorder creates a SessionRecorder that writes to 'outputStream'
+func NewSessionRecorder(outputStream io.Writer) *SessionRecorder {
+	return &SessionRecorder{outputStream: outputStream, startTime: time.Now()}
+}
+
+// TapPDU writes the PDU as a line in the recording.  Write errors are retained and may be
+// retrieved with Err(); once an error occurs, nothing further is written.
+func (recorder *SessionRecorder) TapPDU(direction PDUDirection, encoded []byte, timestamp time.Time) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	if recorder.firstError != nil {
+		return
+	}
+
+	line, err := json.Marshal(&jsonRecordedPDU{
+		Direction: direction.String(),
+		ElapsedNs: int64(timestamp.Sub(recorder.startTime)),
+		PDU:       hex.EncodeToString(encoded),
+	})
+
+	if err == nil {
+		_, err = recorder.outputStream.Write(append(line, '\n'))
+	}
+
+	recorder.firstError = err
+}
+
+// Err returns the first error encountered while writing to the output stream, or nil
+func (recorder *SessionRecorder) Err() error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	return recorder.firstError
+}
+
+// ReadSessionRecording reads a recording written by a SessionRecorder
+func ReadSessionRecording(inputStream io.Reader) ([]RecordedPDU, error) {
+	recording := make([]RecordedPDU, 0)
+	scanner := bufio.NewScanner(inputStream)
+	scanner.Buffer(make([]byte, 0, 65536), 16*1024*1024)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var line jsonRecordedPDU
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("recording line %d: %s", lineNumber, err)
+		}
+
+		encoded, err := hex.DecodeString(line.PDU)
+		if err != nil {
+			return nil, fmt.Errorf("recording line %d: pdu is not hex: %s", lineNumber, err)
+		}
+
+		if len(encoded) < 16 {
+			return nil, fmt.Errorf("recording line %d: pdu is (%d) octets, shorter than a PDU header", lineNumber, len(encoded))
+		}
+
+		entry := RecordedPDU{Elapsed: time.Duration(line.ElapsedNs), Encoded: encoded}
+
+		switch line.Direction {
+		case "outbound":
+			entry.Direction = DirectionOutbound
+		case "inbound":
+			entry.Direction = DirectionInbound
+		default:
+			return nil, fmt.Errorf("recording line %d: direction (%s) is not outbound or inbound", lineNumber, line.Direction)
+		}
+
+		recording = append(recording, entry)
+	}
+
+	return recording, scanner.Err()
+}
+
+// ReplayResult summarizes a session replay
+type ReplayResult struct {
+	// SentPDUs is the number of PDUs written to the peer
+	SentPDUs int
+	// MatchedPDUs is the number of PDUs from the peer that matched a PDU in the recording
+	MatchedPDUs int
+	// Differences describes each PDU from the peer that matched the recording by position,
+	// but whose command_id or command_status differs from the recorded PDU
+	Differences []string
+	// UnexpectedPDUs are the PDUs from the peer that did not match any PDU in the recording
+	UnexpectedPDUs []*PDU
+}
+
+// SessionReplayer plays one side of a session recording against a live peer.  Requests from the
+// replayed side are sent with newly allocated sequence numbers.  Responses from the replayed side
+// are sent with the sequence number of the live request that matched the corresponding recorded
+// request.  Each PDU recorded from the other side is waited for before the replay continues:
+// a recorded request is matched by the next live request with the same command_id, and a
+// recorded response is matched by the live response to the corresponding replayed request.
+type SessionReplayer struct {
+	recording       []RecordedPDU
+	replayedSide    PDUDirection
+	preserveTiming  bool
+	responseTimeout time.Duration
+}
+
+// NewSessionReplayer creates a SessionReplayer that replays the PDUs in 'recording' that have
+// the direction 'replayedSide'.  DirectionOutbound replays the side that made the recording.
+// By default, PDUs are sent as fast as possible, and the replayer waits up to 10 seconds for
+// each PDU from the peer
+func NewSessionReplayer(recording []RecordedPDU, replayedSide PDUDirection) *SessionReplayer {
+	return &SessionReplayer{recording: recording, replayedSide: replayedSide, responseTimeout: 10 * time.Second}
+}
+
+// SetPreserveTiming determines whether each PDU is sent at the same offset from the start of the
+// replay as it had from the start of the recording (true), or as soon as possible (false)
+func (replayer *SessionReplayer) SetPreserveTiming(preserve bool) {
+	replayer.preserveTiming = preserve
+}
+
+// SetResponseTimeout sets how long the replayer waits for each PDU that it expects from the peer
+func (replayer *SessionReplayer) SetResponseTimeout(timeout time.Duration) {
+	replayer.responseTimeout = timeout
+}
+
+// Replay plays the recording against the peer at the other end of 'conn'.  It returns an error if
+// an expected PDU does not arrive in time or the connection fails, along with the result so far.
+// The caller owns 'conn' and should close it when Replay returns.
+func (replayer *SessionReplayer) Replay(conn net.Conn) (*ReplayResult, error) {
+	session := &replaySession{
+		replayer:           replayer,
+		writer:             NewNetworkStreamWriter(conn),
+		incoming:           make(chan *PDU, 64),
+		done:               make(chan struct{}),
+		nextSequenceNumber: 1,
+		localSequenceMap:   make(map[uint32]uint32),
+		peerSequenceMap:    make(map[uint32]uint32),
+		result:             &ReplayResult{Differences: []string{}, UnexpectedPDUs: []*PDU{}},
+	}
+	defer close(session.done)
+
+	go session.readFrom(NewNetworkStreamReader(conn))
+
+	err := session.run()
+
+	session.result.UnexpectedPDUs = append(session.result.UnexpectedPDUs, session.held...)
+
+	return session.result, err
+}
+
+type replaySession struct {
+	replayer           *SessionReplayer
+	writer             *NetworkStreamWriter
+	incoming           chan *PDU
+	readError          error
+	done               chan struct{}
+	held               []*PDU
+	nextSequenceNumber uint32
+	localSequenceMap   map[uint32]uint32
+	peerSequenceMap    map[uint32]uint32
+	result             *ReplayResult
+}
+
+func (session *replaySession) readFrom(reader *NetworkStreamReader) {
+	for {
+		pdus, err := reader.Read()
+
+		for _, pdu := range pdus {
+			select {
+			case session.incoming <- pdu:
+			case <-session.done:
+				return
+			}
+		}
+
+		if err != nil {
+			session.readError = err
+			close(session.incoming)
+			return
+		}
+	}
+}
+
+func (session *replaySession) run() error {
+	if len(session.replayer.recording) == 0 {
+		return nil
+	}
+
+	replayStart := time.Now()
+	recordingStart := session.replayer.recording[0].Elapsed
+
+	for i, entry := range session.replayer.recording {
+		commandID := CommandIDType(binary.BigEndian.Uint32(entry.Encoded[4:8]))
+		commandStatus := binary.BigEndian.Uint32(entry.Encoded[8:12])
+		recordedSequence := binary.BigEndian.Uint32(entry.Encoded[12:16])
+		isRequest := uint32(commandID)&0x80000000 == 0
+
+		if entry.Direction == session.replayer.replayedSide {
+			if session.replayer.preserveTiming {
+				time.Sleep(time.Until(replayStart.Add(entry.Elapsed - recordingStart)))
+			}
+
+			liveSequence := recordedSequence
+
+			if isRequest {
+				liveSequence = session.nextSequenceNumber
+				session.nextSequenceNumber++
+				session.localSequenceMap[recordedSequence] = liveSequence
+			} else if mapped, matched := session.peerSequenceMap[recordedSequence]; matched {
+				liveSequence = mapped
+			}
+
+			outgoing := append([]byte(nil), entry.Encoded...)
+			binary.BigEndian.PutUint32(outgoing[12:16], liveSequence)
+
+			if err := session.writer.WriteEncoded(outgoing); err != nil {
+				return err
+			}
+
+			session.result.SentPDUs++
+			continue
+		}
+
+		var matched *PDU
+		var err error
+
+		if isRequest {
+			matched, err = session.waitFor(func(pdu *PDU) bool {
+				return pdu.IsRequest() && pdu.CommandID == commandID
+			})
+
+			if err == nil {
+				session.peerSequenceMap[recordedSequence] = matched.SequenceNumber
+			}
+		} else {
+			liveSequence, mapped := session.localSequenceMap[recordedSequence]
+			if !mapped {
+				liveSequence = recordedSequence
+			}
+
+			matched, err = session.waitFor(func(pdu *PDU) bool {
+				return !pdu.IsRequest() && pdu.SequenceNumber == liveSequence
+			})
+		}
+
+		if err != nil {
+			return fmt.Errorf("waiting for recorded PDU %d (%s): %s", i+1, CommandName(commandID), err)
+		}
+
+		session.result.MatchedPDUs++
+
+		if matched.CommandID != commandID || matched.CommandStatus != commandStatus {
+			session.result.Differences = append(session.result.Differences, fmt.Sprintf("recorded PDU %d: expected %s with status %s, got %s with status %s",
+				i+1, CommandName(commandID), CommandStatusName(commandStatus), matched.CommandName(), CommandStatusName(matched.CommandStatus)))
+		}
+	}
+
+	return nil
+}
+
+// waitFor returns the first held or newly received PDU that satisfies 'matches'.  Non-matching
+// PDUs that are received while waiting are held for later entries.
+func (session *replaySession) waitFor(matches func(*PDU) bool) (*PDU, error) {
+	for i, pdu := range session.held {
+		if matches(pdu) {
+			session.held = append(session.held[:i], session.held[i+1:]...)
+			return pdu, nil
+		}
+	}
+
+	timer := time.NewTimer(session.replayer.responseTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case pdu, isOpen := <-session.incoming:
+			if !isOpen {
+				return nil, session.readError
+			}
+
+			if matches(pdu) {
+				return pdu, nil
+			}
+			session.held = append(session.held, pdu)
+
+		case <-timer.C:
+			return nil, fmt.Errorf("timed out")
+		}
+	}
+}
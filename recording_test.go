@@ -0,0 +1,267 @@
+package smpp
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func encodeOrFail(t *testing.T, pdu *PDU) []byte {
+	encoded, err := pdu.Encode()
+
+	if err != nil {
+		t.Fatalf("Failed to encode (%s): %s", pdu.CommandName(), err)
+	}
+
+	return encoded
+}
+
+func TestSessionRecorderRoundTrip(t *testing.T) {
+	localEnd, remoteEnd := net.Pipe()
+	defer localEnd.Close()
+	defer remoteEnd.Close()
+
+	output := new(bytes.Buffer)
+	recorder := NewSessionRecorder(output)
+
+	writer := NewNetworkStreamWriter(localEnd)
+	writer.SetTap(recorder)
+	reader := NewNetworkStreamReader(localEnd)
+	reader.SetTap(recorder)
+
+	enquireLink := NewPDU(CommandEnquireLink, 0, 9, []*Parameter{}, []*Parameter{})
+	enquireLinkResp := NewPDU(CommandEnquireLinkResp, 0, 9, []*Parameter{}, []*Parameter{})
+
+	go func() {
+		remoteReader := NewNetworkStreamReader(remoteEnd)
+		if _, err := remoteReader.ExtractNextPDUs(); err == nil {
+			_ = NewNetworkStreamWriter(remoteEnd).Write(enquireLinkResp)
+		}
+	}()
+
+	if err := writer.Write(enquireLink); err != nil {
+		t.Fatalf("Expected no error on Write(), but got error = (%s)", err)
+	}
+
+	if _, err := reader.ExtractNextPDUs(); err != nil {
+		t.Fatalf("Expected no error on ExtractNextPDUs(), but got error = (%s)", err)
+	}
+
+	if err := recorder.Err(); err != nil {
+		t.Fatalf("Expected no error from recorder, but got error = (%s)", err)
+	}
+
+	recording, err := ReadSessionRecording(output)
+
+	if err != nil {
+		t.Fatalf("Expected no error on ReadSessionRecording(), but got error = (%s)", err)
+	}
+
+	if len(recording) != 2 {
+		t.Fatalf("Expected two recorded PDUs, got (%d)", len(recording))
+	}
+
+	if recording[0].Direction != DirectionOutbound || !bytes.Equal(recording[0].Encoded, encodeOrFail(t, enquireLink)) {
+		t.Errorf("First recorded PDU should be outbound enquire-link")
+	}
+
+	if recording[1].Direction != DirectionInbound || !bytes.Equal(recording[1].Encoded, encodeOrFail(t, enquireLinkResp)) {
+		t.Errorf("Second recorded PDU should be inbound enquire-link-resp")
+	}
+
+	if recording[1].Elapsed < recording[0].Elapsed {
+		t.Errorf("Elapsed time of second PDU (%s) is less than first (%s)", recording[1].Elapsed, recording[0].Elapsed)
+	}
+}
+
+func TestReadSessionRecordingErrors(t *testing.T) {
+	for _, document := range []string{
+		`{"direction":"sideways","elapsed_ns":0,"pdu":"00000010000000150000000000000002"}`,
+		`{"direction":"inbound","elapsed_ns":0,"pdu":"0000"}`,
+		`{"direction":"inbound","elapsed_ns":0,"pdu":"zz"}`,
+		`not json`,
+	} {
+		if _, err := ReadSessionRecording(bytes.NewBufferString(document)); err == nil {
+			t.Errorf("Expected error on ReadSessionRecording() for (%s), but got none", document)
+		}
+	}
+}
+
+func TestSessionReplayerRemapsSequenceNumbers(t *testing.T) {
+	bindTransmitter := NewPDU(CommandBindTransmitter, 0, 0x10, []*Parameter{
+		NewCOctetStringParameter("esme01"),
+		NewCOctetStringParameter("password"),
+		NewCOctetStringParameter(""),
+		NewFLParameter(uint8(0x34)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewCOctetStringParameter(""),
+	}, []*Parameter{})
+	bindTransmitterResp := NewPDU(CommandBindTransmitterResp, 0, 0x10, []*Parameter{NewCOctetStringParameter("smsc")}, []*Parameter{})
+	smscEnquireLink := NewPDU(CommandEnquireLink, 0, 0x700, []*Parameter{}, []*Parameter{})
+	enquireLinkResp := NewPDU(CommandEnquireLinkResp, 0, 0x700, []*Parameter{}, []*Parameter{})
+	unbind := NewPDU(CommandUnbind, 0, 0x5e, []*Parameter{}, []*Parameter{})
+	unbindResp := NewPDU(CommandUnbindResp, 0, 0x5e, []*Parameter{}, []*Parameter{})
+
+	recording := []RecordedPDU{
+		{DirectionOutbound, 0, encodeOrFail(t, bindTransmitter)},
+		{DirectionInbound, time.Millisecond, encodeOrFail(t, bindTransmitterResp)},
+		{DirectionInbound, 2 * time.Millisecond, encodeOrFail(t, smscEnquireLink)},
+		{DirectionOutbound, 3 * time.Millisecond, encodeOrFail(t, enquireLinkResp)},
+		{DirectionOutbound, 4 * time.Millisecond, encodeOrFail(t, unbind)},
+		{DirectionInbound, 5 * time.Millisecond, encodeOrFail(t, unbindResp)},
+	}
+
+	esmeEnd, smscEnd := net.Pipe()
+	defer esmeEnd.Close()
+	defer smscEnd.Close()
+
+	receivedBySmsc := make(chan *PDU, 10)
+
+	go func() {
+		reader := NewNetworkStreamReader(smscEnd)
+		writer := NewNetworkStreamWriter(smscEnd)
+
+		for {
+			pdus, err := reader.Read()
+			if err != nil {
+				close(receivedBySmsc)
+				return
+			}
+
+			for _, pdu := range pdus {
+				receivedBySmsc <- pdu
+
+				switch pdu.CommandID {
+				case CommandBindTransmitter:
+					_ = writer.Write(NewPDU(CommandBindTransmitterResp, EsmeRInvPaswd, pdu.SequenceNumber, []*Parameter{NewCOctetStringParameter("smsc")}, []*Parameter{}))
+					_ = writer.Write(NewPDU(CommandEnquireLink, 0, 42, []*Parameter{}, []*Parameter{}))
+				case CommandUnbind:
+					_ = writer.Write(NewPDU(CommandUnbindResp, 0, pdu.SequenceNumber, []*Parameter{}, []*Parameter{}))
+				}
+			}
+		}
+	}()
+
+	replayer := NewSessionReplayer(recording, DirectionOutbound)
+	replayer.SetPreserveTiming(true)
+	replayer.SetResponseTimeout(2 * time.Second)
+
+	result, err := replayer.Replay(esmeEnd)
+
+	if err != nil {
+		t.Fatalf("Expected no error on Replay(), but got error = (%s)", err)
+	}
+
+	if result.SentPDUs != 3 || result.MatchedPDUs != 3 {
+		t.Errorf("Expected 3 sent and 3 matched PDUs, got (%d) sent and (%d) matched", result.SentPDUs, result.MatchedPDUs)
+	}
+
+	if len(result.Differences) != 1 {
+		t.Errorf("Expected one difference (bind status), got (%d): %v", len(result.Differences), result.Differences)
+	}
+
+	expectedAtSmsc := []struct {
+		commandID CommandIDType
+		sequence  uint32
+	}{
+		{CommandBindTransmitter, 1},
+		{CommandEnquireLinkResp, 42},
+		{CommandUnbind, 2},
+	}
+
+	for _, expected := range expectedAtSmsc {
+		pdu := <-receivedBySmsc
+		if pdu.CommandID != expected.commandID || pdu.SequenceNumber != expected.sequence {
+			t.Errorf("SMSC expected %s with sequence (%d), got %s", CommandName(expected.commandID), expected.sequence, pdu)
+		}
+	}
+}
+
+func TestSessionReplayerTimesOutWaitingForPeer(t *testing.T) {
+	enquireLink := encodeOrFail(t, NewPDU(CommandEnquireLink, 0, 1, []*Parameter{}, []*Parameter{}))
+	enquireLinkResp := encodeOrFail(t, NewPDU(CommandEnquireLinkResp, 0, 1, []*Parameter{}, []*Parameter{}))
+
+	esmeEnd, smscEnd := net.Pipe()
+	defer esmeEnd.Close()
+	defer smscEnd.Close()
+
+	go func() {
+		buffer := make([]byte, 64)
+		for {
+			if _, err := smscEnd.Read(buffer); err != nil {
+				return
+			}
+		}
+	}()
+
+	replayer := NewSessionReplayer([]RecordedPDU{
+		{DirectionOutbound, 0, enquireLink},
+		{DirectionInbound, 0, enquireLinkResp},
+	}, DirectionOutbound)
+	replayer.SetResponseTimeout(50 * time.Millisecond)
+
+	result, err := replayer.Replay(esmeEnd)
+
+	if err == nil {
+		t.Fatalf("Expected timeout error on Replay(), but got none")
+	}
+
+	if result.SentPDUs != 1 {
+		t.Errorf("Expected one sent PDU, got (%d)", result.SentPDUs)
+	}
+
+	if binary.BigEndian.Uint32(enquireLink[12:16]) != 1 {
+		t.Errorf("Replay modified the recorded PDU")
+	}
+}
+
+func TestSessionRecorderRecordsLiveSessions(t *testing.T) {
+	var smscRecording, esmeRecording bytes.Buffer
+
+	smsc := NewSMSC("smsc01", nil, BaseSMSCHandler{})
+	smsc.SetSessionConfigurator(func(peer *Peer) { peer.SetTap(NewSessionRecorder(&smscRecording)) })
+	smscAddr, _ := startSMSC(t, smsc)
+
+	esme := &ESME{}
+	esme.SetSessionConfigurator(func(peer *Peer) { peer.SetTap(NewSessionRecorder(&esmeRecording)) })
+
+	peer := connectAndBindOrFail(t, esme, smscAddr, BindInfo{Type: TransmitterBind, SystemID: "esme01"})
+
+	future, err := peer.SendRequest(newShortMessagePDU(CommandSubmitSm, 0, "hello"))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+	if _, err := future.Wait(); err != nil {
+		t.Fatalf("Expected no error on Wait(), but got error = (%s)", err)
+	}
+
+	peer.Close(context.Background())
+	smsc.Shutdown(context.Background())
+
+	for _, expected := range []struct {
+		recording  *bytes.Buffer
+		directions []PDUDirection
+	}{
+		{&esmeRecording, []PDUDirection{DirectionOutbound, DirectionInbound, DirectionOutbound, DirectionInbound}},
+		{&smscRecording, []PDUDirection{DirectionInbound, DirectionOutbound, DirectionInbound, DirectionOutbound}},
+	} {
+		recorded, err := ReadSessionRecording(expected.recording)
+		if err != nil {
+			t.Fatalf("Expected no error on ReadSessionRecording(), but got error = (%s)", err)
+		}
+		if len(recorded) < len(expected.directions) {
+			t.Fatalf("Expected at least (%d) recorded PDUs, got (%d)", len(expected.directions), len(recorded))
+		}
+
+		for i, commandID := range []CommandIDType{CommandBindTransmitter, CommandBindTransmitterResp, CommandSubmitSm, CommandSubmitSmResp} {
+			pdu, err := DecodePDU(recorded[i].Encoded)
+			if err != nil || pdu.CommandID != commandID || recorded[i].Direction != expected.directions[i] {
+				t.Errorf("Expected recorded PDU (%d) to be %s %s, got (%v) %s", i, expected.directions[i], CommandName(commandID), pdu, recorded[i].Direction)
+			}
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package smpp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ResponseFuture is the pending result of a request sent with Peer.SendRequest().  It completes
+// when the matching response or generic_nack arrives, when the response timeout expires, or when
+// the session closes.
+type ResponseFuture struct {
+	request  *PDU
+	done     chan struct{}
+	response *PDU
+	err      error
+	timer    *time.Timer
+	timeout  time.Duration
+	sent     time.Time
+	policy   TimeoutPolicy
+	retries  int
+	mutex    sync.Mutex
+}
+
+func newResponseFuture(request *PDU) *ResponseFuture {
+	return &ResponseFuture{request: request, done: make(chan struct{})}
+}
+
+// Request returns the request, including the sequence number that the session assigned to it.  If
+// the request is resent under RetryOnTimeout, the sequence number changes, so it should be read only
+// after the future completes.
+func (future *ResponseFuture) Request() *PDU {
+	return future.request
+}
+
+// Done returns a channel that is closed when the future completes
+func (future *ResponseFuture) Done() <-chan struct{} {
+	return future.done
+}
+
+// Wait blocks until the future completes.  It returns the response PDU, which is either the
+// response to the request or a generic_nack; the caller should check the command_id and
+// command_status.  If no response arrives in time, the error is ErrResponseTimeout.  If the
+// session closes first, the error describes why.
+func (future *ResponseFuture) Wait() (*PDU, error) {
+	<-future.done
+	return future.response, future.err
+}
+
+// WaitContext is the same as Wait(), except that it returns ctx.Err() if 'ctx' is done before the
+// future completes.  The request remains outstanding, so the future may still complete later.
+func (future *ResponseFuture) WaitContext(ctx context.Context) (*PDU, error) {
+	select {
+	case <-future.done:
+		return future.response, future.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// complete sets the result of the future, if it has not already completed.  It returns false if the
+// future was already complete.
+func (future *ResponseFuture) complete(response *PDU, err error) bool {
+	future.mutex.Lock()
+	defer future.mutex.Unlock()
+
+	select {
+	case <-future.done:
+		return false
+	default:
+	}
+
+	if future.timer != nil {
+		future.timer.Stop()
+	}
+
+	future.response = response
+	future.err = err
+	close(future.done)
+
+	return true
+}
@@ -0,0 +1,158 @@
+package smpp
+
+// SessionState is an enumeration of the SMPP session states
+type SessionState int
+
+// These are the session states defined by the SMPP specification
+const (
+	// StateOpen means a transport connection exists, but there is no bind
+	StateOpen SessionState = iota
+	// StateBoundTx means the ESME is bound as a transmitter
+	StateBoundTx
+	// StateBoundRx means the ESME is bound as a receiver
+	StateBoundRx
+	// StateBoundTrx means the ESME is bound as a transceiver
+	StateBoundTrx
+	// StateOutbound means the SMSC has sent an outbind, and the ESME is expected to bind
+	StateOutbound
+	// StateUnbound means the bind has been released with unbind and unbind_resp
+	StateUnbound
+	// StateClosed means the transport connection is closed
+	StateClosed
+)
+
+var sessionStateName = map[SessionState]string{
+	StateOpen:     "OPEN",
+	StateBoundTx:  "BOUND_TX",
+	StateBoundRx:  "BOUND_RX",
+	StateBoundTrx: "BOUND_TRX",
+	StateOutbound: "OUTBOUND",
+	StateUnbound:  "UNBOUND",
+	StateClosed:   "CLOSED",
+}
+
+// String returns the name of the state used by the SMPP specification (e.g., "BOUND_TX")
+func (state SessionState) String() string {
+	return sessionStateName[state]
+}
+
+// IsBound returns true for StateBoundTx, StateBoundRx and StateBoundTrx
+func (state SessionState) IsBound() bool {
+	return state == StateBoundTx || state == StateBoundRx || state == StateBoundTrx
+}
+
+type sessionStateSet uint
+
+func sessionStates(states ...SessionState) sessionStateSet {
+	set := sessionStateSet(0)
+
+	for _, state := range states {
+		set |= 1 << uint(state)
+	}
+
+	return set
+}
+
+func (set sessionStateSet) contains(state SessionState) bool {
+	return set&(1<<uint(state)) != 0
+}
+
+// sessionRole identifies whether an entity is the ESME or the SMSC in a session
+type sessionRole int
+
+const (
+	roleESME sessionRole = iota
+	roleSMSC
+)
+
+// requestRule describes which entity may send a request, and in which states
+type requestRule struct {
+	sentByESME bool
+	sentBySMSC bool
+	states     sessionStateSet
+}
+
+var (
+	anyBoundState      = sessionStates(StateBoundTx, StateBoundRx, StateBoundTrx)
+	transmitterStates  = sessionStates(StateBoundTx, StateBoundTrx)
+	receiverStates     = sessionStates(StateBoundRx, StateBoundTrx)
+	enquireLinkStates  = sessionStates(StateOpen, StateOutbound, StateBoundTx, StateBoundRx, StateBoundTrx)
+	bindFromOpenStates = sessionStates(StateOpen)
+	bindAfterOutbind   = sessionStates(StateOpen, StateOutbound)
+)
+
+var sessionRequestRules = map[CommandIDType]requestRule{
+	CommandBindTransmitter:   {true, false, bindFromOpenStates},
+	CommandBindReceiver:      {true, false, bindAfterOutbind},
+	CommandBindTransceiver:   {true, false, bindAfterOutbind},
+	CommandOutbind:           {false, true, sessionStates(StateOpen)},
+	CommandSubmitSm:          {true, false, transmitterStates},
+	CommandSubmitMulti:       {true, false, transmitterStates},
+	CommandQuerySm:           {true, false, transmitterStates},
+	CommandCancelSm:          {true, false, transmitterStates},
+	CommandReplaceSm:         {true, false, transmitterStates},
+	CommandDeliverSm:         {false, true, receiverStates},
+	CommandAlertNotification: {false, true, receiverStates},
+	CommandUnbind:            {true, true, anyBoundState},
+	CommandEnquireLink:       {true, true, enquireLinkStates},
+}
+
+// requestIsPermitted returns true if an entity with the role 'sender' may send the request
+// 'commandID' in 'state'.  data_sm is sent by the ESME when it is a transmitter, and by the SMSC
+// when the ESME is a receiver.  Responses are always permitted, except in StateClosed.
+func requestIsPermitted(commandID CommandIDType, sender sessionRole, state SessionState) bool {
+	if state == StateClosed {
+		return false
+	}
+
+	if uint32(commandID)&0x80000000 != 0 {
+		return true
+	}
+
+	if commandID == CommandDataSm {
+		if sender == roleESME {
+			return transmitterStates.contains(state)
+		}
+		return receiverStates.contains(state)
+	}
+
+	rule, isDefined := sessionRequestRules[commandID]
+	if !isDefined {
+		return false
+	}
+
+	if (sender == roleESME && !rule.sentByESME) || (sender == roleSMSC && !rule.sentBySMSC) {
+		return false
+	}
+
+	return rule.states.contains(state)
+}
+
+var boundStateForBindResponse = map[CommandIDType]SessionState{
+	CommandBindTransmitterResp: StateBoundTx,
+	CommandBindReceiverResp:    StateBoundRx,
+	CommandBindTransceiverResp: StateBoundTrx,
+}
+
+// nextSessionState returns the state that follows 'current' when 'pdu' is sent or received.
+// The transitions do not depend on which entity sent the PDU.
+func nextSessionState(current SessionState, pdu *PDU) SessionState {
+	switch pdu.CommandID {
+	case CommandBindTransmitterResp, CommandBindReceiverResp, CommandBindTransceiverResp:
+		if pdu.CommandStatus == EsmeROK && (current == StateOpen || current == StateOutbound) {
+			return boundStateForBindResponse[pdu.CommandID]
+		}
+
+	case CommandOutbind:
+		if current == StateOpen {
+			return StateOutbound
+		}
+
+	case CommandUnbindResp:
+		if current.IsBound() {
+			return StateUnbound
+		}
+	}
+
+	return current
+}
@@ -0,0 +1,444 @@
+package smpp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultBindTimeout is the time that an SMSC waits for a bind on a new connection, unless it is
+// changed with SetBindTimeout()
+const DefaultBindTimeout = 30 * time.Second
+
+// ErrSMSCClosed is returned by SMSC.Serve() and SMSC.ListenAndServe() after SMSC.Shutdown() is called
+var ErrSMSCClosed = errors.New("SMSC is shut down")
+
+// ErrNoReceiver is returned by SMSC.SendToReceiver() when no session with the system_id is bound as a
+// receiver or transceiver
+var ErrNoReceiver = errors.New("No bound receiver for system_id")
+
+// SMSC accepts connections and binds from ESMEs.  It may listen on any number of addresses at once, by
+// calling ListenAndServe() or Serve() in separate goroutines.  Each bind is passed to the Authenticator,
+// and each bound session passes the ESME's requests to the SMSCHandler.
+type SMSC struct {
+	systemID      string
+	authenticator Authenticator
+	handler       SMSCHandler
+
+	mutex            sync.Mutex
+	bindTimeout      time.Duration
+	configureSession func(peer *Peer)
+	listeners        map[net.Listener]bool
+	sessions         map[*Peer]*SMSCSession
+	eventLogger      *EventLogger
+	metrics          *Metrics
+	connections      sync.WaitGroup
+	shutdown         chan struct{}
+}
+
+// NewSMSC creates an SMSC that answers binds with the system_id 'systemID'.  If 'authenticator' is
+// nil, every bind is accepted.  If 'handler' is nil, requests are answered as BaseSMSCHandler answers
+// them.
+func NewSMSC(systemID string, authenticator Authenticator, handler SMSCHandler) *SMSC {
+	if handler == nil {
+		handler = BaseSMSCHandler{}
+	}
+
+	return &SMSC{
+		systemID:      systemID,
+		authenticator: authenticator,
+		handler:       handler,
+		bindTimeout:   DefaultBindTimeout,
+		listeners:     make(map[net.Listener]bool),
+		sessions:      make(map[*Peer]*SMSCSession),
+		shutdown:      make(chan struct{}),
+	}
+}
+
+// SetBindTimeout sets the time that the SMSC waits for a bind on a new connection before closing it
+func (smsc *SMSC) SetBindTimeout(timeout time.Duration) {
+	smsc.mutex.Lock()
+	defer smsc.mutex.Unlock()
+
+	smsc.bindTimeout = timeout
+}
+
+// SetSessionConfigurator sets a function that is called with each new session when its connection is
+// accepted, before the bind arrives.  It may be used to attach a tap with Peer.SetTap(), or to set the
+// window size, keepalive and timeouts.
+func (smsc *SMSC) SetSessionConfigurator(configure func(peer *Peer)) {
+	smsc.mutex.Lock()
+	defer smsc.mutex.Unlock()
+
+	smsc.configureSession = configure
+}
+
+// SetEventLogger sets the EventLogger to which the SMSC logs connections and binds, and which it gives
+// to each session
+func (smsc *SMSC) SetEventLogger(eventLogger *EventLogger) {
+	smsc.mutex.Lock()
+	defer smsc.mutex.Unlock()
+
+	smsc.eventLogger = eventLogger
+}
+
+// SetMetrics sets the Metrics which the SMSC gives to each session
+func (smsc *SMSC) SetMetrics(metrics *Metrics) {
+	smsc.mutex.Lock()
+	defer smsc.mutex.Unlock()
+
+	smsc.metrics = metrics
+}
+
+// ListenAndServe listens on the TCP address 'address' (e.g., "0.0.0.0:2775"), then calls Serve()
+func (smsc *SMSC) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	return smsc.Serve(listener)
+}
+
+// Serve accepts connections on 'listener', and serves each in its own goroutine.  It blocks until the
+// listener fails or Shutdown() is called, and closes the listener before returning.  After Shutdown(),
+// it returns ErrSMSCClosed.
+func (smsc *SMSC) Serve(listener net.Listener) error {
+	smsc.mutex.Lock()
+	if smsc.isShutDown() {
+		smsc.mutex.Unlock()
+		listener.Close()
+		return ErrSMSCClosed
+	}
+	smsc.listeners[listener] = true
+	smsc.mutex.Unlock()
+
+	defer func() {
+		smsc.mutex.Lock()
+		delete(smsc.listeners, listener)
+		smsc.mutex.Unlock()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if smsc.isShutDown() {
+				return ErrSMSCClosed
+			}
+			return err
+		}
+
+		smsc.mutex.Lock()
+		if smsc.isShutDown() {
+			smsc.mutex.Unlock()
+			conn.Close()
+			return ErrSMSCClosed
+		}
+		smsc.connections.Add(1)
+		smsc.mutex.Unlock()
+
+		go smsc.serveConnection(conn)
+	}
+}
+
+// ServeConnection serves a single connection that the caller has already accepted or created (e.g.,
+// one end of net.Pipe()), in the same way as a connection accepted by Serve().  It blocks until the
+// session closes.  After Shutdown(), it closes the connection and returns ErrSMSCClosed.
+func (smsc *SMSC) ServeConnection(conn net.Conn) error {
+	smsc.mutex.Lock()
+	if smsc.isShutDown() {
+		smsc.mutex.Unlock()
+		conn.Close()
+		return ErrSMSCClosed
+	}
+	smsc.connections.Add(1)
+	smsc.mutex.Unlock()
+
+	smsc.serveConnection(conn)
+
+	return nil
+}
+
+// Sessions returns the sessions that are currently bound
+func (smsc *SMSC) Sessions() []*SMSCSession {
+	smsc.mutex.Lock()
+	defer smsc.mutex.Unlock()
+
+	sessions := make([]*SMSCSession, 0, len(smsc.sessions))
+	for _, session := range smsc.sessions {
+		if session != nil {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions
+}
+
+// SendToReceiver sends a request (deliver_sm, data_sm or alert_notification) to the ESME bound with
+// the system_id 'systemID'.  If the ESME has more than one session bound as a receiver or transceiver,
+// the one with the fewest outstanding requests is used.  If it has none, ErrNoReceiver is returned.
+func (smsc *SMSC) SendToReceiver(systemID string, request *PDU) (*ResponseFuture, error) {
+	var receiver *Peer
+
+	for _, session := range smsc.Sessions() {
+		if session.Bind.SystemID != systemID || !receiverStates.contains(session.Peer.State()) {
+			continue
+		}
+
+		if receiver == nil || session.Peer.OutstandingRequests() < receiver.OutstandingRequests() {
+			receiver = session.Peer
+		}
+	}
+
+	if receiver == nil {
+		return nil, ErrNoReceiver
+	}
+
+	return receiver.SendRequest(request)
+}
+
+// Shutdown stops the SMSC.  The listeners are closed, connections that are not yet bound are closed,
+// and each bound session is closed with Peer.Close(ctx).  It waits until every connection is closed, or
+// until 'ctx' is done, in which case it returns ctx.Err().
+func (smsc *SMSC) Shutdown(ctx context.Context) error {
+	smsc.mutex.Lock()
+	if !smsc.isShutDown() {
+		close(smsc.shutdown)
+	}
+	for listener := range smsc.listeners {
+		listener.Close()
+	}
+	peers := make(map[*Peer]*SMSCSession, len(smsc.sessions))
+	for peer, session := range smsc.sessions {
+		peers[peer] = session
+	}
+	smsc.mutex.Unlock()
+
+	for peer, session := range peers {
+		if session == nil {
+			peer.Disconnect()
+		} else {
+			go peer.Close(ctx)
+		}
+	}
+
+	allClosed := make(chan struct{})
+	go func() {
+		smsc.connections.Wait()
+		close(allClosed)
+	}()
+
+	select {
+	case <-allClosed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (smsc *SMSC) isShutDown() bool {
+	select {
+	case <-smsc.shutdown:
+		return true
+	default:
+		return false
+	}
+}
+
+// Outbind connects a transport (TCP) to the ESME at 'remoteAddr' and 'remotePort', and sends an
+// outbind with 'systemID' and 'password'.  It waits for the ESME to answer with bind_receiver (or
+// bind_transceiver), which is passed to the Authenticator like any other bind.  If the bind is accepted,
+// the session is served like a session on a listener, and it is returned.  If the ESME does not bind
+// within the bind timeout, or the bind is rejected, the transport is closed and an error is returned.
+func (smsc *SMSC) Outbind(remoteAddr net.IP, remotePort uint16, systemID string, password string) (*SMSCSession, error) {
+	return smsc.OutbindContext(context.Background(), remoteAddr, remotePort, systemID, password)
+}
+
+// OutbindContext is the same as Outbind(), except that if 'ctx' is done before the ESME binds, the dial
+// is aborted or the transport is closed, and ctx.Err() is returned
+func (smsc *SMSC) OutbindContext(ctx context.Context, remoteAddr net.IP, remotePort uint16, systemID string, password string) (*SMSCSession, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", (&net.TCPAddr{IP: remoteAddr, Port: int(remotePort), Zone: ""}).String())
+	if err != nil {
+		return nil, err
+	}
+
+	smsc.mutex.Lock()
+	if smsc.isShutDown() {
+		smsc.mutex.Unlock()
+		conn.Close()
+		return nil, ErrSMSCClosed
+	}
+	smsc.connections.Add(1)
+	smsc.mutex.Unlock()
+
+	peer, bindTimeout := smsc.startSession(conn)
+	if peer == nil {
+		smsc.connections.Done()
+		return nil, ErrSMSCClosed
+	}
+
+	outbind := NewPDU(CommandOutbind, 0, peer.allocateSequenceNumber(), []*Parameter{
+		NewCOctetStringParameter(systemID),
+		NewCOctetStringParameter(password),
+	}, []*Parameter{})
+
+	if err := peer.SendPDU(outbind); err != nil {
+		smsc.endSession(peer)
+		return nil, err
+	}
+
+	session, err := smsc.awaitBind(ctx, peer, bindTimeout)
+	if session == nil {
+		smsc.endSession(peer)
+		return nil, err
+	}
+
+	go smsc.serveSession(session)
+
+	return session, nil
+}
+
+// serveConnection waits for the bind on a new connection, authenticates it, and then serves the
+// session until it closes
+func (smsc *SMSC) serveConnection(conn net.Conn) {
+	peer, bindTimeout := smsc.startSession(conn)
+	if peer == nil {
+		smsc.connections.Done()
+		return
+	}
+
+	session, _ := smsc.awaitBind(context.Background(), peer, bindTimeout)
+	if session == nil {
+		smsc.endSession(peer)
+		return
+	}
+
+	smsc.serveSession(session)
+}
+
+// startSession creates the session for a new connection, and registers it as not yet bound.  It returns
+// nil if the SMSC is shut down.
+func (smsc *SMSC) startSession(conn net.Conn) (*Peer, time.Duration) {
+	peer := newPeer(conn, roleSMSC)
+
+	smsc.mutex.Lock()
+	if smsc.isShutDown() {
+		smsc.mutex.Unlock()
+		peer.Disconnect()
+		return nil, 0
+	}
+	smsc.sessions[peer] = nil
+	bindTimeout := smsc.bindTimeout
+	configure := smsc.configureSession
+	eventLogger := smsc.eventLogger
+	metrics := smsc.metrics
+	smsc.mutex.Unlock()
+
+	peer.SetEventLogger(eventLogger)
+	peer.SetMetrics(metrics)
+	peer.log(LogEventConnect, "Transport connected", LogField{"local_addr", conn.LocalAddr().String()})
+
+	if configure != nil {
+		configure(peer)
+	}
+
+	return peer, bindTimeout
+}
+
+// serveSession passes the requests on a bound session to the handler until the session closes
+func (smsc *SMSC) serveSession(session *SMSCSession) {
+	for request := range session.Peer.IncomingPDUs() {
+		smsc.dispatch(session, request)
+	}
+
+	smsc.endSession(session.Peer)
+}
+
+// endSession closes the transport of a session, and forgets it
+func (smsc *SMSC) endSession(peer *Peer) {
+	peer.Disconnect()
+
+	smsc.mutex.Lock()
+	delete(smsc.sessions, peer)
+	smsc.mutex.Unlock()
+
+	smsc.connections.Done()
+}
+
+// awaitBind waits for the bind on a new session, and answers it.  It returns the bound session, or an
+// error if the bind is rejected, or does not arrive in time or before 'ctx' is done.
+func (smsc *SMSC) awaitBind(ctx context.Context, peer *Peer, timeout time.Duration) (*SMSCSession, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var bind *PDU
+	select {
+	case bind = <-peer.IncomingPDUs():
+		if bind == nil {
+			return nil, fmt.Errorf("Session closed before bind: %v", peer.Err())
+		}
+	case <-timer.C:
+		return nil, fmt.Errorf("No bind received within (%s)", timeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// only a bind is permitted from an ESME in the OPEN state, so the session has answered anything else
+	session := &SMSCSession{Peer: peer, Bind: BindRequest{BindInfo: bindInfoFromPDU(bind), RemoteAddr: peer.connectionToRemotePeer.RemoteAddr(), TLS: peer.TLSConnectionState()}}
+
+	status := EsmeROK
+	if smsc.authenticator != nil {
+		status = smsc.authenticator.Authenticate(&session.Bind)
+	}
+
+	peer.log(LogEventBind, "Bind received", LogField{"system_id", session.Bind.SystemID}, LogField{"command", bind.CommandName()}, LogField{"status", CommandStatusName(status)})
+
+	if status != EsmeROK {
+		peer.SendPDU(NewPDU(bind.CommandID|0x80000000, status, bind.SequenceNumber, []*Parameter{}, []*Parameter{}))
+		return nil, fmt.Errorf("Bind from (%s) rejected with %s", session.Bind.SystemID, CommandStatusName(status))
+	}
+
+	err := peer.SendPDU(NewPDU(bind.CommandID|0x80000000, EsmeROK, bind.SequenceNumber, []*Parameter{
+		NewCOctetStringParameter(smsc.systemID),
+	}, []*Parameter{
+		NewTLVParameter(parameterTypeDefinition["SC_interface_version"].TagID, uint8(0x34)),
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	smsc.mutex.Lock()
+	smsc.sessions[peer] = session
+	smsc.mutex.Unlock()
+
+	return session, nil
+}
+
+// dispatch passes a request to the handler and sends the response
+func (smsc *SMSC) dispatch(session *SMSCSession, request *PDU) {
+	var response *PDU
+
+	switch request.CommandID {
+	case CommandSubmitSm:
+		response = responsePDU(request, smsc.handler.OnSubmitSm(session, request))
+	case CommandDataSm:
+		response = responsePDU(request, smsc.handler.OnDataSm(session, request))
+	case CommandQuerySm:
+		response = querySmResponsePDU(request, smsc.handler.OnQuerySm(session, request))
+	case CommandCancelSm:
+		response = responsePDU(request, smsc.handler.OnCancelSm(session, request))
+	case CommandReplaceSm:
+		response = responsePDU(request, smsc.handler.OnReplaceSm(session, request))
+	case CommandSubmitMulti:
+		response = submitMultiResponsePDU(request, smsc.handler.OnSubmitMulti(session, request))
+	default:
+		response = NewPDU(CommandGenericNack, EsmeRInvCmdID, request.SequenceNumber, []*Parameter{}, []*Parameter{})
+	}
+
+	session.Peer.SendPDU(response)
+}
@@ -0,0 +1,168 @@
+package smpp
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// BindRequest describes a bind received by an SMSC, for its Authenticator.  RemoteAddr is the address
+// of the ESME's end of the transport.  TLS is the negotiated TLS state if the ESME connected over TLS
+// (e.g., TLS.PeerCertificates holds the ESME's client certificate under mutual TLS, and TLS.ServerName
+// is the name it sent with SNI), and nil otherwise.
+type BindRequest struct {
+	BindInfo
+	RemoteAddr net.Addr
+	TLS        *tls.ConnectionState
+}
+
+// Authenticator decides whether an SMSC accepts a bind.  Authenticate returns the command_status of
+// the bind response: ESME_ROK accepts the bind, and any other value (e.g., ESME_RINVPASWD) rejects it.
+type Authenticator interface {
+	Authenticate(request *BindRequest) uint32
+}
+
+// AuthenticatorFunc is a function that implements Authenticator
+type AuthenticatorFunc func(request *BindRequest) uint32
+
+// Authenticate calls the function
+func (authenticate AuthenticatorFunc) Authenticate(request *BindRequest) uint32 {
+	return authenticate(request)
+}
+
+// PasswordAuthenticator is an Authenticator that accepts a bind if its system_id is a key of the map
+// and its password is the value.  An unknown system_id is rejected with ESME_RINVSYSID, and a wrong
+// password with ESME_RINVPASWD.
+type PasswordAuthenticator map[string]string
+
+// Authenticate checks the system_id and password of the bind
+func (credentials PasswordAuthenticator) Authenticate(request *BindRequest) uint32 {
+	password, isKnown := credentials[request.SystemID]
+
+	switch {
+	case !isKnown:
+		return EsmeRInvSysID
+	case password != request.Password:
+		return EsmeRInvPaswd
+	default:
+		return EsmeROK
+	}
+}
+
+// SMSCSession is a session bound to an SMSC.  Bind is the accepted bind.
+type SMSCSession struct {
+	Peer *Peer
+	Bind BindRequest
+}
+
+// QuerySmResponse describes the query_sm_resp that an SMSC sends for a query_sm.  A nil
+// *QuerySmResponse is answered with ESME_RQUERYFAIL.
+type QuerySmResponse struct {
+	CommandStatus uint32
+	MessageID     string
+	FinalDate     string
+	MessageState  uint8
+	ErrorCode     uint8
+}
+
+// SubmitMultiResponse describes the submit_multi_resp that an SMSC sends for a submit_multi.
+// UnsuccessfulSMEs lists the destinations to which the message could not be submitted.  Its count is
+// encoded in one octet (no_unsuccess), so a response with more than 255 of them is sent as ESME_RSYSERR.
+// A nil *SubmitMultiResponse is ESME_ROK with an empty message_id and no unsuccessful destinations.
+type SubmitMultiResponse struct {
+	CommandStatus    uint32
+	MessageID        string
+	UnsuccessfulSMEs []UnsuccessfulSME
+}
+
+// SMSCHandler receives the requests that ESMEs send on the sessions bound to an SMSC.  Each session
+// is served by its own goroutine, so an implementation must be safe for concurrent use.  The SMSC
+// sends the response built from what each method returns.
+type SMSCHandler interface {
+	// OnSubmitSm is called for each submit_sm.  A nil *Response is ESME_ROK with an empty message_id.
+	OnSubmitSm(session *SMSCSession, pdu *PDU) *Response
+	// OnDataSm is called for each data_sm.  A nil *Response is ESME_ROK with an empty message_id.
+	OnDataSm(session *SMSCSession, pdu *PDU) *Response
+	// OnQuerySm is called for each query_sm
+	OnQuerySm(session *SMSCSession, pdu *PDU) *QuerySmResponse
+	// OnCancelSm is called for each cancel_sm.  A nil *Response is ESME_ROK.
+	OnCancelSm(session *SMSCSession, pdu *PDU) *Response
+	// OnReplaceSm is called for each replace_sm.  A nil *Response is ESME_ROK.
+	OnReplaceSm(session *SMSCSession, pdu *PDU) *Response
+	// OnSubmitMulti is called for each submit_multi.  A nil *SubmitMultiResponse is ESME_ROK with an empty
+	// message_id.
+	OnSubmitMulti(session *SMSCSession, pdu *PDU) *SubmitMultiResponse
+}
+
+// BaseSMSCHandler implements SMSCHandler by accepting every submit_sm, data_sm and submit_multi, and
+// failing every query_sm, cancel_sm and replace_sm, because it keeps no record of messages.  It may be
+// embedded in a struct that overrides only some methods.
+type BaseSMSCHandler struct{}
+
+// OnSubmitSm accepts the submit_sm
+func (BaseSMSCHandler) OnSubmitSm(session *SMSCSession, pdu *PDU) *Response { return nil }
+
+// OnDataSm accepts the data_sm
+func (BaseSMSCHandler) OnDataSm(session *SMSCSession, pdu *PDU) *Response { return nil }
+
+// OnQuerySm fails the query_sm with ESME_RQUERYFAIL
+func (BaseSMSCHandler) OnQuerySm(session *SMSCSession, pdu *PDU) *QuerySmResponse { return nil }
+
+// OnCancelSm fails the cancel_sm with ESME_RCANCELFAIL
+func (BaseSMSCHandler) OnCancelSm(session *SMSCSession, pdu *PDU) *Response {
+	return &Response{CommandStatus: EsmeRCancelFail}
+}
+
+// OnReplaceSm fails the replace_sm with ESME_RREPLACEFAIL
+func (BaseSMSCHandler) OnReplaceSm(session *SMSCSession, pdu *PDU) *Response {
+	return &Response{CommandStatus: EsmeRReplaceFail}
+}
+
+// OnSubmitMulti accepts the submit_multi
+func (BaseSMSCHandler) OnSubmitMulti(session *SMSCSession, pdu *PDU) *SubmitMultiResponse {
+	return nil
+}
+
+// querySmResponsePDU builds the query_sm_resp to 'request' described by 'response'
+func querySmResponsePDU(request *PDU, response *QuerySmResponse) *PDU {
+	if response == nil {
+		response = &QuerySmResponse{CommandStatus: EsmeRQueryFail}
+	}
+
+	if response.CommandStatus != EsmeROK {
+		return NewPDU(CommandQuerySmResp, response.CommandStatus, request.SequenceNumber, []*Parameter{}, []*Parameter{})
+	}
+
+	return NewPDU(CommandQuerySmResp, EsmeROK, request.SequenceNumber, []*Parameter{
+		NewCOctetStringParameter(response.MessageID),
+		NewCOctetStringParameter(response.FinalDate),
+		NewFLParameter(response.MessageState),
+		NewFLParameter(response.ErrorCode),
+	}, []*Parameter{})
+}
+
+// maximumUnsuccessfulSMEs is the most entries that the one octet no_unsuccess can count
+const maximumUnsuccessfulSMEs = 255
+
+// submitMultiResponsePDU builds the submit_multi_resp to 'request' described by 'response'.  A response
+// with more unsuccessful destinations than no_unsuccess can count is answered with ESME_RSYSERR rather
+// than with a count that disagrees with the list.
+func submitMultiResponsePDU(request *PDU, response *SubmitMultiResponse) *PDU {
+	if response == nil {
+		response = &SubmitMultiResponse{}
+	}
+
+	status := response.CommandStatus
+	if status == EsmeROK && len(response.UnsuccessfulSMEs) > maximumUnsuccessfulSMEs {
+		status = EsmeRSysErr
+	}
+
+	if status != EsmeROK {
+		return NewPDU(CommandSubmitMultiResp, status, request.SequenceNumber, []*Parameter{}, []*Parameter{})
+	}
+
+	return NewPDU(CommandSubmitMultiResp, EsmeROK, request.SequenceNumber, []*Parameter{
+		NewCOctetStringParameter(response.MessageID),
+		NewFLParameter(uint8(len(response.UnsuccessfulSMEs))),
+		NewUnsuccessfulSMEListParameter(response.UnsuccessfulSMEs),
+	}, []*Parameter{})
+}
@@ -0,0 +1,306 @@
+package smpp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// startSMSC serves 'smsc' on a loopback listener, and returns the listener address and a channel that
+// receives the error from Serve()
+func startSMSC(t *testing.T, smsc *SMSC) (*net.TCPAddr, chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on loopback: %s", err)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- smsc.Serve(listener) }()
+
+	return listener.Addr().(*net.TCPAddr), served
+}
+
+func connectAndBindOrFail(t *testing.T, esme *ESME, addr *net.TCPAddr, bind BindInfo) *Peer {
+	peer, err := esme.ConnectToPeer(addr.IP, uint16(addr.Port))
+	if err != nil {
+		t.Fatalf("Expected no error on ConnectToPeer(), but got error = (%s)", err)
+	}
+
+	if _, _, err := esme.BindToPeer(peer, bind); err != nil {
+		t.Fatalf("Expected no error on BindToPeer(%s), but got error = (%s)", bind.SystemID, err)
+	}
+
+	return peer
+}
+
+type submitRecordingSMSCHandler struct {
+	BaseSMSCHandler
+	submitters chan string
+}
+
+func (handler *submitRecordingSMSCHandler) OnSubmitSm(session *SMSCSession, pdu *PDU) *Response {
+	handler.submitters <- session.Bind.SystemID
+	return &Response{MessageID: "msg-" + session.Bind.SystemID}
+}
+
+func TestSMSCAuthenticatesBindsOnMultipleListeners(t *testing.T) {
+	handler := &submitRecordingSMSCHandler{submitters: make(chan string, 10)}
+	smsc := NewSMSC("smsc01", PasswordAuthenticator{"esme01": "secret1", "esme02": "secret2"}, handler)
+
+	firstAddr, _ := startSMSC(t, smsc)
+	secondAddr, _ := startSMSC(t, smsc)
+	defer smsc.Shutdown(context.Background())
+
+	esme := &ESME{}
+	transmitter := connectAndBindOrFail(t, esme, firstAddr, BindInfo{Type: TransmitterBind, SystemID: "esme01", Password: "secret1"})
+	transceiver := connectAndBindOrFail(t, esme, secondAddr, BindInfo{Type: TransceiverBind, SystemID: "esme02", Password: "secret2"})
+
+	for _, rejected := range []struct {
+		bind   BindInfo
+		status uint32
+	}{
+		{BindInfo{Type: TransmitterBind, SystemID: "esme01", Password: "wrong"}, EsmeRInvPaswd},
+		{BindInfo{Type: ReceiverBind, SystemID: "esme03", Password: "secret1"}, EsmeRInvSysID},
+	} {
+		peer, err := esme.ConnectToPeer(secondAddr.IP, uint16(secondAddr.Port))
+		if err != nil {
+			t.Fatalf("Expected no error on ConnectToPeer(), but got error = (%s)", err)
+		}
+
+		_, _, err = esme.BindToPeer(peer, rejected.bind)
+
+		var statusError *CommandStatusError
+		if !errors.As(err, &statusError) || statusError.CommandStatus != rejected.status {
+			t.Errorf("Expected bind for (%s) to be rejected with (%s), got (%v)", rejected.bind.SystemID, CommandStatusName(rejected.status), err)
+		}
+		peer.Disconnect()
+	}
+
+	if sessions := smsc.Sessions(); len(sessions) != 2 {
+		t.Errorf("Expected (2) bound sessions, got (%d)", len(sessions))
+	}
+
+	for _, testCase := range []struct {
+		peer     *Peer
+		systemID string
+	}{
+		{transmitter, "esme01"},
+		{transceiver, "esme02"},
+	} {
+		future, err := testCase.peer.SendRequest(newShortMessagePDU(CommandSubmitSm, 0, "hello"))
+		if err != nil {
+			t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+		}
+
+		response, err := future.Wait()
+		if err != nil || response.CommandID != CommandSubmitSmResp || response.CommandStatus != EsmeROK {
+			t.Fatalf("Expected submit-sm-resp with ESME_ROK, got (%v), error = (%v)", response, err)
+		}
+
+		if messageID := response.MandatoryParameters[0].Value; messageID != "msg-"+testCase.systemID {
+			t.Errorf("Expected message_id (msg-%s), got (%v)", testCase.systemID, messageID)
+		}
+
+		if submitter := <-handler.submitters; submitter != testCase.systemID {
+			t.Errorf("Expected handler to see submit_sm from (%s), got (%s)", testCase.systemID, submitter)
+		}
+	}
+}
+
+func TestSMSCSendsToBoundReceiverAndShutsDown(t *testing.T) {
+	smsc := NewSMSC("smsc01", nil, BaseSMSCHandler{})
+	smscAddr, served := startSMSC(t, smsc)
+
+	esme := &ESME{}
+	handler := &recordingESMEHandler{received: make(chan *PDU, 10)}
+	go esme.StartListenLoop(handler)
+	defer esme.StopListenLoop()
+
+	receiver := connectAndBindOrFail(t, esme, smscAddr, BindInfo{Type: ReceiverBind, SystemID: "esme01"})
+	connectAndBindOrFail(t, esme, smscAddr, BindInfo{Type: TransmitterBind, SystemID: "esme01"})
+
+	if _, err := smsc.SendToReceiver("esme02", newShortMessagePDU(CommandDeliverSm, 0, "hello")); err != ErrNoReceiver {
+		t.Errorf("Expected ErrNoReceiver for unbound system_id, got (%v)", err)
+	}
+
+	future, err := smsc.SendToReceiver("esme01", newShortMessagePDU(CommandDeliverSm, 0, "hello"))
+	if err != nil {
+		t.Fatalf("Expected no error on SendToReceiver(), but got error = (%s)", err)
+	}
+
+	response, err := future.Wait()
+	if err != nil || response.CommandID != CommandDeliverSmResp || response.CommandStatus != EsmeROK {
+		t.Fatalf("Expected deliver-sm-resp with ESME_ROK, got (%v), error = (%v)", response, err)
+	}
+
+	if pdu := <-handler.received; pdu.CommandID != CommandDeliverSm {
+		t.Errorf("Expected ESME handler to receive deliver_sm, got %s", pdu)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := smsc.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected no error on Shutdown(), but got error = (%s)", err)
+	}
+
+	if err := <-served; err != ErrSMSCClosed {
+		t.Errorf("Expected ErrSMSCClosed from Serve(), got (%v)", err)
+	}
+
+	<-receiver.Done()
+	if receiver.Err() != ErrPeerUnbound {
+		t.Errorf("Expected receiver session to close with ErrPeerUnbound, got (%v)", receiver.Err())
+	}
+}
+
+func TestSMSCOutbindLeadsToReceiverBind(t *testing.T) {
+	smsc := NewSMSC("smsc01", PasswordAuthenticator{"esme01": "secret"}, BaseSMSCHandler{})
+	defer smsc.Shutdown(context.Background())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on loopback: %s", err)
+	}
+	esmeAddr := listener.Addr().(*net.TCPAddr)
+
+	esme := &ESME{}
+	handler := &recordingESMEHandler{received: make(chan *PDU, 10)}
+	go esme.StartListenLoop(handler)
+	defer esme.StopListenLoop()
+
+	outbinds := make(chan *OutbindRequest, 2)
+	go esme.ServeOutbind(listener, func(request *OutbindRequest) (BindInfo, bool) {
+		outbinds <- request
+		return BindInfo{Type: ReceiverBind, SystemID: "esme01", Password: "secret"}, request.Password == "outbind-secret"
+	})
+	defer listener.Close()
+
+	if _, err := smsc.Outbind(esmeAddr.IP, uint16(esmeAddr.Port), "smsc01", "wrong"); err == nil {
+		t.Errorf("Expected error on Outbind() with rejected credentials, but got none")
+	}
+
+	session, err := smsc.Outbind(esmeAddr.IP, uint16(esmeAddr.Port), "smsc01", "outbind-secret")
+	if err != nil {
+		t.Fatalf("Expected no error on Outbind(), but got error = (%s)", err)
+	}
+
+	<-outbinds
+	if request := <-outbinds; request.SystemID != "smsc01" || request.Password != "outbind-secret" {
+		t.Errorf("Expected outbind with system_id (smsc01) and password (outbind-secret), got (%s) and (%s)", request.SystemID, request.Password)
+	}
+
+	if session.Bind.Type != ReceiverBind || session.Bind.SystemID != "esme01" || session.Peer.State() != StateBoundRx {
+		t.Errorf("Expected session bound as receiver by esme01, got bind (%v) in state (%s)", session.Bind, session.Peer.State())
+	}
+
+	future, err := smsc.SendToReceiver("esme01", newShortMessagePDU(CommandDeliverSm, 0, "hello"))
+	if err != nil {
+		t.Fatalf("Expected no error on SendToReceiver(), but got error = (%s)", err)
+	}
+
+	if response, err := future.Wait(); err != nil || response.CommandID != CommandDeliverSmResp {
+		t.Fatalf("Expected deliver-sm-resp, got (%v), error = (%v)", response, err)
+	}
+
+	if pdu := <-handler.received; pdu.CommandID != CommandDeliverSm {
+		t.Errorf("Expected ESME handler to receive deliver_sm, got %s", pdu)
+	}
+}
+
+// submitMultiSMSCHandler rejects every destination of a submit_multi except the first
+type submitMultiSMSCHandler struct {
+	BaseSMSCHandler
+}
+
+func (submitMultiSMSCHandler) OnSubmitMulti(session *SMSCSession, pdu *PDU) *SubmitMultiResponse {
+	response := &SubmitMultiResponse{MessageID: "multi-01"}
+	for _, destination := range pdu.MandatoryParameters[5].Value.([]DestinationAddress)[1:] {
+		response.UnsuccessfulSMEs = append(response.UnsuccessfulSMEs, UnsuccessfulSME{AddrTON: destination.AddrTON, AddrNPI: destination.AddrNPI, Address: destination.Address, ErrorStatusCode: EsmeRInvDstAdr})
+	}
+
+	return response
+}
+
+func TestSMSCAnswersSubmitMulti(t *testing.T) {
+	smsc := NewSMSC("smsc01", nil, submitMultiSMSCHandler{})
+	defer smsc.Shutdown(context.Background())
+	smscAddr, _ := startSMSC(t, smsc)
+
+	peer := connectAndBindOrFail(t, &ESME{}, smscAddr, BindInfo{Type: TransmitterBind, SystemID: "esme01"})
+
+	destinations := []DestinationAddress{{AddrTON: 1, AddrNPI: 1, Address: "15555550101"}, {AddrTON: 1, AddrNPI: 1, Address: "15555550102"}}
+	future, err := peer.SendRequest(NewPDU(CommandSubmitMulti, 0, 0, []*Parameter{
+		NewCOctetStringParameter(""),
+		NewFLParameter(uint8(1)),
+		NewFLParameter(uint8(1)),
+		NewCOctetStringParameter("15555550100"),
+		NewFLParameter(uint8(len(destinations))),
+		NewDestinationAddressListParameter(destinations),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewCOctetStringParameter(""),
+		NewCOctetStringParameter(""),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(5)),
+		NewOctetStringFromString("hello"),
+	}, []*Parameter{}))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	response, err := future.Wait()
+	if err != nil || response.CommandID != CommandSubmitMultiResp || len(response.MandatoryParameters) != 3 {
+		t.Fatalf("Expected submit-multi-resp with three mandatory parameters, got (%v), error = (%v)", response, err)
+	}
+
+	expected := []UnsuccessfulSME{{AddrTON: 1, AddrNPI: 1, Address: "15555550102", ErrorStatusCode: EsmeRInvDstAdr}}
+	if messageID, count := response.MandatoryParameters[0].Value, response.MandatoryParameters[1].Value; messageID != "multi-01" || count != uint8(1) {
+		t.Errorf("Expected message_id (multi-01) and no_unsuccess (1), got (%v) and (%v)", messageID, count)
+	}
+	if list := response.MandatoryParameters[2].Value; !reflect.DeepEqual(list, expected) {
+		t.Errorf("Expected unsuccess_sme (%v), got (%v)", expected, list)
+	}
+}
+
+func TestSubmitMultiResponseWithTooManyUnsuccessfulSMEs(t *testing.T) {
+	request := NewPDU(CommandSubmitMulti, 0, 9, []*Parameter{}, []*Parameter{})
+	unsuccessful := make([]UnsuccessfulSME, maximumUnsuccessfulSMEs+1)
+
+	response := submitMultiResponsePDU(request, &SubmitMultiResponse{MessageID: "multi-01", UnsuccessfulSMEs: unsuccessful})
+	if response.CommandStatus != EsmeRSysErr || len(response.MandatoryParameters) != 0 || response.SequenceNumber != 9 {
+		t.Errorf("Expected submit-multi-resp with ESME_RSYSERR and no body for (%d) unsuccessful destinations, got %s", len(unsuccessful), response)
+	}
+
+	response = submitMultiResponsePDU(request, &SubmitMultiResponse{MessageID: "multi-01", UnsuccessfulSMEs: unsuccessful[:maximumUnsuccessfulSMEs]})
+	if response.CommandStatus != EsmeROK || response.MandatoryParameters[1].Value != uint8(maximumUnsuccessfulSMEs) {
+		t.Errorf("Expected submit-multi-resp with ESME_ROK and no_unsuccess (%d), got %s", maximumUnsuccessfulSMEs, response)
+	}
+}
+
+func TestSMSCWithNilHandlerAnswersRequests(t *testing.T) {
+	smsc := NewSMSC("smsc01", nil, nil)
+	defer smsc.Shutdown(context.Background())
+	smscAddr, _ := startSMSC(t, smsc)
+
+	peer := connectAndBindOrFail(t, &ESME{}, smscAddr, BindInfo{Type: TransmitterBind, SystemID: "esme01"})
+
+	future, err := peer.SendRequest(newShortMessagePDU(CommandSubmitSm, 0, "hello"))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	if response, err := future.Wait(); err != nil || response.CommandID != CommandSubmitSmResp || response.CommandStatus != EsmeROK {
+		t.Errorf("Expected submit-sm-resp with ESME_ROK, got (%v), error = (%v)", response, err)
+	}
+	if peer.State() != StateBoundTx {
+		t.Errorf("Expected the session to remain BOUND_TX, got (%s)", peer.State())
+	}
+}
@@ -0,0 +1,116 @@
+package smpp
+
+import "fmt"
+
+// These are the command_status values defined by SMPP v3.4
+const (
+	EsmeROK              uint32 = 0x00000000
+	EsmeRInvMsgLen       uint32 = 0x00000001
+	EsmeRInvCmdLen       uint32 = 0x00000002
+	EsmeRInvCmdID        uint32 = 0x00000003
+	EsmeRInvBndSts       uint32 = 0x00000004
+	EsmeRAlyBnd          uint32 = 0x00000005
+	EsmeRInvPrtFlg       uint32 = 0x00000006
+	EsmeRInvRegDlvFlg    uint32 = 0x00000007
+	EsmeRSysErr          uint32 = 0x00000008
+	EsmeRInvSrcAdr       uint32 = 0x0000000A
+	EsmeRInvDstAdr       uint32 = 0x0000000B
+	EsmeRInvMsgID        uint32 = 0x0000000C
+	EsmeRBindFail        uint32 = 0x0000000D
+	EsmeRInvPaswd        uint32 = 0x0000000E
+	EsmeRInvSysID        uint32 = 0x0000000F
+	EsmeRCancelFail      uint32 = 0x00000011
+	EsmeRReplaceFail     uint32 = 0x00000013
+	EsmeRMsgQFul         uint32 = 0x00000014
+	EsmeRInvSerTyp       uint32 = 0x00000015
+	EsmeRInvNumDests     uint32 = 0x00000033
+	EsmeRInvDLName       uint32 = 0x00000034
+	EsmeRInvDestFlag     uint32 = 0x00000040
+	EsmeRInvSubRep       uint32 = 0x00000042
+	EsmeRInvEsmClass     uint32 = 0x00000043
+	EsmeRCntSubDL        uint32 = 0x00000044
+	EsmeRSubmitFail      uint32 = 0x00000045
+	EsmeRInvSrcTon       uint32 = 0x00000048
+	EsmeRInvSrcNpi       uint32 = 0x00000049
+	EsmeRInvDstTon       uint32 = 0x00000050
+	EsmeRInvDstNpi       uint32 = 0x00000051
+	EsmeRInvSysTyp       uint32 = 0x00000053
+	EsmeRInvRepFlag      uint32 = 0x00000054
+	EsmeRInvNumMsgs      uint32 = 0x00000055
+	EsmeRThrottled       uint32 = 0x00000058
+	EsmeRInvSched        uint32 = 0x00000061
+	EsmeRInvExpiry       uint32 = 0x00000062
+	EsmeRInvDftMsgID     uint32 = 0x00000063
+	EsmeRxTAppn          uint32 = 0x00000064
+	EsmeRxPAppn          uint32 = 0x00000065
+	EsmeRxRAppn          uint32 = 0x00000066
+	EsmeRQueryFail       uint32 = 0x00000067
+	EsmeRInvOptParStream uint32 = 0x000000C0
+	EsmeROptParNotAllwd  uint32 = 0x000000C1
+	EsmeRInvParLen       uint32 = 0x000000C2
+	EsmeRMissingOptParam uint32 = 0x000000C3
+	EsmeRInvOptParamVal  uint32 = 0x000000C4
+	EsmeRDeliveryFailure uint32 = 0x000000FE
+	EsmeRUnknownErr      uint32 = 0x000000FF
+)
+
+var commandStatusName = map[uint32]string{
+	EsmeROK:              "ESME_ROK",
+	EsmeRInvMsgLen:       "ESME_RINVMSGLEN",
+	EsmeRInvCmdLen:       "ESME_RINVCMDLEN",
+	EsmeRInvCmdID:        "ESME_RINVCMDID",
+	EsmeRInvBndSts:       "ESME_RINVBNDSTS",
+	EsmeRAlyBnd:          "ESME_RALYBND",
+	EsmeRInvPrtFlg:       "ESME_RINVPRTFLG",
+	EsmeRInvRegDlvFlg:    "ESME_RINVREGDLVFLG",
+	EsmeRSysErr:          "ESME_RSYSERR",
+	EsmeRInvSrcAdr:       "ESME_RINVSRCADR",
+	EsmeRInvDstAdr:       "ESME_RINVDSTADR",
+	EsmeRInvMsgID:        "ESME_RINVMSGID",
+	EsmeRBindFail:        "ESME_RBINDFAIL",
+	EsmeRInvPaswd:        "ESME_RINVPASWD",
+	EsmeRInvSysID:        "ESME_RINVSYSID",
+	EsmeRCancelFail:      "ESME_RCANCELFAIL",
+	EsmeRReplaceFail:     "ESME_RREPLACEFAIL",
+	EsmeRMsgQFul:         "ESME_RMSGQFUL",
+	EsmeRInvSerTyp:       "ESME_RINVSERTYP",
+	EsmeRInvNumDests:     "ESME_RINVNUMDESTS",
+	EsmeRInvDLName:       "ESME_RINVDLNAME",
+	EsmeRInvDestFlag:     "ESME_RINVDESTFLAG",
+	EsmeRInvSubRep:       "ESME_RINVSUBREP",
+	EsmeRInvEsmClass:     "ESME_RINVESMCLASS",
+	EsmeRCntSubDL:        "ESME_RCNTSUBDL",
+	EsmeRSubmitFail:      "ESME_RSUBMITFAIL",
+	EsmeRInvSrcTon:       "ESME_RINVSRCTON",
+	EsmeRInvSrcNpi:       "ESME_RINVSRCNPI",
+	EsmeRInvDstTon:       "ESME_RINVDSTTON",
+	EsmeRInvDstNpi:       "ESME_RINVDSTNPI",
+	EsmeRInvSysTyp:       "ESME_RINVSYSTYP",
+	EsmeRInvRepFlag:      "ESME_RINVREPFLAG",
+	EsmeRInvNumMsgs:      "ESME_RINVNUMMSGS",
+	EsmeRThrottled:       "ESME_RTHROTTLED",
+	EsmeRInvSched:        "ESME_RINVSCHED",
+	EsmeRInvExpiry:       "ESME_RINVEXPIRY",
+	EsmeRInvDftMsgID:     "ESME_RINVDFTMSGID",
+	EsmeRxTAppn:          "ESME_RX_T_APPN",
+	EsmeRxPAppn:          "ESME_RX_P_APPN",
+	EsmeRxRAppn:          "ESME_RX_R_APPN",
+	EsmeRQueryFail:       "ESME_RQUERYFAIL",
+	EsmeRInvOptParStream: "ESME_RINVOPTPARSTREAM",
+	EsmeROptParNotAllwd:  "ESME_ROPTPARNOTALLWD",
+	EsmeRInvParLen:       "ESME_RINVPARLEN",
+	EsmeRMissingOptParam: "ESME_RMISSINGOPTPARAM",
+	EsmeRInvOptParamVal:  "ESME_RINVOPTPARAMVAL",
+	EsmeRDeliveryFailure: "ESME_RDELIVERYFAILURE",
+	EsmeRUnknownErr:      "ESME_RUNKNOWNERR",
+}
+
+// CommandStatusName returns the name used by the SMPP specification for a command_status
+// value (e.g., "ESME_RINVBNDSTS").  If the value is not defined, the hex value is returned
+func CommandStatusName(status uint32) string {
+	if name, ok := commandStatusName[status]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("0x%08x", status)
+}
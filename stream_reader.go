@@ -1,70 +1,219 @@
 package smpp
 
 import (
+	"context"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"net"
+	"sync"
+	"time"
 )
 
+// DefaultReadBufferSize is the size of the buffer into which a NetworkStreamReader initially reads.
+// The buffer grows if a PDU is larger than this
+const DefaultReadBufferSize = 4096
+
+// DefaultMaxPDULength is the largest command_length that a NetworkStreamReader accepts, unless it is
+// changed with SetMaxPDULength().  A message_payload TLV may carry up to 65535 octets, so the default
+// leaves room above that for the header, the mandatory parameters and the other TLVs of a legitimate
+// PDU, while still refusing a command_length that would have the reader allocate a very large buffer.
+const DefaultMaxPDULength = 70000
+
 // NetworkStreamReader provides a mechanism for reading PDUs from an incoming TCP stream connection, breaking
-// the stream into PDUs
+// the stream into PDUs.  Data are read directly into a single buffer.  Unprocessed data occupy the range
+// between two offsets in that buffer, so extracting a PDU only advances an offset.  The unprocessed
+// data are moved to the front of the buffer only when the buffer's tail is full, which means that each
+// octet is moved at most once.
 type NetworkStreamReader struct {
 	connectionFromWhichToRead  net.Conn
-	readBuffer                 []byte
-	pduBuffer                  []byte
+	buffer                     []byte
+	bufferStart                int
+	bufferEnd                  int
+	bufferPool                 *BufferPool
 	attachedConnectionIsClosed bool
+	lossless                   bool
+
+	settingsMutex sync.Mutex
+	tap           PDUTap
+	maxPDULength  int
 }
 
-// NewNetworkStreamReader creates a NetworkStreamReader that operates on the identified connection
+// NewNetworkStreamReader creates a NetworkStreamReader that operates on the identified connection.  Its
+// buffer is allocated on the first Read()
 func NewNetworkStreamReader(fromConnection net.Conn) *NetworkStreamReader {
-	return &NetworkStreamReader{connectionFromWhichToRead: fromConnection, readBuffer: make([]byte, 65536), pduBuffer: make([]byte, 0, 65536), attachedConnectionIsClosed: false}
+	return &NetworkStreamReader{connectionFromWhichToRead: fromConnection, attachedConnectionIsClosed: false}
+}
+
+// NewNetworkStreamReaderWithBufferPool creates a NetworkStreamReader that takes its buffer from 'pool'.
+// The buffer is returned to the pool each time Read() leaves no unprocessed data, so a reader on an idle
+// connection holds only the smallest buffer size class, and only while a Read() is blocked.  A pool may be
+// shared by any number of readers
+func NewNetworkStreamReaderWithBufferPool(fromConnection net.Conn, pool *BufferPool) *NetworkStreamReader {
+	return &NetworkStreamReader{connectionFromWhichToRead: fromConnection, bufferPool: pool, attachedConnectionIsClosed: false}
+}
+
+// SetTap attaches a PDUTap, which receives each PDU extracted from the stream before it
+// is decoded.  Set to nil to detach the current tap.  It may be called while another goroutine
+// is in Read()
+func (reader *NetworkStreamReader) SetTap(tap PDUTap) {
+	reader.settingsMutex.Lock()
+	defer reader.settingsMutex.Unlock()
+
+	reader.tap = tap
+}
+
+// SetLosslessDecoding determines whether extracted PDUs are decoded with DecodePDULossless (true)
+// or DecodePDU (false, the default)
+func (reader *NetworkStreamReader) SetLosslessDecoding(lossless bool) {
+	reader.lossless = lossless
+}
+
+// SetMaxPDULength sets the largest command_length that is accepted.  A PDU header with a larger
+// command_length is reported by Read() as a DecodeError before any buffer is allocated for it.  A length
+// of zero restores DefaultMaxPDULength.  It may be called while another goroutine is in Read().
+func (reader *NetworkStreamReader) SetMaxPDULength(length int) {
+	reader.settingsMutex.Lock()
+	defer reader.settingsMutex.Unlock()
+
+	reader.maxPDULength = length
+}
+
+// settings returns the tap and the maximum PDU length
+func (reader *NetworkStreamReader) settings() (PDUTap, int) {
+	reader.settingsMutex.Lock()
+	defer reader.settingsMutex.Unlock()
+
+	if reader.maxPDULength <= 0 {
+		return reader.tap, DefaultMaxPDULength
+	}
+
+	return reader.tap, reader.maxPDULength
 }
 
 // Read performs a read of the associated TCP stream and attempts to extract one or more PDUs from the
 // stream.  If there are data left over after extracting zero or more PDUs, those data are saved, and
-// subsequent Read values are appended to those data
+// subsequent Read values are appended to those data.  If a PDU header has a command_length larger than
+// the maximum PDU length, the stream cannot be followed any further: the buffered data are discarded and
+// a DecodeError is returned.
 func (reader *NetworkStreamReader) Read() ([]*PDU, error) {
-	bytesRead, err := reader.connectionFromWhichToRead.Read(reader.readBuffer)
+	tap, maxPDULength := reader.settings()
+
+	if reader.buffer == nil {
+		reader.buffer = reader.acquireBuffer(DefaultReadBufferSize)
+	} else if reader.bufferEnd == len(reader.buffer) {
+		reader.makeRoomForPDU(0)
+	}
+
+	bytesRead, err := reader.connectionFromWhichToRead.Read(reader.buffer[reader.bufferEnd:])
 
 	if err != nil {
 		if err == io.EOF {
 			reader.attachedConnectionIsClosed = true
 		}
+		reader.releaseBufferIfEmpty()
 		return nil, err
 	}
 
-	reader.pduBuffer = append(reader.pduBuffer, reader.readBuffer[:bytesRead]...)
+	reader.bufferEnd += bytesRead
 
 	extractedPDUs := make([]*PDU, 0, 3)
 
-	for len(reader.pduBuffer) >= 16 {
-		pduLength := uint32(binary.BigEndian.Uint32(reader.pduBuffer[0:4]))
-
-		if len(reader.pduBuffer) >= int(pduLength) {
-			pdu, err := DecodePDU(reader.pduBuffer[:pduLength])
+	for reader.bufferEnd-reader.bufferStart >= 16 {
+		pending := reader.buffer[reader.bufferStart:reader.bufferEnd]
+		pduLength := int(binary.BigEndian.Uint32(pending[0:4]))
 
-			copy(reader.pduBuffer[0:len(reader.pduBuffer)-int(pduLength)], reader.pduBuffer[pduLength:])
-			reader.pduBuffer = reader.pduBuffer[:len(reader.pduBuffer)-int(pduLength)]
+		if pduLength > maxPDULength {
+			reader.bufferStart = reader.bufferEnd
+			reader.releaseBufferIfEmpty()
+			return extractedPDUs, &DecodeError{Err: fmt.Errorf("Stream length field value (%d) exceeds the maximum PDU length (%d)", pduLength, maxPDULength)}
+		}
 
-			if err != nil {
-				return extractedPDUs, err
+		if pduLength > len(pending) {
+			if reader.bufferStart+pduLength > len(reader.buffer) {
+				reader.makeRoomForPDU(pduLength)
 			}
+			break
+		}
+
+		if pduLength < 16 {
+			// the length is invalid, so let DecodePDU report the error, then discard the header
+			pduLength = 16
+		}
+
+		if tap != nil {
+			tap.TapPDU(DirectionInbound, pending[:pduLength], time.Now())
+		}
 
-			extractedPDUs = append(extractedPDUs, pdu)
-		} else {
-			return extractedPDUs, nil
+		pdu, err := decodePDU(pending[:pduLength], reader.lossless)
+
+		reader.bufferStart += pduLength
+
+		if err != nil {
+			reader.releaseBufferIfEmpty()
+			return extractedPDUs, &DecodeError{Err: err}
 		}
+
+		extractedPDUs = append(extractedPDUs, pdu)
 	}
 
+	reader.releaseBufferIfEmpty()
+
 	return extractedPDUs, nil
 }
 
+// ReadContext is the same as Read(), except that if 'ctx' is done before data arrive, the read is
+// interrupted and ctx.Err() is returned.  The read is interrupted by setting a read deadline in the past
+// on the connection, so any read deadline that the caller set is cleared when that happens.  No data
+// are lost: a later Read() continues from the same point in the stream.
+func (reader *NetworkStreamReader) ReadContext(ctx context.Context) ([]*PDU, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if ctx.Done() == nil {
+		return reader.Read()
+	}
+
+	readFinished := make(chan struct{})
+	watcherFinished := make(chan bool)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			reader.connectionFromWhichToRead.SetReadDeadline(time.Unix(1, 0))
+			watcherFinished <- true
+		case <-readFinished:
+			watcherFinished <- false
+		}
+	}()
+
+	pdus, err := reader.Read()
+	close(readFinished)
+
+	if interrupted := <-watcherFinished; interrupted {
+		reader.connectionFromWhichToRead.SetReadDeadline(time.Time{})
+
+		if netErr, isNetErr := err.(net.Error); isNetErr && netErr.Timeout() {
+			return pdus, ctx.Err()
+		}
+	}
+
+	return pdus, err
+}
+
 // ExtractNextPDUs repeatedly reads from the TCP stream until there is at least one PDU.
 // It returns the set of extracted PDUs, and like Read(), stores any remaining data for
 // subsequent calls
 func (reader *NetworkStreamReader) ExtractNextPDUs() ([]*PDU, error) {
+	return reader.ExtractNextPDUsContext(context.Background())
+}
+
+// ExtractNextPDUsContext is the same as ExtractNextPDUs(), except that it returns ctx.Err() if 'ctx'
+// is done before a PDU arrives
+func (reader *NetworkStreamReader) ExtractNextPDUsContext(ctx context.Context) ([]*PDU, error) {
 	for {
-		pdus, err := reader.Read()
+		pdus, err := reader.ReadContext(ctx)
 
 		if err != nil {
 			return nil, err
@@ -81,3 +230,61 @@ func (reader *NetworkStreamReader) ExtractNextPDUs() ([]*PDU, error) {
 func (reader *NetworkStreamReader) AttachedConnectionIsClosed() bool {
 	return reader.attachedConnectionIsClosed
 }
+
+// makeRoomForPDU moves the unprocessed data to the front of the buffer.  If the buffer cannot
+// hold a PDU of length 'pduLength', it is replaced by a larger one
+func (reader *NetworkStreamReader) makeRoomForPDU(pduLength int) {
+	pendingLength := reader.bufferEnd - reader.bufferStart
+
+	if pendingLength == len(reader.buffer) && pduLength <= len(reader.buffer) {
+		pduLength = 2 * len(reader.buffer)
+	}
+
+	if pduLength > len(reader.buffer) {
+		largerBuffer := reader.acquireBuffer(pduLength)
+		copy(largerBuffer, reader.buffer[reader.bufferStart:reader.bufferEnd])
+		reader.releaseBuffer()
+		reader.buffer = largerBuffer
+	} else if reader.bufferStart > 0 {
+		copy(reader.buffer, reader.buffer[reader.bufferStart:reader.bufferEnd])
+	}
+
+	reader.bufferStart = 0
+	reader.bufferEnd = pendingLength
+}
+
+func (reader *NetworkStreamReader) acquireBuffer(minimumSize int) []byte {
+	if minimumSize < DefaultReadBufferSize {
+		minimumSize = DefaultReadBufferSize
+	}
+
+	if reader.bufferPool != nil {
+		return reader.bufferPool.Get(minimumSize)
+	}
+
+	return make([]byte, minimumSize)
+}
+
+func (reader *NetworkStreamReader) releaseBuffer() {
+	if reader.bufferPool != nil {
+		reader.bufferPool.Put(reader.buffer)
+	}
+
+	reader.buffer = nil
+}
+
+// releaseBufferIfEmpty resets the offsets if there are no unprocessed data.  The buffer is
+// returned to the pool if there is one.  Without a pool, a buffer that grew to accommodate a
+// large PDU is dropped, so that the next Read() allocates one of the default size.
+func (reader *NetworkStreamReader) releaseBufferIfEmpty() {
+	if reader.bufferStart != reader.bufferEnd {
+		return
+	}
+
+	reader.bufferStart = 0
+	reader.bufferEnd = 0
+
+	if reader.bufferPool != nil || len(reader.buffer) > DefaultReadBufferSize {
+		reader.releaseBuffer()
+	}
+}
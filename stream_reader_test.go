@@ -1,6 +1,9 @@
 package smpp
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"net"
 	"testing"
@@ -219,3 +222,235 @@ func TestRemoteConnectionClose(t *testing.T) {
 		t.Errorf("Expected AttachedConnectionIsClosed() to be true after io.EOF error, but is false")
 	}
 }
+
+// streamFakeConn serves 'stream' in reads of at most 'chunkSize' octets, honoring the length
+// of the buffer passed to Read().  When the stream is exhausted, it starts again from the beginning.
+type streamFakeConn struct {
+	fakeNetConn
+	stream    []byte
+	offset    int
+	chunkSize int
+}
+
+func (conn *streamFakeConn) Read(b []byte) (int, error) {
+	if conn.offset == len(conn.stream) {
+		conn.offset = 0
+	}
+
+	n := len(conn.stream) - conn.offset
+	if n > conn.chunkSize {
+		n = conn.chunkSize
+	}
+
+	n = copy(b, conn.stream[conn.offset:conn.offset+n])
+	conn.offset += n
+
+	return n, nil
+}
+
+func buildLargeDataSm(payloadLength int) []byte {
+	payload := make([]byte, payloadLength)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	encoded, _ := NewPDU(CommandDataSm, 0, 7, []*Parameter{
+		NewCOctetStringParameter(""),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewCOctetStringParameter("1234"),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewCOctetStringParameter("5678"),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+		NewFLParameter(uint8(0)),
+	}, []*Parameter{
+		NewTLVParameter(0x0424, payload),
+	}).Encode()
+
+	return encoded
+}
+
+func TestPDULargerThanReadBuffer(t *testing.T) {
+	enquireLink := newFakeNetConn().enquireLink01Msg
+	largePDU := buildLargeDataSm(3 * DefaultReadBufferSize)
+
+	stream := append(append(append([]byte{}, enquireLink...), largePDU...), enquireLink...)
+
+	for _, pool := range []*BufferPool{nil, NewBufferPool()} {
+		conn := &streamFakeConn{stream: stream, chunkSize: 1000}
+		reader := NewNetworkStreamReaderWithBufferPool(conn, pool)
+
+		extracted := make([]*PDU, 0, 3)
+		for reads := 0; len(extracted) < 3 && reads < 100; reads++ {
+			pdus, err := reader.Read()
+
+			if err != nil {
+				t.Fatalf("Expected no error on Read(), but got error = (%s)", err)
+			}
+
+			extracted = append(extracted, pdus...)
+		}
+
+		if len(extracted) != 3 {
+			t.Fatalf("Expected three PDUs, got (%d)", len(extracted))
+		}
+
+		if extracted[1].CommandID != CommandDataSm || extracted[1].CommandLength != uint32(len(largePDU)) {
+			t.Errorf("Expected second PDU to be data-sm of length (%d), got (%s)", len(largePDU), extracted[1])
+		}
+
+		reEncoded, _ := extracted[1].Encode()
+		if !bytes.Equal(reEncoded, largePDU) {
+			t.Errorf("Re-encoded large data-sm does not match original encoding")
+		}
+
+		if reader.buffer != nil && (pool != nil || len(reader.buffer) > DefaultReadBufferSize) {
+			t.Errorf("Expected reader to release its buffer once it was empty")
+		}
+	}
+}
+
+func TestPDULargerThanMaximumIsRejected(t *testing.T) {
+	enquireLink := newFakeNetConn().enquireLink01Msg
+
+	// a header that claims almost 4 GiB must not be allocated for
+	hugeHeader := append([]byte{0xff, 0xff, 0xff, 0xf0}, enquireLink[4:16]...)
+	conn := &streamFakeConn{stream: append(append([]byte{}, enquireLink...), hugeHeader...), chunkSize: 1000}
+	reader := NewNetworkStreamReader(conn)
+
+	pdus, err := reader.Read()
+	if _, isDecodeError := err.(*DecodeError); !isDecodeError || len(pdus) != 1 {
+		t.Fatalf("Expected the enquire-link and a DecodeError, got (%d) PDUs and error = (%v)", len(pdus), err)
+	}
+	if len(reader.buffer) > DefaultReadBufferSize {
+		t.Errorf("Expected no buffer to be allocated for the oversized PDU, buffer is (%d) octets", len(reader.buffer))
+	}
+
+	// a message_payload of the largest length that its TLV can carry is accepted by default
+	maximumPayloadPDU := buildLargeDataSm(65535)
+	reader = NewNetworkStreamReader(&streamFakeConn{stream: maximumPayloadPDU, chunkSize: len(maximumPayloadPDU)})
+	pdus, err = nil, nil
+	for reads := 0; len(pdus) == 0 && err == nil && reads < 100; reads++ {
+		pdus, err = reader.Read()
+	}
+	if err != nil || len(pdus) != 1 {
+		t.Errorf("Expected the PDU of (%d) octets to be accepted by default, got (%d) PDUs and error = (%v)", len(maximumPayloadPDU), len(pdus), err)
+	}
+
+	largePDU := buildLargeDataSm(3 * DefaultReadBufferSize)
+	reader = NewNetworkStreamReader(&streamFakeConn{stream: largePDU, chunkSize: len(largePDU)})
+	reader.SetMaxPDULength(2 * DefaultReadBufferSize)
+
+	if _, err := reader.Read(); err == nil {
+		t.Errorf("Expected error for PDU of (%d) octets over a maximum of (%d)", len(largePDU), 2*DefaultReadBufferSize)
+	}
+}
+
+func TestDecodedPDUsDoNotShareReadBuffer(t *testing.T) {
+	largePDU := buildLargeDataSm(100)
+	conn := &streamFakeConn{stream: largePDU, chunkSize: len(largePDU)}
+	reader := NewNetworkStreamReaderWithBufferPool(conn, NewBufferPool())
+
+	pdus, err := reader.Read()
+	if err != nil || len(pdus) != 1 {
+		t.Fatalf("Expected one PDU and no error on Read(), got (%d) PDUs and error = (%v)", len(pdus), err)
+	}
+
+	conn.stream = bytes.Repeat([]byte{0xff}, len(largePDU))
+	reader.buffer = nil
+	_, _ = reader.Read()
+
+	reEncoded, _ := pdus[0].Encode()
+	if !bytes.Equal(reEncoded, largePDU) {
+		t.Errorf("PDU changed after its read buffer was reused")
+	}
+}
+
+func BenchmarkReadManySmallPDUsPerRead(b *testing.B) {
+	enquireLink := newFakeNetConn().enquireLink01Msg
+	stream := bytes.Repeat(enquireLink, 65536/len(enquireLink))
+
+	for _, size := range []int{DefaultReadBufferSize, 65536} {
+		b.Run(fmt.Sprintf("read-%d", size), func(b *testing.B) {
+			conn := &streamFakeConn{stream: stream, chunkSize: size}
+			reader := NewNetworkStreamReader(conn)
+
+			b.ReportAllocs()
+			b.SetBytes(int64(len(stream)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				for extracted := 0; extracted < len(stream)/len(enquireLink); {
+					pdus, err := reader.Read()
+
+					if err != nil {
+						b.Fatalf("Read() error = (%s)", err)
+					}
+
+					extracted += len(pdus)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkManyIdleConnections(b *testing.B) {
+	const connectionCount = 10000
+	enquireLink := newFakeNetConn().enquireLink01Msg
+
+	for _, pool := range []*BufferPool{nil, NewBufferPool()} {
+		name := "unpooled"
+		if pool != nil {
+			name = "pooled"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				readers := make([]*NetworkStreamReader, connectionCount)
+				retainedBytes := 0
+
+				for c := range readers {
+					readers[c] = NewNetworkStreamReaderWithBufferPool(&streamFakeConn{stream: enquireLink, chunkSize: 16}, pool)
+
+					if _, err := readers[c].Read(); err != nil {
+						b.Fatalf("Read() error = (%s)", err)
+					}
+
+					retainedBytes += len(readers[c].buffer)
+				}
+
+				b.ReportMetric(float64(retainedBytes)/connectionCount, "idle-bytes/conn")
+			}
+		})
+	}
+}
+
+func TestReadContextIsInterruptedWithoutLosingData(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	reader := NewNetworkStreamReader(local)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := reader.ReadContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded from ReadContext() with no data, got (%v)", err)
+	}
+
+	go remote.Write(newFakeNetConn().enquireLink01Msg)
+
+	pdus, err := reader.ExtractNextPDUsContext(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error on ExtractNextPDUsContext() after interrupted read, but got error = (%s)", err)
+	}
+
+	if len(pdus) != 1 || pdus[0].CommandID != CommandEnquireLink || pdus[0].SequenceNumber != 2 {
+		t.Errorf("Expected enquire-link with sequence 2 after interrupted read, got (%v)", pdus)
+	}
+}
@@ -0,0 +1,66 @@
+package smpp
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// NetworkStreamWriter provides a mechanism for writing PDUs to an outgoing TCP stream connection.
+// It is safe for concurrent use; each PDU is written to the connection as a unit
+type NetworkStreamWriter struct {
+	connectionToWhichToWrite net.Conn
+	tap                      PDUTap
+	mutex                    sync.Mutex
+}
+
+// NewNetworkStreamWriter creates a NetworkStreamWriter that operates on the identified connection
+func NewNetworkStreamWriter(toConnection net.Conn) *NetworkStreamWriter {
+	return &NetworkStreamWriter{connectionToWhichToWrite: toConnection}
+}
+
+// SetTap attaches a PDUTap, which receives each PDU after it is written.  Set to nil
+// to detach the current tap
+func (writer *NetworkStreamWriter) SetTap(tap PDUTap) {
+	writer.mutex.Lock()
+	writer.tap = tap
+	writer.mutex.Unlock()
+}
+
+// Write encodes the PDU and writes it to the associated TCP stream
+func (writer *NetworkStreamWriter) Write(pdu *PDU) error {
+	encoded, err := pdu.Encode()
+
+	if err != nil {
+		return err
+	}
+
+	return writer.WriteEncoded(encoded)
+}
+
+// WriteEncoded writes an already encoded PDU to the associated TCP stream
+func (writer *NetworkStreamWriter) WriteEncoded(encoded []byte) error {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+
+	for written := 0; written < len(encoded); {
+		n, err := writer.connectionToWhichToWrite.Write(encoded[written:])
+
+		if err != nil {
+			return err
+		}
+
+		if n == 0 {
+			return io.ErrShortWrite
+		}
+
+		written += n
+	}
+
+	if writer.tap != nil {
+		writer.tap.TapPDU(DirectionOutbound, encoded, time.Now())
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package smpp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// These are the values of dest_flag, which begins each entry of the dest_address list of a submit_multi
+const (
+	destFlagSMEAddress       = 1
+	destFlagDistributionList = 2
+)
+
+// DestinationAddress is one entry of the dest_address list of a submit_multi.  If DistributionList is
+// not empty, the entry is the name of a distribution list, and the other fields are not encoded;
+// otherwise it is an SME address.
+type DestinationAddress struct {
+	AddrTON          uint8
+	AddrNPI          uint8
+	Address          string
+	DistributionList string
+}
+
+// UnsuccessfulSME is one entry of the unsuccess_sme list of a submit_multi_resp: a destination to which
+// the message could not be submitted, and the command_status that says why
+type UnsuccessfulSME struct {
+	AddrTON         uint8
+	AddrNPI         uint8
+	Address         string
+	ErrorStatusCode uint32
+}
+
+// NewDestinationAddressListParameter creates the dest_address Parameter of a submit_multi.  It must be
+// preceded in the PDU by number_of_dests, with the value len(addresses).
+func NewDestinationAddressListParameter(addresses []DestinationAddress) *Parameter {
+	length := uint32(0)
+	for _, address := range addresses {
+		if address.DistributionList != "" {
+			length += 1 + uint32(len(address.DistributionList)) + 1
+		} else {
+			length += 3 + uint32(len(address.Address)) + 1
+		}
+	}
+
+	return &Parameter{TypeDestinationAddressList, length, addresses}
+}
+
+// NewUnsuccessfulSMEListParameter creates the unsuccess_sme Parameter of a submit_multi_resp.  It must
+// be preceded in the PDU by no_unsuccess, with the value len(smes).
+func NewUnsuccessfulSMEListParameter(smes []UnsuccessfulSME) *Parameter {
+	length := uint32(0)
+	for _, sme := range smes {
+		length += 2 + uint32(len(sme.Address)) + 1 + 4
+	}
+
+	return &Parameter{TypeUnsuccessfulSMEList, length, smes}
+}
+
+func encodeDestinationAddresses(addresses []DestinationAddress, length uint32) []byte {
+	encoded := make([]byte, 0, length)
+
+	for _, address := range addresses {
+		if address.DistributionList != "" {
+			encoded = append(encoded, destFlagDistributionList)
+			encoded = append(append(encoded, address.DistributionList...), 0)
+		} else {
+			encoded = append(encoded, destFlagSMEAddress, address.AddrTON, address.AddrNPI)
+			encoded = append(append(encoded, address.Address...), 0)
+		}
+	}
+
+	return encoded
+}
+
+func encodeUnsuccessfulSMEs(smes []UnsuccessfulSME, length uint32) []byte {
+	encoded := make([]byte, 0, length)
+
+	for _, sme := range smes {
+		encoded = append(encoded, sme.AddrTON, sme.AddrNPI)
+		encoded = append(append(encoded, sme.Address...), 0)
+		encoded = append(encoded, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(encoded[len(encoded)-4:], sme.ErrorStatusCode)
+	}
+
+	return encoded
+}
+
+// decodeAddressList decodes 'count' entries of a dest_address (TypeDestinationAddressList) or
+// unsuccess_sme (TypeUnsuccessfulSMEList) list from the start of 'stream'.  It returns the Parameter and
+// the number of octets that it occupies.
+func decodeAddressList(listType ParameterType, stream []byte, count uint8) (*Parameter, int, error) {
+	s := 0
+
+	nextCOctetString := func() (string, error) {
+		nullOffset := bytes.IndexByte(stream[s:], 0)
+		if nullOffset < 0 {
+			return "", fmt.Errorf("Require C-String-Octet type but failed to find null terminator")
+		}
+		value := string(stream[s : s+nullOffset])
+		s += nullOffset + 1
+		return value, nil
+	}
+
+	if listType == TypeDestinationAddressList {
+		addresses := make([]DestinationAddress, 0, count)
+
+		for i := 0; i < int(count); i++ {
+			if s >= len(stream) {
+				return nil, 0, fmt.Errorf("dest_address list has fewer than number_of_dests (%d) entries", count)
+			}
+
+			address := DestinationAddress{}
+			flag := stream[s]
+			s++
+
+			switch flag {
+			case destFlagSMEAddress:
+				if s+2 > len(stream) {
+					return nil, 0, fmt.Errorf("dest_address entry (%d) extends beyond end of PDU", i)
+				}
+				address.AddrTON, address.AddrNPI = stream[s], stream[s+1]
+				s += 2
+
+				value, err := nextCOctetString()
+				if err != nil {
+					return nil, 0, err
+				}
+				address.Address = value
+
+			case destFlagDistributionList:
+				value, err := nextCOctetString()
+				if err != nil {
+					return nil, 0, err
+				}
+				address.DistributionList = value
+
+			default:
+				return nil, 0, fmt.Errorf("dest_address entry (%d) has unknown dest_flag (%d)", i, flag)
+			}
+
+			addresses = append(addresses, address)
+		}
+
+		return NewDestinationAddressListParameter(addresses), s, nil
+	}
+
+	smes := make([]UnsuccessfulSME, 0, count)
+
+	for i := 0; i < int(count); i++ {
+		if s+2 > len(stream) {
+			return nil, 0, fmt.Errorf("unsuccess_sme list has fewer than no_unsuccess (%d) entries", count)
+		}
+
+		sme := UnsuccessfulSME{AddrTON: stream[s], AddrNPI: stream[s+1]}
+		s += 2
+
+		value, err := nextCOctetString()
+		if err != nil {
+			return nil, 0, err
+		}
+		sme.Address = value
+
+		if s+4 > len(stream) {
+			return nil, 0, fmt.Errorf("unsuccess_sme entry (%d) extends beyond end of PDU", i)
+		}
+		sme.ErrorStatusCode = binary.BigEndian.Uint32(stream[s : s+4])
+		s += 4
+
+		smes = append(smes, sme)
+	}
+
+	return NewUnsuccessfulSMEListParameter(smes), s, nil
+}
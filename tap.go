@@ -0,0 +1,39 @@
+package smpp
+
+import "time"
+
+// PDUDirection indicates whether a PDU was sent or received by the local entity
+type PDUDirection int
+
+// These are the possible PDU directions
+const (
+	DirectionOutbound PDUDirection = iota
+	DirectionInbound
+)
+
+// String returns "outbound" or "inbound"
+func (direction PDUDirection) String() string {
+	if direction == DirectionInbound {
+		return "inbound"
+	}
+
+	return "outbound"
+}
+
+// PDUTap receives a copy of every PDU that passes through a NetworkStreamReader or
+// NetworkStreamWriter to which it is attached.  'encoded' is the PDU exactly as it
+// appeared on the wire.  It is only valid for the duration of the call, so an
+// implementation that retains it must copy it.  TapPDU must not block.
+type PDUTap interface {
+	TapPDU(direction PDUDirection, encoded []byte, timestamp time.Time)
+}
+
+// MultiTap is a PDUTap that passes each PDU to every member PDUTap, in order
+type MultiTap []PDUTap
+
+// TapPDU passes the PDU to each member of the MultiTap
+func (taps MultiTap) TapPDU(direction PDUDirection, encoded []byte, timestamp time.Time) {
+	for _, tap := range taps {
+		tap.TapPDU(direction, encoded, timestamp)
+	}
+}
@@ -0,0 +1,179 @@
+package smpp
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TimeoutAction is what a session does when a request's response timeout expires
+type TimeoutAction int
+
+const (
+	// FailOnTimeout completes the request's ResponseFuture with ErrResponseTimeout
+	FailOnTimeout TimeoutAction = iota
+	// RetryOnTimeout resends the request with a new sequence number, up to MaxRetries times, then fails it
+	RetryOnTimeout
+	// DisconnectOnTimeout fails the request, then treats the connection as broken: the transport is
+	// closed, and the session moves to StateClosed
+	DisconnectOnTimeout
+)
+
+// TimeoutPolicy describes the handling of a request whose response does not arrive in time.  The zero
+// value is FailOnTimeout.
+type TimeoutPolicy struct {
+	Action     TimeoutAction
+	MaxRetries int
+}
+
+// RequestOptions override session settings for a single request.  A zero Timeout means the timeout for
+// the request's command (or the session response timeout), and a nil TimeoutPolicy means the session
+// timeout policy.
+type RequestOptions struct {
+	Timeout       time.Duration
+	TimeoutPolicy *TimeoutPolicy
+}
+
+// maximumExpiredSequences limits the number of timed-out sequence numbers that a session remembers in
+// order to recognize late responses
+const maximumExpiredSequences = 1024
+
+// SetCommandResponseTimeout sets the response timeout for requests with the command 'commandID', in
+// place of the session response timeout.  A timeout of zero removes the command-specific timeout.
+func (peer *Peer) SetCommandResponseTimeout(commandID CommandIDType, timeout time.Duration) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	if timeout == 0 {
+		delete(peer.commandTimeouts, commandID)
+	} else {
+		peer.commandTimeouts[commandID] = timeout
+	}
+}
+
+// SetTimeoutPolicy sets the handling of requests whose response does not arrive in time.  The default
+// is FailOnTimeout.
+func (peer *Peer) SetTimeoutPolicy(policy TimeoutPolicy) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	peer.timeoutPolicy = policy
+}
+
+// SendRequestWithOptions is the same as SendRequest(), except that the response timeout or the timeout
+// policy may be set for this request only
+func (peer *Peer) SendRequestWithOptions(request *PDU, options RequestOptions) (*ResponseFuture, error) {
+	return peer.sendRequest(request, requestOptions{blockIfWindowIsFull: true, timeout: options.Timeout, policy: options.TimeoutPolicy})
+}
+
+// SendRequestWithOptionsContext is the same as SendRequestWithOptions(), except that if 'ctx' is done
+// while it is blocked on a full window, it returns ctx.Err(), and nothing is written
+func (peer *Peer) SendRequestWithOptionsContext(ctx context.Context, request *PDU, options RequestOptions) (*ResponseFuture, error) {
+	return peer.sendRequest(request, requestOptions{ctx: ctx, blockIfWindowIsFull: true, timeout: options.Timeout, policy: options.TimeoutPolicy})
+}
+
+// responseTimeoutFor returns the timeout for a request, given the timeout requested for the call.  The
+// caller must hold the peer mutex.
+func (peer *Peer) responseTimeoutFor(commandID CommandIDType, callTimeout time.Duration) time.Duration {
+	if callTimeout > 0 {
+		return callTimeout
+	}
+
+	if commandTimeout, isSet := peer.commandTimeouts[commandID]; isSet {
+		return commandTimeout
+	}
+
+	return peer.responseTimeout
+}
+
+func (peer *Peer) startResponseTimer(future *ResponseFuture) {
+	future.mutex.Lock()
+	defer future.mutex.Unlock()
+
+	future.timer = time.AfterFunc(future.timeout, func() {
+		peer.requestTimedOut(future)
+	})
+}
+
+// requestTimedOut applies the future's timeout policy when its response timeout expires
+func (peer *Peer) requestTimedOut(future *ResponseFuture) {
+	peer.mutex.Lock()
+
+	sequenceNumber := future.request.SequenceNumber
+	if peer.outstanding[sequenceNumber] != future {
+		peer.mutex.Unlock()
+		return
+	}
+
+	delete(peer.outstanding, sequenceNumber)
+	peer.rememberExpiredSequence(sequenceNumber)
+
+	if future.policy.Action == RetryOnTimeout && future.retries < future.policy.MaxRetries && peer.state != StateClosed {
+		future.retries++
+		future.request.SequenceNumber = peer.allocateUnusedSequenceNumber()
+		peer.outstanding[future.request.SequenceNumber] = future
+		future.sent = time.Now()
+		peer.mutex.Unlock()
+
+		peer.reportTimeout(future, sequenceNumber)
+
+		peer.startResponseTimer(future)
+
+		if err := peer.writePDU(future.request, true); err != nil {
+			peer.removeOutstanding(future)
+			future.complete(nil, err)
+		}
+		return
+	}
+
+	peer.releaseWindow()
+	peer.mutex.Unlock()
+
+	peer.reportTimeout(future, sequenceNumber)
+
+	if future.policy.Action == DisconnectOnTimeout {
+		peer.closeSession(fmt.Errorf("%w: %s (sequence %d)", ErrResponseTimeout, future.request.CommandName(), sequenceNumber))
+		peer.connectionToRemotePeer.Close()
+	}
+
+	future.complete(nil, ErrResponseTimeout)
+}
+
+// reportTimeout logs and counts a request that timed out
+func (peer *Peer) reportTimeout(future *ResponseFuture, sequenceNumber uint32) {
+	peer.currentMetrics().countTimeout(future.request.CommandID)
+	peer.log(LogEventTimeout, "Request timed out", LogField{"command", future.request.CommandName()}, LogField{"sequence", sequenceNumber}, LogField{"timeout", future.timeout.String()})
+}
+
+// rememberExpiredSequence records a sequence number whose request timed out, forgetting the oldest
+// one if the limit is reached.  The caller must hold the peer mutex.
+func (peer *Peer) rememberExpiredSequence(sequenceNumber uint32) {
+	if len(peer.expiredOrder) == maximumExpiredSequences {
+		delete(peer.expiredSequences, peer.expiredOrder[0])
+		peer.expiredOrder = peer.expiredOrder[1:]
+	}
+
+	peer.expiredSequences[sequenceNumber] = true
+	peer.expiredOrder = append(peer.expiredOrder, sequenceNumber)
+}
+
+// takeExpiredSequence returns true if the sequence number belongs to a request that timed out, and
+// forgets it
+func (peer *Peer) takeExpiredSequence(sequenceNumber uint32) bool {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	if !peer.expiredSequences[sequenceNumber] {
+		return false
+	}
+
+	delete(peer.expiredSequences, sequenceNumber)
+	for i, expired := range peer.expiredOrder {
+		if expired == sequenceNumber {
+			peer.expiredOrder = append(peer.expiredOrder[:i], peer.expiredOrder[i+1:]...)
+			break
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,92 @@
+package smpp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+)
+
+// ConnectToPeerTLS connects a TLS transport to a remote peer.  'config' supplies everything about the
+// TLS session: the root CAs with which to verify the SMSC, the client certificate for mutual TLS (in
+// Certificates), and the name to send with SNI and to verify (in ServerName).  If ServerName is empty,
+// the name is taken from 'remoteAddr', which, because it is an IP address, means that no SNI is sent.
+func (esme *ESME) ConnectToPeerTLS(remoteAddr net.IP, remotePort uint16, config *tls.Config) (peer *Peer, err error) {
+	return esme.ConnectToPeerTLSContext(context.Background(), remoteAddr, remotePort, config)
+}
+
+// ConnectToPeerTLSContext is the same as ConnectToPeerTLS(), except that the dial and the TLS handshake
+// are aborted if 'ctx' is done before they complete
+func (esme *ESME) ConnectToPeerTLSContext(ctx context.Context, remoteAddr net.IP, remotePort uint16, config *tls.Config) (peer *Peer, err error) {
+	dialer := &tls.Dialer{Config: config}
+	address := (&net.TCPAddr{IP: remoteAddr, Port: int(remotePort), Zone: ""}).String()
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+
+	if err != nil {
+		esme.connectFailed(address, err)
+		return nil, err
+	}
+
+	return esme.connected(conn), nil
+}
+
+// ListenAndServeTLS listens on the TCP address 'address', then calls ServeTLS()
+func (smsc *SMSC) ListenAndServeTLS(address string, config *tls.Config) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	return smsc.ServeTLS(listener, config)
+}
+
+// ServeTLS is the same as Serve(), except that each connection is a TLS server connection configured by
+// 'config', which must have at least one certificate in Certificates (or GetCertificate).  For mutual
+// TLS, set ClientAuth to tls.RequireAndVerifyClientCert and ClientCAs to the CAs that issue ESME
+// certificates.  The negotiated TLS state is passed to the Authenticator in BindRequest.TLS.
+func (smsc *SMSC) ServeTLS(listener net.Listener, config *tls.Config) error {
+	return smsc.Serve(tls.NewListener(listener, config))
+}
+
+// TLSConnectionState returns the negotiated TLS state of the session, or nil if the transport is not
+// TLS.  The state is complete once the first PDU has been received.
+func (peer *Peer) TLSConnectionState() *tls.ConnectionState {
+	tlsConn, isTLS := peer.connectionToRemotePeer.(*tls.Conn)
+	if !isTLS {
+		return nil
+	}
+
+	state := tlsConn.ConnectionState()
+
+	return &state
+}
+
+// CertificateFingerprint returns the SHA-256 digest of the DER encoding of a certificate, which is the
+// value used for certificate pinning
+func CertificateFingerprint(certificate *x509.Certificate) [sha256.Size]byte {
+	return sha256.Sum256(certificate.Raw)
+}
+
+// PinnedCertificates returns a function for tls.Config.VerifyPeerCertificate that accepts the remote
+// peer only if its certificate (the first in the chain it presents) has one of the SHA-256 fingerprints.
+// The function runs after normal certificate verification, so to pin a self-signed certificate, set
+// InsecureSkipVerify as well, and the pin becomes the only check.
+func PinnedCertificates(fingerprints ...[sha256.Size]byte) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("Peer presented no certificate")
+		}
+
+		fingerprint := sha256.Sum256(rawCerts[0])
+		for _, pinned := range fingerprints {
+			if bytes.Equal(fingerprint[:], pinned[:]) {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("Peer certificate with SHA-256 fingerprint (%x) is not pinned", fingerprint)
+	}
+}
@@ -0,0 +1,185 @@
+package smpp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCertificate is a generated certificate and its key
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+// newTestCertificate generates a certificate for 'commonName' and 'dnsNames', signed by 'issuer', or
+// self-signed (as a CA) if 'issuer' is nil
+func newTestCertificate(t *testing.T, commonName string, dnsNames []string, issuer *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	parent, signingKey := template, key
+	if issuer == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		parent, signingKey = issuer.certificate, issuer.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signingKey)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %s", err)
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %s", err)
+	}
+
+	return &testCertificate{certificate: certificate, key: key}
+}
+
+func (generated *testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{generated.certificate.Raw}, PrivateKey: generated.key, Leaf: generated.certificate}
+}
+
+func (generated *testCertificate) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(generated.certificate)
+	return pool
+}
+
+// startTLSSMSC serves 'smsc' over TLS on a loopback listener
+func startTLSSMSC(t *testing.T, smsc *SMSC, config *tls.Config) *net.TCPAddr {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on loopback: %s", err)
+	}
+
+	go smsc.ServeTLS(listener, config)
+
+	return listener.Addr().(*net.TCPAddr)
+}
+
+func TestMutualTLSBindExposesStateToAuthenticator(t *testing.T) {
+	ca := newTestCertificate(t, "Test CA", nil, nil)
+	server := newTestCertificate(t, "smsc01", []string{"smsc.example.test"}, ca)
+	client := newTestCertificate(t, "esme01", nil, ca)
+
+	binds := make(chan *BindRequest, 1)
+	smsc := NewSMSC("smsc01", AuthenticatorFunc(func(request *BindRequest) uint32 {
+		binds <- request
+		if request.TLS == nil || len(request.TLS.PeerCertificates) == 0 || request.TLS.PeerCertificates[0].Subject.CommonName != request.SystemID {
+			return EsmeRInvSysID
+		}
+		return EsmeROK
+	}), BaseSMSCHandler{})
+	defer smsc.Shutdown(context.Background())
+
+	smscAddr := startTLSSMSC(t, smsc, &tls.Config{
+		Certificates: []tls.Certificate{server.tlsCertificate()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.pool(),
+	})
+
+	esme := &ESME{}
+	peer, err := esme.ConnectToPeerTLS(smscAddr.IP, uint16(smscAddr.Port), &tls.Config{
+		Certificates: []tls.Certificate{client.tlsCertificate()},
+		RootCAs:      ca.pool(),
+		ServerName:   "smsc.example.test",
+	})
+	if err != nil {
+		t.Fatalf("Expected no error on ConnectToPeerTLS(), but got error = (%s)", err)
+	}
+
+	if _, _, err := esme.BindToPeer(peer, BindInfo{Type: TransceiverBind, SystemID: "esme01"}); err != nil {
+		t.Fatalf("Expected no error on BindToPeer() over TLS, but got error = (%s)", err)
+	}
+
+	bind := <-binds
+	if bind.TLS.ServerName != "smsc.example.test" {
+		t.Errorf("Expected authenticator to see SNI name (smsc.example.test), got (%s)", bind.TLS.ServerName)
+	}
+
+	if state := peer.TLSConnectionState(); state == nil || state.PeerCertificates[0].Subject.CommonName != "smsc01" {
+		t.Errorf("Expected ESME session TLS state with SMSC certificate, got (%v)", state)
+	}
+
+	if err := peer.SetTCPKeepAlive(time.Minute); err != nil {
+		t.Errorf("Expected TCP options to apply beneath TLS, got error = (%s)", err)
+	}
+
+	future, err := peer.SendRequest(newShortMessagePDU(CommandSubmitSm, 0, "over tls"))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	if response, err := future.Wait(); err != nil || response.CommandID != CommandSubmitSmResp {
+		t.Errorf("Expected submit-sm-resp over TLS, got (%v), error = (%v)", response, err)
+	}
+
+	// without a client certificate, the SMSC refuses the handshake
+	unauthenticated, err := esme.ConnectToPeerTLS(smscAddr.IP, uint16(smscAddr.Port), &tls.Config{RootCAs: ca.pool(), ServerName: "smsc.example.test"})
+	if err == nil {
+		if _, _, err = esme.BindToPeer(unauthenticated, BindInfo{Type: TransceiverBind, SystemID: "esme01"}); err == nil {
+			t.Errorf("Expected bind without client certificate to fail, but it succeeded")
+		}
+	}
+}
+
+func TestPinnedCertificates(t *testing.T) {
+	selfSigned := newTestCertificate(t, "smsc01", []string{"smsc.example.test"}, nil)
+	other := newTestCertificate(t, "other", nil, nil)
+
+	smsc := NewSMSC("smsc01", nil, BaseSMSCHandler{})
+	defer smsc.Shutdown(context.Background())
+
+	smscAddr := startTLSSMSC(t, smsc, &tls.Config{Certificates: []tls.Certificate{selfSigned.tlsCertificate()}})
+
+	esme := &ESME{}
+
+	for _, testCase := range []struct {
+		pinned     *testCertificate
+		shouldBind bool
+	}{
+		{selfSigned, true},
+		{other, false},
+	} {
+		config := &tls.Config{
+			InsecureSkipVerify:    true,
+			VerifyPeerCertificate: PinnedCertificates(CertificateFingerprint(testCase.pinned.certificate)),
+		}
+
+		peer, err := esme.ConnectToPeerTLS(smscAddr.IP, uint16(smscAddr.Port), config)
+		if testCase.shouldBind {
+			if err != nil {
+				t.Fatalf("Expected no error on ConnectToPeerTLS() with pinned certificate, but got error = (%s)", err)
+			}
+			if _, _, err := esme.BindToPeer(peer, BindInfo{Type: TransmitterBind, SystemID: "esme01"}); err != nil {
+				t.Errorf("Expected no error on BindToPeer() with pinned certificate, but got error = (%s)", err)
+			}
+		} else if err == nil {
+			t.Errorf("Expected error on ConnectToPeerTLS() with certificate that is not pinned, but got none")
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package smpp
+
+import (
+	"net"
+	"time"
+)
+
+// DefaultTCPKeepAlivePeriod is the TCP keepalive period that a session sets on its transport, when the
+// transport is TCP (directly, or beneath TLS)
+const DefaultTCPKeepAlivePeriod = 30 * time.Second
+
+// SetTCPKeepAlive sets the TCP keepalive period of the session's transport.  A period of zero disables
+// TCP keepalive.  ErrTransportNotTCP is returned if the transport is not TCP (e.g., a Unix domain socket
+// or net.Pipe()).  TCP keepalive is independent of the enquire_link keepalive.
+func (peer *Peer) SetTCPKeepAlive(period time.Duration) error {
+	tcpConn := underlyingTCPConn(peer.connectionToRemotePeer)
+	if tcpConn == nil {
+		return ErrTransportNotTCP
+	}
+
+	if period == 0 {
+		return tcpConn.SetKeepAlive(false)
+	}
+
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		return err
+	}
+
+	return tcpConn.SetKeepAlivePeriod(period)
+}
+
+// SetTCPNoDelay sets TCP_NODELAY on the session's transport, which a session enables by default.
+// ErrTransportNotTCP is returned if the transport is not TCP.
+func (peer *Peer) SetTCPNoDelay(noDelay bool) error {
+	tcpConn := underlyingTCPConn(peer.connectionToRemotePeer)
+	if tcpConn == nil {
+		return ErrTransportNotTCP
+	}
+
+	return tcpConn.SetNoDelay(noDelay)
+}
+
+// underlyingTCPConn returns the TCP connection that carries 'conn', or nil if there is none.  A TLS
+// connection is unwrapped to the connection beneath it.
+func underlyingTCPConn(conn net.Conn) *net.TCPConn {
+	for {
+		switch c := conn.(type) {
+		case *net.TCPConn:
+			return c
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		default:
+			return nil
+		}
+	}
+}
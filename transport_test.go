@@ -0,0 +1,98 @@
+package smpp
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// bindAndSubmitOrFail binds 'peer' as a transceiver and sends a submit_sm, failing the test if either
+// does not succeed
+func bindAndSubmitOrFail(t *testing.T, esme *ESME, peer *Peer) {
+	if _, _, err := esme.BindToPeer(peer, BindInfo{Type: TransceiverBind, SystemID: "esme01"}); err != nil {
+		t.Fatalf("Expected no error on BindToPeer(), but got error = (%s)", err)
+	}
+
+	future, err := peer.SendRequest(newShortMessagePDU(CommandSubmitSm, 0, "hello"))
+	if err != nil {
+		t.Fatalf("Expected no error on SendRequest(), but got error = (%s)", err)
+	}
+
+	if response, err := future.Wait(); err != nil || response.CommandID != CommandSubmitSmResp {
+		t.Fatalf("Expected submit-sm-resp, got (%v), error = (%v)", response, err)
+	}
+}
+
+func TestSessionOverNetPipe(t *testing.T) {
+	smsc := NewSMSC("smsc01", nil, BaseSMSCHandler{})
+	defer smsc.Shutdown(context.Background())
+
+	esmeEnd, smscEnd := net.Pipe()
+
+	served := make(chan error, 1)
+	go func() { served <- smsc.ServeConnection(smscEnd) }()
+
+	esme := &ESME{}
+	peer := NewPeerWithConnection(esmeEnd)
+
+	bindAndSubmitOrFail(t, esme, peer)
+
+	if err := peer.SetTCPKeepAlive(time.Minute); err != ErrTransportNotTCP {
+		t.Errorf("Expected ErrTransportNotTCP from SetTCPKeepAlive() on net.Pipe(), got (%v)", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := peer.Close(ctx); err != nil {
+		t.Errorf("Expected no error on Close(), but got error = (%s)", err)
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected no error from ServeConnection(), got (%s)", err)
+		}
+	case <-ctx.Done():
+		t.Errorf("Timed out waiting for ServeConnection() to return")
+	}
+}
+
+func TestSessionOverUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smsc.sock")
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("Unix domain sockets are not available: %s", err)
+	}
+
+	smsc := NewSMSC("smsc01", nil, BaseSMSCHandler{})
+	defer smsc.Shutdown(context.Background())
+	go smsc.Serve(listener)
+
+	esme := &ESME{}
+	peer, err := esme.ConnectToPeerAddress("unix", path)
+	if err != nil {
+		t.Fatalf("Expected no error on ConnectToPeerAddress(), but got error = (%s)", err)
+	}
+
+	bindAndSubmitOrFail(t, esme, peer)
+
+	if err := peer.SetTCPNoDelay(true); err != ErrTransportNotTCP {
+		t.Errorf("Expected ErrTransportNotTCP from SetTCPNoDelay() on Unix domain socket, got (%v)", err)
+	}
+}
+
+func TestTCPOptionsOnTCPTransport(t *testing.T) {
+	peer, _ := newLoopbackPeer(t, roleESME)
+
+	if err := peer.SetTCPKeepAlive(10 * time.Second); err != nil {
+		t.Errorf("Expected no error on SetTCPKeepAlive() over TCP, but got error = (%s)", err)
+	}
+
+	if err := peer.SetTCPNoDelay(false); err != nil {
+		t.Errorf("Expected no error on SetTCPNoDelay() over TCP, but got error = (%s)", err)
+	}
+}